@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"encoding/json"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/primitives"
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrInvalidAddress = errors.New("Invalid address")
+	ErrWrongNetwork   = errors.New("Address is not for this network")
+
+	// Valid address of a type the index doesn't track (i.e. segwit)
+	ErrUnsupportedAddress = errors.New("Address type not supported")
+)
+
+// Error codes for the structured address errors
+const (
+	InvalidAddressCode     = "invalid_address"
+	WrongNetworkCode       = "wrong_network"
+	UnsupportedAddressCode = "unsupported_address"
+)
+
+// Networks tried to tell addresses for other networks from invalid ones
+var knownNetworks = []*chaincfg.Params {
+	&chaincfg.MainNetParams,
+	&chaincfg.TestNet3Params,
+	&chaincfg.RegressionNetParams,
+	&chaincfg.SimNetParams,
+}
+
+// normalizeAddress validates address for the chain in
+// primitives.DefaultChainParams and returns it with the same encoding used by
+// the indexed outputs (i.e. pubkeys as their p2pkh address). Only the address
+// types produced by primitives.PkScriptToAddr are accepted, the remaining
+// ones would always have zero balance.
+func normalizeAddress(address string) (string, error) {
+	decoded, err := btcutil.DecodeAddress(address, primitives.DefaultChainParams)
+	if err == nil && decoded.IsForNet(primitives.DefaultChainParams) {
+		switch decoded.(type) {
+		case *btcutil.AddressPubKeyHash, *btcutil.AddressScriptHash, *btcutil.AddressPubKey:
+			return decoded.EncodeAddress(), nil
+		default:
+			return "", ErrUnsupportedAddress
+		}
+	}
+
+	for _, params := range knownNetworks {
+		if params == primitives.DefaultChainParams {
+			continue
+		}
+		if decoded, err := btcutil.DecodeAddress(address, params); err == nil && decoded.IsForNet(params) {
+			return "", ErrWrongNetwork
+		}
+	}
+	return "", ErrInvalidAddress
+}
+
+// addressErrorCode returns the structured error code for an address error
+func addressErrorCode(err error) string {
+	switch err {
+	case ErrWrongNetwork:
+		return WrongNetworkCode
+	case ErrUnsupportedAddress:
+		return UnsupportedAddressCode
+	default:
+		return InvalidAddressCode
+	}
+}
+
+// addressParam returns the normalized address from the request url, replying
+// with a structured 400 error when it isn't valid.
+func addressParam(writer http.ResponseWriter, request *http.Request) (string, bool) {
+	address := mux.Vars(request)["address"]
+	normalized, err := normalizeAddress(address)
+	if err != nil {
+		response := api_common.Error {
+			Error:   err.Error(),
+			Code:    addressErrorCode(err),
+			Address: address,
+		}
+		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		writer.Header().Set("X-Content-Type-Options", "nosniff")
+		writer.WriteHeader(http.StatusBadRequest)
+		if err := json.NewEncoder(writer).Encode(response); err != nil {
+			panic(err)
+		}
+		return "", false
+	}
+	return normalized, true
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		address    string
+		normalized string
+		err        error
+	}{
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2", nil},
+		{"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", nil},
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", "", ErrUnsupportedAddress},
+		{"bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3", "", ErrUnsupportedAddress},
+		{"mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", "", ErrWrongNetwork},
+		{"tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", "", ErrWrongNetwork},
+		{"1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3", "", ErrInvalidAddress},
+		{"garbage", "", ErrInvalidAddress},
+		{"", "", ErrInvalidAddress},
+	}
+
+	for _, test := range tests {
+		normalized, err := normalizeAddress(test.address)
+		if normalized != test.normalized || err != test.err {
+			t.Errorf("normalizeAddress(%q): Expecting %q %v returned %q %v",
+				test.address, test.normalized, test.err, normalized, err)
+		}
+	}
+
+	if code := addressErrorCode(ErrUnsupportedAddress); code != UnsupportedAddressCode {
+		t.Errorf("addressErrorCode(): Expecting %v returned %v", UnsupportedAddressCode, code)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"strconv"
+	"net/http"
+	"encoding/json"
+
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/height"
+	"github.com/secnot/gobalance/balance"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/recent_tx"
+)
+
+const (
+	// Transactions per page when limit isn't specified
+	DefaultAddressTxLimit = 50
+
+	// Max transactions per page
+	MaxAddressTxLimit = 500
+)
+
+// intParam parses an optional integer query parameter, returns def when missing
+func intParam(request *http.Request, name string, def int64) (int64, bool) {
+	param := request.URL.Query().Get(name)
+	if param == "" {
+		return def, true
+	}
+	value, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || value < 0 {
+		return 0, false
+	}
+	return value, true
+}
+
+// Address transactions handler, newest first paginated with cursor
+func AddressTxHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_tx.RecentTxCache, heightC *height.HeightCache) http.Handler {
+
+	handler := func (writer http.ResponseWriter, request *http.Request) {
+		address, ok := addressParam(writer, request)
+		if !ok {
+			return
+		}
+
+		limit, ok := intParam(request, "limit", DefaultAddressTxLimit)
+		if !ok || limit < 1 || limit > MaxAddressTxLimit {
+			http.Error(writer, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		minHeight, ok := intParam(request, "min_height", 0)
+		if !ok {
+			http.Error(writer, "Invalid min_height", http.StatusBadRequest)
+			return
+		}
+		maxHeight, ok := intParam(request, "max_height", -1)
+		if !ok {
+			http.Error(writer, "Invalid max_height", http.StatusBadRequest)
+			return
+		}
+
+		query := block_manager.AddressTxQuery {
+			Address:   address,
+			MinHeight: minHeight,
+			MaxHeight: maxHeight,
+			Cursor:    request.URL.Query().Get("cursor"),
+			Limit:     int(limit),
+		}
+		page, err := balanceC.GetAddressTxs(request.Context(), query)
+		if err != nil {
+			httpError(writer, err)
+			return
+		}
+
+		response := api_common.AddressTxs {
+			Address: address,
+			Txs:     make([]api_common.AddressTx, len(page.Txs)),
+			Cursor:  page.Cursor,
+		}
+		for n, atx := range page.Txs {
+			response.Txs[n] = api_common.AddressTx {
+				TxHash:   atx.TxHash.String(),
+				Height:   atx.Height,
+				Position: atx.Position,
+				Delta:    atx.Delta,
+			}
+		}
+
+		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(writer).Encode(response); err != nil {
+			panic(err)
+		}
+	}
+
+	return http.HandlerFunc(handler)
+}
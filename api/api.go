@@ -2,17 +2,44 @@ package api
 
 import (
 	"log"
+	"time"
+	"context"
 	"net/http"
 	"github.com/secnot/gobalance/balance"
 	"github.com/secnot/gobalance/recent_tx"
 	"github.com/secnot/gobalance/height"
+	"github.com/secnot/gobalance/notify"
 )
 
-func StartApi(address string, urlPrefix string, 
+const (
+	// Max time waiting for active requests to finish on shutdown
+	ShutdownTimeout = 10*time.Second
+)
+
+// StartApi serves the api until ctx is cancelled, then waits for active
+// requests to finish before returning.
+func StartApi(ctx context.Context, address string, urlPrefix string, 
 	balanceC  *balance.BalanceCache, 
 	recentTxC *recent_tx.RecentTxCache,
-	heightC   *height.HeightCache) {
+	heightC   *height.HeightCache,
+	hub       *notify.Hub) error {
+
+	router := NewRouter(urlPrefix, balanceC, recentTxC, heightC, hub)
+	server := &http.Server{Addr: address, Handler: router}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
 
-	router := NewRouter(urlPrefix, balanceC, recentTxC, heightC)
-	log.Fatal(http.ListenAndServe(address, router))
+	log.Print("Api: Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
 }
@@ -1,15 +1,16 @@
 package api
 
 import (
+	"strconv"
 	"net/http"
 	"encoding/json"
 
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/secnot/gobalance/api/common"
 	"github.com/secnot/gobalance/height"
 	"github.com/secnot/gobalance/balance"
 	"github.com/secnot/gobalance/recent_tx"
 	"github.com/secnot/gobalance/utils"
-	"github.com/gorilla/mux"
 )
 
 
@@ -18,8 +19,10 @@ import (
 func BalanceHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_tx.RecentTxCache, heightC *height.HeightCache) http.Handler {
 
 	handler := func (writer http.ResponseWriter, request *http.Request) {
-		vars := mux.Vars(request)
-		address := vars["address"]
+		address, ok := addressParam(writer, request)
+		if !ok {
+			return
+		}
 			
 		// Use requester ip	as its identification
 		ip, _, err := utils.ParseHost(request.RemoteAddr)
@@ -28,8 +31,62 @@ func BalanceHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_t
 			return
 		}
 
+		atHash, ok := atHashParam(request)
+		if !ok {
+			http.Error(writer, "Invalid at_hash", http.StatusBadRequest)
+			return
+		}
+
+		// Balance at a past height, unconfirmed balance doesn't apply
+		if param := request.URL.Query().Get("height"); param != "" {
+			height, err := strconv.ParseInt(param, 10, 64)
+			if err != nil || height < 0 {
+				http.Error(writer, "Invalid height", http.StatusBadRequest)
+				return
+			}
+
+			bal, state, err := balanceC.GetBalanceAt(request.Context(), address, height)
+			if err != nil {
+				httpError(writer, err)
+				return
+			}
+			if !writeChainState(writer, state, atHash) {
+				return
+			}
+
+			response := api_common.Address {
+				Address:  address,
+				Balance:  bal,
+				AtHeight: &height,
+			}
+			if state.Hash != (chainhash.Hash{}) {
+				response.Height    = state.Height
+				response.BlockHash = state.Hash.String()
+			}
+			writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			if err := json.NewEncoder(writer).Encode(response); err != nil {
+				panic(err)
+			}
+			return
+		}
+
+		minconf, ok := intParam(request, "minconf", 0)
+		if !ok {
+			http.Error(writer, "Invalid minconf", http.StatusBadRequest)
+			return
+		}
+
 		// Request balance
-		bal, err := balanceC.GetBalance(address, ip)
+		bal, state, err := balanceC.GetBalance(request.Context(), address, int(minconf), ip)
+		if err != nil {
+			 httpError(writer, err)
+			 return
+		}
+		if !writeChainState(writer, state, atHash) {
+			return
+		}
+		
+		unconfirmed, err := balanceC.GetUnconfirmedBalance(address)
 		if err != nil {
 			 http.Error(writer, err.Error(), http.StatusInternalServerError)
 			 return
@@ -37,8 +94,14 @@ func BalanceHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_t
 		
 		// Send response back.
 		response := api_common.Address {
-			Address: address,
-			Balance: bal,
+			Address:     address,
+			Balance:     bal,
+			Unconfirmed: unconfirmed,
+			MinConf:     int(minconf),
+		}
+		if state.Hash != (chainhash.Hash{}) {
+			response.Height    = state.Height
+			response.BlockHash = state.Hash.String()
 		}
 		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		if err := json.NewEncoder(writer).Encode(response); err != nil {
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"encoding/json"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/height"
+	"github.com/secnot/gobalance/balance"
+	"github.com/secnot/gobalance/recent_tx"
+	"github.com/secnot/gobalance/utils"
+)
+
+const (
+	// Max number of addresses in a batch balance request
+	MaxBatchAddresses = 1000
+
+	// Max batch balance request body size
+	MaxBatchBodySize = 1 << 20
+)
+
+// Batch balance handler, the balances are all computed at the same chain state
+// and invalid addresses are reported with an error instead of a balance.
+func BalancesHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_tx.RecentTxCache, heightC *height.HeightCache) http.Handler {
+
+	handler := func (writer http.ResponseWriter, request *http.Request) {
+		// Use requester ip	as its identification
+		ip, _, err := utils.ParseHost(request.RemoteAddr)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		atHash, ok := atHashParam(request)
+		if !ok {
+			http.Error(writer, "Invalid at_hash", http.StatusBadRequest)
+			return
+		}
+
+		var body api_common.BalancesRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, MaxBatchBodySize))
+		if err := decoder.Decode(&body); err != nil {
+			http.Error(writer, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(body.Addresses) == 0 || len(body.Addresses) > MaxBatchAddresses {
+			http.Error(writer, "Invalid number of addresses", http.StatusBadRequest)
+			return
+		}
+
+		response := api_common.Balances {
+			Balances: make([]api_common.AddressBalance, len(body.Addresses)),
+		}
+		normalized := make([]string, len(body.Addresses))
+		valid := make([]string, 0, len(body.Addresses))
+		for n, address := range body.Addresses {
+			response.Balances[n].Address = address
+			if normalized[n], err = normalizeAddress(address); err != nil {
+				response.Balances[n].Error = err.Error()
+				response.Balances[n].Code  = addressErrorCode(err)
+			} else {
+				valid = append(valid, normalized[n])
+			}
+		}
+
+		if len(valid) > 0 {
+			balances, state, err := balanceC.GetBalances(request.Context(), valid, ip)
+			if err != nil {
+				httpError(writer, err)
+				return
+			}
+			if !writeChainState(writer, state, atHash) {
+				return
+			}
+
+			for n := range response.Balances {
+				if response.Balances[n].Error == "" {
+					response.Balances[n].Balance = balances[normalized[n]]
+				}
+			}
+			if state.Hash != (chainhash.Hash{}) {
+				response.Height    = state.Height
+				response.BlockHash = state.Hash.String()
+			}
+		}
+
+		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(writer).Encode(response); err != nil {
+			panic(err)
+		}
+	}
+
+	return http.HandlerFunc(handler)
+}
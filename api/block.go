@@ -0,0 +1,62 @@
+package api
+
+import (
+	"strconv"
+	"net/http"
+	"encoding/json"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/height"
+	"github.com/secnot/gobalance/balance"
+	"github.com/secnot/gobalance/recent_tx"
+	"github.com/gorilla/mux"
+)
+
+// Block handler, the block is identified by its hash or height
+func BlockHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_tx.RecentTxCache, heightC *height.HeightCache) http.Handler {
+
+	handler := func (writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		var hash *chainhash.Hash
+		height, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil || len(vars["id"]) == 2*chainhash.HashSize {
+			if hash, err = chainhash.NewHashFromStr(vars["id"]); err != nil {
+				http.Error(writer, "Invalid block hash or height", http.StatusBadRequest)
+				return
+			}
+		} else if height < 0 {
+			http.Error(writer, "Invalid block hash or height", http.StatusBadRequest)
+			return
+		}
+
+		atHash, ok := atHashParam(request)
+		if !ok {
+			http.Error(writer, "Invalid at_hash", http.StatusBadRequest)
+			return
+		}
+
+		block, state, err := recentC.GetBlock(request.Context(), hash, height)
+		if err != nil {
+			httpError(writer, err)
+			return
+		}
+		if !writeChainState(writer, state, atHash) {
+			return
+		}
+
+		response := blockModel(block, state)
+		response.Transactions = make([]api_common.Tx, len(block.Transactions))
+		for n, tx := range block.Transactions {
+			response.Transactions[n] = txModel(tx, nil, state)
+		}
+
+		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(writer).Encode(response); err != nil {
+			panic(err)
+		}
+	}
+
+	return http.HandlerFunc(handler)
+}
@@ -11,15 +11,197 @@ type Tx struct {
 	Block *Block 	`json:"block,omitempty"`
 	Inputs  []TxOut `json:"inputs,omitempty"`
 	Outputs []TxOut `json:"outputs,omitempty"`
+
+	// Blocks since the transaction was included
+	Confirmations int64 `json:"confirmations,omitempty"`
+
+	// Inputs minus outputs value
+	Fee int64 `json:"fee"`
+
+	// Balance change for the queried address
+	Delta int64 `json:"delta,omitempty"`
 }
 
 type Block struct {
 	Hash string       `json:"hash"`
 	Height int64      `json:"height"`
+
+	// Block header timestamp (unix time)
+	Time int64        `json:"time,omitempty"`
+
+	// Blocks since this one, including itself
+	Confirmations int64 `json:"confirmations,omitempty"`
 	Transactions []Tx `json:"transactions,omitempty"`
 }
 
+type RecentTxs struct {
+	Address string `json:"address"`
+	Txs     []Tx   `json:"txs"`
+
+	// Cursor for the next page, empty when there are no more transactions
+	Cursor  string `json:"cursor,omitempty"`
+}
+
+// Error is the structured error response for invalid requests
+type Error struct {
+	Error   string `json:"error"`
+
+	// Machine readable error code
+	Code    string `json:"code"`
+
+	// Address the error refers to
+	Address string `json:"address,omitempty"`
+}
+
+// BalancesRequest is the batch balance request body
+type BalancesRequest struct {
+	Addresses []string `json:"addresses"`
+}
+
+type AddressBalance struct {
+	Address string `json:"address"`
+	Balance int64  `json:"balance"`
+
+	// Set instead of the balance when the address is invalid
+	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+type Balances struct {
+	// Chain state all the balances were computed at
+	Height    int64  `json:"height"`
+	BlockHash string `json:"block_hash,omitempty"`
+
+	Balances  []AddressBalance `json:"balances"`
+}
+
+// Event types pushed to WebSocket subscribers
+const (
+	// Address balance change in a block (or its reversal on backtrack)
+	EventBalance   = "balance"
+
+	// Transaction involving the address included in a block
+	EventTx        = "tx"
+
+	// Transaction removed from the chain by a backtrack
+	EventTxRemoved = "tx_removed"
+)
+
+// Event describes the activity of a subscribed address in a block
+type Event struct {
+	Type      string `json:"type"`
+	Address   string `json:"address"`
+	TxHash    string `json:"txid,omitempty"`
+
+	// Address balance change
+	Delta     int64  `json:"delta"`
+
+	// Block connected or disconnected
+	Height    int64  `json:"height"`
+	BlockHash string `json:"block_hash"`
+}
+
+// WsRequest is a WebSocket client message, op is "subscribe" or "unsubscribe"
+type WsRequest struct {
+	Op        string   `json:"op"`
+	Addresses []string `json:"addresses"`
+}
+
+// WsMessage is a WebSocket server message, type is "subscribed",
+// "unsubscribed", "events" or "error"
+type WsMessage struct {
+	Type      string   `json:"type"`
+	Addresses []string `json:"addresses,omitempty"`
+	Events    []Event  `json:"events,omitempty"`
+	Error     *Error   `json:"error,omitempty"`
+}
+
+type Height struct {
+	Height int64 `json:"height"`
+	Hash   string `json:"hash"`
+
+	// Tip header timestamp and median time past (unix time)
+	Time       int64 `json:"time,omitempty"`
+	MedianTime int64 `json:"median_time,omitempty"`
+
+	// Seconds since the tip timestamp
+	SinceLastBlock int64 `json:"since_last_block,omitempty"`
+
+	// Last block committed to storage
+	CommittedHeight int64 `json:"committed_height"`
+
+	// Sync state, target is bitcoind chain tip (-1 when unknown) and
+	// progress the fraction of blocks processed
+	Synced       bool    `json:"synced"`
+	TargetHeight int64   `json:"target_height"`
+	Progress     float64 `json:"progress"`
+
+	// Estimated seconds until synced (omitted when unknown or synced)
+	ETA int64 `json:"eta,omitempty"`
+}
+
+type Utxo struct {
+	TxHash string `json:"txid"`
+	Nout   uint32 `json:"vout"`
+	Value  int64  `json:"value"`
+
+	// Omitted when the output block height is unknown (outputs stored
+	// before the height column was added)
+	Height        *int64 `json:"height,omitempty"`
+	Confirmations *int64 `json:"confirmations,omitempty"`
+}
+
+type AddressTx struct {
+	TxHash   string `json:"txid"`
+	Height   int64  `json:"height"`
+	Position int    `json:"position"`
+	Delta    int64  `json:"delta"`
+}
+
+type AddressTxs struct {
+	Address string      `json:"address"`
+	Txs     []AddressTx `json:"txs"`
+
+	// Cursor for the next page, empty when there are no more transactions
+	Cursor  string      `json:"cursor,omitempty"`
+}
+
+type SpentBy struct {
+	TxHash string `json:"txid"`
+	Nin    uint32 `json:"vin"`
+	Height int64  `json:"height"`
+}
+
+type Outpoint struct {
+	TxHash string `json:"txid"`
+	Nout   uint32 `json:"vout"`
+
+	// "unspent", "spent" or "unknown"
+	Status string `json:"status"`
+
+	// Only for unspent outputs
+	Address string `json:"address,omitempty"`
+	Value   int64  `json:"value,omitempty"`
+	Height  int64  `json:"height,omitempty"`
+
+	// Only for spent outputs
+	SpentBy *SpentBy `json:"spent_by,omitempty"`
+}
+
 type Address struct {
 	Address string `json:"address"`
 	Balance int64  `json:"balance"`
+
+	// Balance delta from transactions still in the mempool
+	Unconfirmed int64 `json:"unconfirmed"`
+
+	// Chain state the balance was computed against
+	Height    int64  `json:"height,omitempty"`
+	BlockHash string `json:"block_hash,omitempty"`
+
+	// Requested height for historical balances
+	AtHeight *int64 `json:"at_height,omitempty"`
+
+	// Min confirmations of the blocks included in the balance
+	MinConf int `json:"minconf,omitempty"`
 }
@@ -4,10 +4,31 @@ const (
 	// Get address balance
 	BalancePath     = "address"
 
+	// Get the balance of several addresses
+	BalancesPath    = "addresses/balance"
+
 	// Get height
 	HeightPath		 = "height"
 
 	// Announce
 	RecentTxPath     = "recent_tx"
+
+	// Get address unspent outputs
+	UtxoPath         = "utxo"
+
+	// Get address transactions
+	AddressTxPath    = "address_tx"
+
+	// Get transaction output status
+	OutpointPath     = "outpoint"
+
+	// Get block by hash or height
+	BlockPath        = "block"
+
+	// Get transaction
+	TxPath           = "tx"
+
+	// Address activity WebSocket
+	WsPath           = "ws"
 )
 
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/secnot/gobalance/bitcoind"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/recent_tx"
+)
+
+// errorStatus maps internal errors to HTTP status codes
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, recent_tx.ErrUnresolvedInputs):
+		return http.StatusBadGateway
+	case errors.Is(err, context.Canceled), errors.Is(err, block_manager.ErrStopped),
+		errors.Is(err, bitcoind.ErrNoBackendAvailable), errors.Is(err, recent_tx.ErrTooManyInputs):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, block_manager.ErrHistoryUnavailable), errors.Is(err, block_manager.ErrAddressTxUnavailable),
+		errors.Is(err, recent_tx.ErrBlockNotFound), errors.Is(err, recent_tx.ErrTxNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, block_manager.ErrInvalidCursor), errors.Is(err, block_manager.ErrInvalidMinConf),
+		errors.Is(err, recent_tx.ErrInvalidDirection):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// httpError replies to the request with err message and its status code
+func httpError(writer http.ResponseWriter, err error) {
+	http.Error(writer, err.Error(), errorStatus(err))
+}
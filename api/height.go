@@ -1,17 +1,47 @@
 package api
 
 import (
+	"time"
 	"net/http"
 	"encoding/json"
 
+	"github.com/secnot/gobalance/api/common"
 	"github.com/secnot/gobalance/height"
 	"github.com/secnot/gobalance/balance"
+	"github.com/secnot/gobalance/block_manager"
 	"github.com/secnot/gobalance/recent_tx"
 )
 
+// Chain tip and sync state handler
 func HeightHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_tx.RecentTxCache, heightC *height.HeightCache) http.Handler {
 	handler := func(writer http.ResponseWriter, request *http.Request) {
-		response := heightC.GetHeight()
+		progress, err := heightC.SyncProgress(request.Context())
+		if err != nil {
+			httpError(writer, err)
+			return
+		}
+		tip := heightC.GetTip()
+
+		response := api_common.Height {
+			Height:          tip.Height,
+			Hash:            tip.Hash.String(),
+			CommittedHeight: progress.CommittedHeight,
+			Synced:          progress.Synced,
+			TargetHeight:    progress.TargetHeight,
+			Progress:        syncFraction(progress),
+			ETA:             int64(progress.ETA/time.Second),
+		}
+		if !tip.Time.IsZero() {
+			response.Time = tip.Time.Unix()
+			if since := time.Since(tip.Time); since > 0 {
+				response.SinceLastBlock = int64(since/time.Second)
+			}
+		}
+		if !tip.MedianTime.IsZero() {
+			response.MedianTime = tip.MedianTime.Unix()
+		}
+		writeChainState(writer, block_manager.ChainState{Height: tip.Height, Hash: tip.Hash}, nil)
+
 		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		if err := json.NewEncoder(writer).Encode(response); err != nil {
 			panic(err)
@@ -21,3 +51,18 @@ func HeightHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_tx
 	return http.HandlerFunc(handler)
 }
 
+// syncFraction returns the fraction of bitcoind chain processed, 1 when
+// synced and 0 if bitcoind tip is unknown.
+func syncFraction(progress block_manager.SyncProgress) float64 {
+	switch {
+	case progress.Synced:
+		return 1
+	case progress.TargetHeight <= 0:
+		return 0
+	}
+	fraction := float64(progress.Height+1)/float64(progress.TargetHeight+1)
+	if fraction > 1 {
+		fraction = 1
+	}
+	return fraction
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"strconv"
+	"net/http"
+	"encoding/json"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/height"
+	"github.com/secnot/gobalance/balance"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/block_manager/storage"
+	"github.com/secnot/gobalance/recent_tx"
+	"github.com/gorilla/mux"
+)
+
+// Transaction output status handler
+func OutpointHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_tx.RecentTxCache, heightC *height.HeightCache) http.Handler {
+
+	handler := func (writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		hash, err := chainhash.NewHashFromStr(vars["txid"])
+		if err != nil {
+			http.Error(writer, "Invalid txid", http.StatusBadRequest)
+			return
+		}
+		nout, err := strconv.ParseUint(vars["n"], 10, 32)
+		if err != nil {
+			http.Error(writer, "Invalid output number", http.StatusBadRequest)
+			return
+		}
+
+		outpoint, err := balanceC.GetOutpoint(request.Context(), storage.TxOutId{TxHash: *hash, Nout: uint32(nout)})
+		if err != nil {
+			httpError(writer, err)
+			return
+		}
+
+		response := api_common.Outpoint {
+			TxHash: hash.String(),
+			Nout:   uint32(nout),
+			Status: outpoint.Status.String(),
+		}
+		switch outpoint.Status {
+		case block_manager.OUTPOINT_UNSPENT:
+			response.Address = outpoint.Addr
+			response.Value   = outpoint.Value
+			response.Height  = outpoint.Height
+		case block_manager.OUTPOINT_SPENT:
+			response.SpentBy = &api_common.SpentBy {
+				TxHash: outpoint.SpentBy.TxHash.String(),
+				Nin:    outpoint.SpentBy.Nin,
+				Height: outpoint.SpentBy.Height,
+			}
+		}
+
+		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(writer).Encode(response); err != nil {
+			panic(err)
+		}
+	}
+
+	return http.HandlerFunc(handler)
+}
@@ -8,29 +8,61 @@ import (
 	"github.com/secnot/gobalance/height"
 	"github.com/secnot/gobalance/balance"
 	"github.com/secnot/gobalance/recent_tx"
-	"github.com/gorilla/mux"
 )
 
+const (
+	// Recent transactions per page when limit isn't specified
+	DefaultRecentTxLimit = 50
+
+	// Max recent transactions per page
+	MaxRecentTxLimit = 500
+)
+
+// Recent transactions handler, newest first paginated with cursor and
+// optionally filtered by direction ("in" or "out")
 func RecentTxHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_tx.RecentTxCache, heightC *height.HeightCache) http.Handler {
 	
 	handler := func (writer http.ResponseWriter, request *http.Request) {
-		vars := mux.Vars(request)
-		address := vars["address"]
-		
-		// Get balance from crawler
-		transactions, _, err := recentC.GetRecentTx(address)
+		address, ok := addressParam(writer, request)
+		if !ok {
+			return
+		}
+
+		atHash, ok := atHashParam(request)
+		if !ok {
+			http.Error(writer, "Invalid at_hash", http.StatusBadRequest)
+			return
+		}
+
+		limit, ok := intParam(request, "limit", DefaultRecentTxLimit)
+		if !ok || limit < 1 || limit > MaxRecentTxLimit {
+			http.Error(writer, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+
+		query := recent_tx.Query {
+			Address:   address,
+			Direction: request.URL.Query().Get("direction"),
+			Cursor:    request.URL.Query().Get("cursor"),
+			Limit:     int(limit),
+		}
+		page, state, err := recentC.GetRecentTxPage(request.Context(), query)
 		if err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			httpError(writer, err)
+			return
+		}
+		if !writeChainState(writer, state, atHash) {
 			return
 		}
 
-		response := make([]api_common.Tx, len(transactions))
-		for n, tx := range transactions {
-		
-			response[n] = api_common.Tx {
-				Hash: tx.Hash.String(),
-				
-			}	
+		response := api_common.RecentTxs {
+			Address: address,
+			Txs:     make([]api_common.Tx, len(page.Txs)),
+			Cursor:  page.Cursor,
+		}
+		for n, rtx := range page.Txs {
+			response.Txs[n] = txModel(rtx.Tx, rtx.Block, state)
+			response.Txs[n].Delta = rtx.Delta
 		}
 
 		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -40,5 +72,4 @@ func RecentTxHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_
 	}
 
 	return http.HandlerFunc(handler)
-}	
-
+}
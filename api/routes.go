@@ -10,6 +10,7 @@ import (
 	
 	"github.com/secnot/gobalance/height"
 	"github.com/secnot/gobalance/balance"
+	"github.com/secnot/gobalance/notify"
 	"github.com/secnot/gobalance/recent_tx"
 )
 
@@ -17,11 +18,32 @@ const (
 	// Get address balance
 	BalancePath     = "address"
 
+	// Get the balance of several addresses
+	BalancesPath    = "addresses/balance"
+
 	// Get height
 	HeightPath		 = "height"
 
 	// Announce
 	RecentTxPath     = "recent_tx"
+
+	// Get address unspent outputs
+	UtxoPath         = "utxo"
+
+	// Get address transactions
+	AddressTxPath    = "address_tx"
+
+	// Get transaction output status
+	OutpointPath     = "outpoint"
+
+	// Get block by hash or height
+	BlockPath        = "block"
+
+	// Get transaction
+	TxPath           = "tx"
+
+	// Address activity WebSocket
+	WsPath           = "ws"
 )
 
 type HandlerFuncConstructor func (*balance.BalanceCache, *recent_tx.RecentTxCache, *height.HeightCache) http.Handler
@@ -52,6 +74,42 @@ var routes = [...]Route {
 	"/address/{address}/recent_tx",
 	RecentTxHandlerConstructor},
 
+	{
+	api_common.UtxoPath,
+	"GET",
+	"/address/{address}/utxo",
+	UtxoHandlerConstructor},
+
+	{
+	api_common.AddressTxPath,
+	"GET",
+	"/address/{address}/txs",
+	AddressTxHandlerConstructor},
+
+	{
+	api_common.OutpointPath,
+	"GET",
+	"/outpoint/{txid}/{n}",
+	OutpointHandlerConstructor},
+
+	{
+	api_common.BalancesPath,
+	"POST",
+	"/addresses/balance",
+	BalancesHandlerConstructor},
+
+	{
+	api_common.BlockPath,
+	"GET",
+	"/block/{id}",
+	BlockHandlerConstructor},
+
+	{
+	api_common.TxPath,
+	"GET",
+	"/tx/{txid}",
+	TxHandlerConstructor},
+
 	/*
 	// Transactions involving this address in the last few blocks
 	{"recent_transactions",
@@ -80,11 +138,12 @@ func BuildPath(urlPrefix string, path string) string {
 	}
 }
 
-// NewRouter
+// NewRouter, the WebSocket endpoint is only added when hub isn't nil
 func NewRouter(urlPrefix string,
 	balanceC  *balance.BalanceCache, 
 	recentTxC *recent_tx.RecentTxCache,
-	heightC   *height.HeightCache) *mux.Router {
+	heightC   *height.HeightCache,
+	hub       *notify.Hub) *mux.Router {
 
     router := mux.NewRouter().StrictSlash(true)
    
@@ -99,5 +158,13 @@ func NewRouter(urlPrefix string,
             Handler(loggedHandler)
     }
 
+	if hub != nil {
+		router.
+			Methods("GET").
+			Path(BuildPath(urlPrefix, "/ws")).
+			Name(api_common.WsPath).
+			Handler(logging.NewLoggerHandler(WsHandler(hub), api_common.WsPath))
+	}
+
     return router
 }
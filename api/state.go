@@ -0,0 +1,44 @@
+package api
+
+import (
+	"strconv"
+	"net/http"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/block_manager"
+)
+
+const (
+	// Headers with the chain state a response was computed at
+	BlockHeightHeader = "X-Block-Height"
+	BlockHashHeader   = "X-Block-Hash"
+)
+
+// atHashParam parses the optional at_hash query parameter, returns nil when
+// missing and false when it isn't a valid block hash.
+func atHashParam(request *http.Request) (*chainhash.Hash, bool) {
+	param := request.URL.Query().Get("at_hash")
+	if param == "" {
+		return nil, true
+	}
+	hash, err := chainhash.NewHashFromStr(param)
+	if err != nil {
+		return nil, false
+	}
+	return hash, true
+}
+
+// writeChainState checks the response state matches the requested hash (if
+// any) replying with 409 Conflict otherwise, and sets the chain state headers.
+func writeChainState(writer http.ResponseWriter, state block_manager.ChainState, atHash *chainhash.Hash) bool {
+	var zero chainhash.Hash
+	if atHash != nil && *atHash != state.Hash {
+		http.Error(writer, "Chain state doesn't match at_hash", http.StatusConflict)
+		return false
+	}
+	if state.Hash != zero {
+		writer.Header().Set(BlockHeightHeader, strconv.FormatInt(state.Height, 10))
+		writer.Header().Set(BlockHashHeader, state.Hash.String())
+	}
+	return true
+}
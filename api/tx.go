@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"encoding/json"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/height"
+	"github.com/secnot/gobalance/balance"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/primitives"
+	"github.com/secnot/gobalance/recent_tx"
+	"github.com/gorilla/mux"
+)
+
+// txOuts converts transaction inputs or outputs to their api model
+func txOuts(outs []*primitives.TxOut) []api_common.TxOut {
+	models := make([]api_common.TxOut, len(outs))
+	for n, out := range outs {
+		models[n] = api_common.TxOut{Address: out.Addr, Value: out.Value}
+	}
+	return models
+}
+
+// blockModel converts a block header to its api model, confirmations are
+// computed from the chain state.
+func blockModel(block *primitives.Block, state block_manager.ChainState) *api_common.Block {
+	model := &api_common.Block {
+		Hash:          block.Hash.String(),
+		Height:        int64(block.Height),
+		Confirmations: state.Height - int64(block.Height) + 1,
+	}
+	if !block.Time.IsZero() {
+		model.Time = block.Time.Unix()
+	}
+	return model
+}
+
+// txModel converts a transaction included in block (nil when unconfirmed)
+// to its api model.
+func txModel(tx *primitives.Tx, block *primitives.Block, state block_manager.ChainState) api_common.Tx {
+	model := api_common.Tx {
+		Hash:    tx.Hash.String(),
+		Inputs:  txOuts(tx.In),
+		Outputs: txOuts(tx.Out),
+		Fee:     tx.Fee(),
+	}
+	if block != nil {
+		model.Block = blockModel(block, state)
+		model.Confirmations = model.Block.Confirmations
+	}
+	return model
+}
+
+// Transaction handler, with inputs address and value resolved
+func TxHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_tx.RecentTxCache, heightC *height.HeightCache) http.Handler {
+
+	handler := func (writer http.ResponseWriter, request *http.Request) {
+		vars := mux.Vars(request)
+
+		hash, err := chainhash.NewHashFromStr(vars["txid"])
+		if err != nil {
+			http.Error(writer, "Invalid txid", http.StatusBadRequest)
+			return
+		}
+
+		atHash, ok := atHashParam(request)
+		if !ok {
+			http.Error(writer, "Invalid at_hash", http.StatusBadRequest)
+			return
+		}
+
+		tx, block, state, err := recentC.GetTx(request.Context(), *hash)
+		if err != nil {
+			httpError(writer, err)
+			return
+		}
+		if !writeChainState(writer, state, atHash) {
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(writer).Encode(txModel(tx, block, state)); err != nil {
+			panic(err)
+		}
+	}
+
+	return http.HandlerFunc(handler)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"encoding/json"
+
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/height"
+	"github.com/secnot/gobalance/balance"
+	"github.com/secnot/gobalance/recent_tx"
+)
+
+// Address unspent outputs handler
+func UtxoHandlerConstructor(balanceC *balance.BalanceCache, recentC *recent_tx.RecentTxCache, heightC *height.HeightCache) http.Handler {
+
+	handler := func (writer http.ResponseWriter, request *http.Request) {
+		address, ok := addressParam(writer, request)
+		if !ok {
+			return
+		}
+
+		atHash, ok := atHashParam(request)
+		if !ok {
+			http.Error(writer, "Invalid at_hash", http.StatusBadRequest)
+			return
+		}
+
+		utxos, state, err := balanceC.GetUtxos(request.Context(), address)
+		if err != nil {
+			httpError(writer, err)
+			return
+		}
+		if !writeChainState(writer, state, atHash) {
+			return
+		}
+
+		response := make([]api_common.Utxo, len(utxos))
+		for n, utxo := range utxos {
+			response[n] = api_common.Utxo {
+				TxHash: utxo.TxHash.String(),
+				Nout:   utxo.Nout,
+				Value:  utxo.Value,
+			}
+			if utxo.Height > 0 {
+				height, confirmations := utxo.Height, utxo.Confirmations
+				response[n].Height = &height
+				response[n].Confirmations = &confirmations
+			}
+		}
+
+		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(writer).Encode(response); err != nil {
+			panic(err)
+		}
+	}
+
+	return http.HandlerFunc(handler)
+}
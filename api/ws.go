@@ -0,0 +1,186 @@
+package api
+
+import (
+	"log"
+	"time"
+	"net/http"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/notify"
+)
+
+const (
+	// Max time to write a message to the client
+	WsWriteWait = 10*time.Second
+
+	// Max time between client pongs, and the ping period (must be shorter)
+	WsPongWait   = 60*time.Second
+	WsPingPeriod = (WsPongWait*9)/10
+
+	// Max client message size
+	WsMaxMessageSize = 64*1024
+
+	// Number of replies to client requests waiting to be written
+	WsReplyQueueSize = 8
+)
+
+// WebSocket client request operations
+const (
+	WsSubscribe   = "subscribe"
+	WsUnsubscribe = "unsubscribe"
+)
+
+// The events are public so connections from any origin are accepted
+var upgrader = websocket.Upgrader {
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// wsError returns an error message for the client
+func wsError(code string, err error, address string) api_common.WsMessage {
+	return api_common.WsMessage {
+		Type:  "error",
+		Error: &api_common.Error{Error: err.Error(), Code: code, Address: address},
+	}
+}
+
+// wsHandleRequest applies a client request and returns the reply
+func wsHandleRequest(hub *notify.Hub, client *notify.Client, request api_common.WsRequest) api_common.WsMessage {
+	if request.Op != WsSubscribe && request.Op != WsUnsubscribe {
+		return api_common.WsMessage {
+			Type:  "error",
+			Error: &api_common.Error{Error: "Unknown op", Code: "invalid_op"},
+		}
+	}
+
+	addresses := make([]string, len(request.Addresses))
+	for n, address := range request.Addresses {
+		normalized, err := normalizeAddress(address)
+		if err != nil {
+			return wsError(addressErrorCode(err), err, address)
+		}
+		addresses[n] = normalized
+	}
+
+	if request.Op == WsSubscribe {
+		if err := hub.Subscribe(client, addresses); err != nil {
+			return wsError("subscription_limit", err, "")
+		}
+		return api_common.WsMessage{Type: "subscribed", Addresses: addresses}
+	}
+
+	if err := hub.Unsubscribe(client, addresses); err != nil {
+		return wsError("subscription_limit", err, "")
+	}
+	return api_common.WsMessage{Type: "unsubscribed", Addresses: addresses}
+}
+
+// wsReader reads client requests until the connection fails, the replies are
+// sent to the writer through replies.
+func wsReader(conn *websocket.Conn, hub *notify.Hub, client *notify.Client, replies chan<- api_common.WsMessage, done chan<- bool) {
+	defer close(done)
+
+	conn.SetReadLimit(WsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(WsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(WsPongWait))
+	})
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var request api_common.WsRequest
+		if err := json.Unmarshal(message, &request); err != nil {
+			replies <- api_common.WsMessage {
+				Type:  "error",
+				Error: &api_common.Error{Error: "Invalid message", Code: "invalid_message"},
+			}
+			continue
+		}
+		replies <- wsHandleRequest(hub, client, request)
+	}
+}
+
+// wsWriter sends the replies, client events and pings until the reader exits,
+// the hub disconnects the client or a write fails.
+func wsWriter(conn *websocket.Conn, client *notify.Client, replies <-chan api_common.WsMessage, done <-chan bool) {
+	ticker := time.NewTicker(WsPingPeriod)
+	defer ticker.Stop()
+
+	write := func(message api_common.WsMessage) bool {
+		conn.SetWriteDeadline(time.Now().Add(WsWriteWait))
+		return conn.WriteJSON(message) == nil
+	}
+
+	for {
+		select {
+		case reply := <-replies:
+			if !write(reply) {
+				return
+			}
+
+		case events, ok := <-client.Events():
+			if !ok {
+				// Disconnected by the hub, too slow or stopping
+				closeMsg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Disconnected")
+				conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(WsWriteWait))
+				return
+			}
+			if !write(api_common.WsMessage{Type: "events", Events: events}) {
+				return
+			}
+
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WsWriteWait)); err != nil {
+				return
+			}
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// WebSocket handler, clients subscribe to addresses and receive their
+// balance changes and transactions on each new block and backtrack.
+func WsHandler(hub *notify.Hub) http.Handler {
+
+	handler := func (writer http.ResponseWriter, request *http.Request) {
+		client, err := hub.Register()
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer hub.Unregister(client)
+
+		conn, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			log.Print("WebSocket: ", err)
+			return
+		}
+		defer conn.Close()
+
+		replies := make(chan api_common.WsMessage, WsReplyQueueSize)
+		done    := make(chan bool)
+		go wsReader(conn, hub, client, replies, done)
+		wsWriter(conn, client, replies, done)
+
+		// Unblock the reader, it may be waiting to send a reply
+		conn.Close()
+		for {
+			select {
+			case <-replies:
+			case <-done:
+				return
+			}
+		}
+	}
+
+	return http.HandlerFunc(handler)
+}
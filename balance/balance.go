@@ -2,99 +2,107 @@ package balance
 
 
 import (
-	"fmt"
-	"time"
+	"context"
 	"net"
-	"net/http"
-	"encoding/json"
-	"io/ioutil"
 	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/block_manager/storage"
+	"github.com/secnot/gobalance/mempool"
 	"github.com/secnot/gobalance/peers"
-	"github.com/secnot/gobalance/api/common"
 )
 
 
 type BalanceRequest struct {
+	Ctx        context.Context
 	Address    string
+	MinConf    int
 	IP         net.IP
 	ResponseCh chan BalanceResponse
 }
 
 type BalanceResponse struct {
 	balance int64
+	state   block_manager.ChainState
 	err     error
 }
 
+// BalancesRequest is used to request the balance of several addresses
+type BalancesRequest struct {
+	Ctx        context.Context
+	Addresses  []string
+	IP         net.IP
+	ResponseCh chan BalancesResponse
+}
+
+type BalancesResponse struct {
+	balances map[string]int64
+	state    block_manager.ChainState
+	err      error
+}
+
 
 // BalanceProxy
 type BalanceCache struct {
 	BlockM    block_manager.BlockManagerInterface
 	PeerM     peers.PeerManagerInterface
 	CacheSize int
+
+	// Unconfirmed transactions (nil when mempool tracking is disabled)
+	Mempool   mempool.MempoolInterface
 	
 	cache     *Cache
 	
 	// Control channels
-	RequestChan chan BalanceRequest
-	StopChan    chan chan bool
-}
+	RequestChan  chan BalanceRequest
+	BalancesChan chan BalancesRequest
 
-// 
-var proxyClient = &http.Client {
-	Timeout:    2 * time.Second,
-	Transport : &http.Transport{MaxIdleConnsPerHost: 20},
-}
+	// Cancels the routine context, done is closed once it has exited
+	cancel context.CancelFunc
+	done   chan bool
 
+	// Limits the number of requests being proxied to other peers
+	proxySem chan struct{}
 
-// NewBalanceCache initializes a BalanceCache
-func NewBalanceCache(blockM block_manager.BlockManagerInterface, 
-					peerM peers.PeerManagerInterface, cacheSize int) *BalanceCache {
+	// Requests that couldn't be proxied, they are served from the local
+	// cache once the commit finishes.
+	fallbackChan chan func()
+	waiting      []func()
+}
+
+// NewBalanceCache initializes a BalanceCache that runs until ctx is cancelled
+// or Stop is called, memPool can be nil when unconfirmed balances are not
+// tracked.
+func NewBalanceCache(ctx context.Context, blockM block_manager.BlockManagerInterface, 
+					peerM peers.PeerManagerInterface,
+					memPool mempool.MempoolInterface, cacheSize int) *BalanceCache {
 	cache := &BalanceCache {
 		BlockM:    blockM,
 		PeerM :    peerM,
+		Mempool:   memPool,
 		CacheSize: cacheSize,
 	}
 
-	cache.start()
+	cache.start(ctx)
 	return cache
 }
 
 // Initialize and start proxy
-func (b *BalanceCache) start(){
+func (b *BalanceCache) start(ctx context.Context){
 	
 	b.cache   = NewCache(b.CacheSize, b.BlockM)
 	b.RequestChan   = make(chan BalanceRequest, 100)
-	b.StopChan      = make(chan chan bool)
-	go b.balanceRoutine()
-}
-
-// 
-func (b *BalanceCache) requestProxyBalance(request BalanceRequest) {
+	b.BalancesChan  = make(chan BalancesRequest, 10)
+	b.done          = make(chan bool)
+	b.proxySem      = make(chan struct{}, MaxProxyRequests)
+	b.fallbackChan  = make(chan func(), MaxProxyRequests)
 
-	remotePeer, err := b.PeerM.GetPeerPersistent(request.IP.String())
-	url := fmt.Sprintf("http://%s/%s", remotePeer, api_common.BalancePath)
-	resp, err := proxyClient.Get(url)
-	if err != nil {
-		request.ResponseCh <- BalanceResponse{balance: -1, err: err}
-		return
-	}
-	defer resp.Body.Close()
-
-	var address api_common.Address
-	err = json.NewDecoder(resp.Body).Decode(&address)
-	if err != nil {
-		request.ResponseCh <- BalanceResponse{balance: -1, err: err}
-		return
-	}
-	
-	ioutil.ReadAll(resp.Body) // Exhaust body data
-	request.ResponseCh <- BalanceResponse{balance: address.Balance, err: nil}
+	ctx, b.cancel = context.WithCancel(ctx)
+	go b.balanceRoutine(ctx)
 }
 
 // balanceRoutine handles all incoming requests
-func (b *BalanceCache) balanceRoutine() {
+func (b *BalanceCache) balanceRoutine(ctx context.Context) {
 
-	updateChan := b.BlockM.Subscribe(10)
+	updateChan := b.BlockM.Subscribe("balance", 10)
 	
 	// When the block manager is commiting a block the balance is proxied from another
 	// 
@@ -103,7 +111,11 @@ func (b *BalanceCache) balanceRoutine() {
 	for {
 
 		select {
-		case update := <- updateChan:			
+		case update, ok := <- updateChan:			
+			if !ok { // Manager stopped
+				updateChan = nil
+				continue
+			}
 		
 			switch update.Class {
 			case block_manager.OP_NEWBLOCK:
@@ -114,37 +126,176 @@ func (b *BalanceCache) balanceRoutine() {
 				proxyMode = true
 			case block_manager.OP_COMMIT_DONE:
 				proxyMode = false
+				b.serveWaiting()
 			}
 
 		case request := <- b.RequestChan:
+			serve := func() { b.serveBalance(request) }
+			if !proxyMode {
+				serve()
+			} else {
+				b.proxy(serve, func() { b.proxyBalanceRequest(request, serve) })
+			}
+	
+		case request := <- b.BalancesChan:
+			serve := func() { b.serveBalances(request) }
 			if !proxyMode {
-				request.ResponseCh <- BalanceResponse{balance: b.cache.GetBalance(request.Address), err: nil}
+				serve()
+			} else {
+				b.proxy(serve, func() { b.proxyBalancesRequest(request, serve) })
+			}
+
+		case serve := <- b.fallbackChan:
+			if proxyMode {
+				b.waiting = append(b.waiting, serve)
 			} else {
-				// TODO: limit number of parallel requests??
-				go b.requestProxyBalance(request)
+				serve()
 			}
 	
-		case ch := <- b.StopChan:
-			// TODO: Close all pending requests, and channels????
-			ch <- true
+		case <- ctx.Done():
+			b.BlockM.Unsubscribe(updateChan)
+			close(b.done)
 			return
 		}
 		
 	}
 }
 
-// Request
-func (b *BalanceCache) GetBalance(address string, ip net.IP) (balance int64, err error) {	
-	responseCh := make(chan BalanceResponse)
-	b.RequestChan <- BalanceRequest{Address: address, ResponseCh: responseCh, IP: ip}
-	response :=  <- responseCh
-	close(responseCh)
-	return response.balance, response.err
+// serveBalance responds a balance request from the local cache
+func (b *BalanceCache) serveBalance(request BalanceRequest) {
+	if err := request.Ctx.Err(); err != nil {
+		request.ResponseCh <- BalanceResponse{err: err}
+		return
+	}
+	balance, state, err := b.cache.GetBalance(request.Ctx, request.Address, request.MinConf)
+	request.ResponseCh <- BalanceResponse{balance: balance, state: state, err: err}
+}
+
+// serveBalances responds a multiple balance request from the local cache
+func (b *BalanceCache) serveBalances(request BalancesRequest) {
+	if err := request.Ctx.Err(); err != nil {
+		request.ResponseCh <- BalancesResponse{err: err}
+		return
+	}
+	balances, state, err := b.cache.GetBalances(request.Ctx, request.Addresses)
+	request.ResponseCh <- BalancesResponse{balances: balances, state: state, err: err}
+}
+
+// serveWaiting responds all the requests waiting for the local commit
+func (b *BalanceCache) serveWaiting() {
+	for _, serve := range b.waiting {
+		serve()
+	}
+	b.waiting = nil
+}
+
+// GetBalance returns the balance for an address with at least minconf
+// confirmations (0 or 1 for all the processed blocks) and the chain state it
+// was computed at, the state of the peer when proxied. ip identifies the
+// requester when the request has to be proxied to another peer.
+func (b *BalanceCache) GetBalance(ctx context.Context, address string, minconf int, ip net.IP) (int64, block_manager.ChainState, error) {
+	if minconf <= 1 {
+		// Read the manager snapshot directly unless a commit is in progress
+		balance, state, err := b.BlockM.ReadBalance(ctx, address)
+		if err != block_manager.ErrCommitInProgress {
+			return balance, state, err
+		}
+		minconf = 0
+	}
+	return b.requestBalance(ctx, address, minconf, ip)
+}
+
+// requestBalance retrieves the balance through the balance routine, from the
+// cache or from another peer while committing.
+func (b *BalanceCache) requestBalance(ctx context.Context, address string, minconf int, ip net.IP) (int64, block_manager.ChainState, error) {	
+	// Buffered so the routine never blocks if the request is cancelled
+	responseCh := make(chan BalanceResponse, 1)
+	select {
+	case b.RequestChan <- BalanceRequest{Ctx: ctx, Address: address, MinConf: minconf, ResponseCh: responseCh, IP: ip}:
+	case <- ctx.Done():
+		return 0, block_manager.ChainState{}, ctx.Err()
+	case <- b.done:
+		return 0, block_manager.ChainState{}, block_manager.ErrStopped
+	}
+
+	select {
+	case response := <- responseCh:
+		return response.balance, response.state, response.err
+	case <- ctx.Done():
+		return 0, block_manager.ChainState{}, ctx.Err()
+	case <- b.done:
+		return 0, block_manager.ChainState{}, block_manager.ErrStopped
+	}
+}
+
+// GetBalances returns the confirmed balance of several addresses and the chain
+// state they were all computed at, the state of the peer when proxied.
+func (b *BalanceCache) GetBalances(ctx context.Context, addresses []string, ip net.IP) (map[string]int64, block_manager.ChainState, error) {
+	// Read the manager snapshot directly unless a commit is in progress
+	balances, state, err := b.BlockM.ReadBalances(ctx, addresses)
+	if err != block_manager.ErrCommitInProgress {
+		return balances, state, err
+	}
+	return b.requestBalances(ctx, addresses, ip)
+}
+
+// requestBalances retrieves the balances through the balance routine, from the
+// cache or from another peer while committing.
+func (b *BalanceCache) requestBalances(ctx context.Context, addresses []string, ip net.IP) (map[string]int64, block_manager.ChainState, error) {
+	responseCh := make(chan BalancesResponse, 1)
+	select {
+	case b.BalancesChan <- BalancesRequest{Ctx: ctx, Addresses: addresses, ResponseCh: responseCh, IP: ip}:
+	case <- ctx.Done():
+		return nil, block_manager.ChainState{}, ctx.Err()
+	case <- b.done:
+		return nil, block_manager.ChainState{}, block_manager.ErrStopped
+	}
+
+	select {
+	case response := <- responseCh:
+		return response.balances, response.state, response.err
+	case <- ctx.Done():
+		return nil, block_manager.ChainState{}, ctx.Err()
+	case <- b.done:
+		return nil, block_manager.ChainState{}, block_manager.ErrStopped
+	}
+}
+
+// GetUtxos returns the address unspent outputs and the chain state they were
+// retrieved at, they are always retrieved from the block manager.
+func (b *BalanceCache) GetUtxos(ctx context.Context, address string) ([]block_manager.Utxo, block_manager.ChainState, error) {
+	return b.BlockM.GetUtxos(ctx, address)
+}
+
+// GetBalanceAt returns the address balance at a past height and the chain
+// state it was computed at, it requires the address history to be enabled in
+// the block manager.
+func (b *BalanceCache) GetBalanceAt(ctx context.Context, address string, height int64) (int64, block_manager.ChainState, error) {
+	return b.BlockM.GetBalanceAt(ctx, address, height)
+}
+
+// GetAddressTxs returns a page of the transactions involving an address, it
+// requires the address transactions index enabled in the block manager.
+func (b *BalanceCache) GetAddressTxs(ctx context.Context, query block_manager.AddressTxQuery) (block_manager.AddressTxPage, error) {
+	return b.BlockM.GetAddressTxs(ctx, query)
+}
+
+// GetOutpoint returns the status of a transaction output
+func (b *BalanceCache) GetOutpoint(ctx context.Context, id storage.TxOutId) (block_manager.Outpoint, error) {
+	return b.BlockM.GetOutpoint(ctx, id)
+}
+
+// GetUnconfirmedBalance returns the balance delta from unconfirmed transactions
+// (always 0 when mempool tracking is disabled)
+func (b *BalanceCache) GetUnconfirmedBalance(address string) (balance int64, err error) {
+	if b.Mempool == nil {
+		return 0, nil
+	}
+	return b.Mempool.GetBalance(address)
 }
 
+// Stop cancels the balance routine and waits until it has exited
 func (b *BalanceCache) Stop() {
-	confirmationCh := make(chan bool)
-	b.StopChan <- confirmationCh
-	<- confirmationCh
-	close(confirmationCh)
+	b.cancel()
+	<- b.done
 }
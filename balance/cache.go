@@ -2,6 +2,9 @@ package balance
 
 
 import (
+	"context"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/secnot/simplelru"
 	"github.com/secnot/gobalance/block_manager"
 	"github.com/secnot/gobalance/primitives"
@@ -10,10 +13,25 @@ import (
 
 
 
+// minConfKey is the cache key for balances with min confirmations, they are
+// only valid for the chain tip they were retrieved at so they can't be updated
+// with each block.
+type minConfKey struct {
+	address string
+	minconf int
+	tip     chainhash.Hash
+}
+
+// Cache caches the balances with min confirmations, plain balances are read
+// from the block manager which already caches the stored balances.
 type Cache struct {
 	size int
 	manager block_manager.BlockManagerInterface
 	cache *simplelru.LRUCache
+
+	// Last block received, unknown (zero) until the first one
+	tip    chainhash.Hash
+	height int64
 }
 
 //
@@ -22,51 +40,60 @@ func NewCache(size int, manager block_manager.BlockManagerInterface) *Cache {
 		size:    size,
 		manager: manager,
 		cache:   simplelru.NewLRUCache(size, 1000),
+		height:  -1,
 	}
 }
 
-//
-func (c *Cache) addBalance(addr string, balance int64, tx *primitives.Tx) {
-	if currentBalance, ok := c.cache.Get(addr); ok {
-		c.cache.Set(addr, currentBalance.(int64)+balance)
-	}
+// NewBlock updates the cache tip
+func (c *Cache) NewBlock(block *primitives.Block) {
+	c.tip    = block.Hash
+	c.height = int64(block.Height)
 }
 
-//
-func (c *Cache) remBalance(addr string, balance int64, tx *primitives.Tx) {
-	if currentBalance, ok := c.cache.Get(addr); ok {
-		c.cache.Set(addr, currentBalance.(int64)-balance)
-	}
+// Bactrack a block from cache
+func (c *Cache) Backtrack(block *primitives.Block) {
+	c.tip    = block.PrevHash
+	c.height = int64(block.Height) - 1
 }
 
-
-// NewBlock adds a new block to cache
-func (c *Cache) NewBlock(block *primitives.Block) {
-
-	for _, tx := range block.Transactions {
-		tx.ForEachAddress(c.addBalance)
-	}
+// state returns the chain state of the cache tip
+func (c *Cache) state() block_manager.ChainState {
+	return block_manager.ChainState{Height: c.height, Hash: c.tip}
 }
 
-// Bactrack a block from cache
-func (c *Cache) Backtrack(block *primitives.Block) {
-	for _, tx := range block.Transactions {
-		tx.ForEachAddress(c.remBalance)
+// GetBalance returns the address balance with at least minconf confirmations
+// and the chain state it was computed at. Balances with more than one
+// confirmation are cached for the chain tip they were computed at.
+func (c *Cache) GetBalance(ctx context.Context, address string, minconf int) (int64, block_manager.ChainState, error) {
+	if minconf <= 1 {
+		balance, state, err := c.manager.ReadBalance(ctx, address)
+		if err != block_manager.ErrCommitInProgress {
+			return balance, state, err
+		}
+		return c.manager.GetBalance(ctx, address, 0)
 	}
-}
 
-func (c *Cache) GetBalance(address string) int64 {
-	
-	// Check cache fro balance
-	if balance, ok := c.cache.Get(address); ok{
-		return balance.(int64)
+	var zero chainhash.Hash
+	key := minConfKey{address: address, minconf: minconf, tip: c.tip}
+	if balance, ok := c.cache.Get(key); ok && c.tip != zero {
+		return balance.(int64), c.state(), nil
 	}
 
-	// If there was a cache miss retrieve balance from block_manager
-	balance := c.manager.GetBalance(address)
+	balance, state, err := c.manager.GetBalance(ctx, address, minconf)
+	if err != nil {
+		return 0, state, err
+	}
 
-	c.cache.Set(address, balance)
-	return balance
+	c.cache.Set(minConfKey{address: address, minconf: minconf, tip: state.Hash}, balance)
+	return balance, state, nil
 }
 
-
+// GetBalances returns the balance of several addresses and the chain state
+// they were all computed at.
+func (c *Cache) GetBalances(ctx context.Context, addresses []string) (map[string]int64, block_manager.ChainState, error) {
+	balances, state, err := c.manager.ReadBalances(ctx, addresses)
+	if err != block_manager.ErrCommitInProgress {
+		return balances, state, err
+	}
+	return c.manager.GetBalances(ctx, addresses)
+}
@@ -0,0 +1,114 @@
+package balance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/primitives"
+)
+
+// mockManager returns balances from a map at a fixed state, other methods
+// aren't used
+type mockManager struct {
+	block_manager.BlockManagerInterface
+	balances map[string]int64
+	state    block_manager.ChainState
+
+	// Requests through the manager routine, and snapshot reads failing
+	requests   int
+	committing bool
+}
+
+func (m *mockManager) ReadBalance(ctx context.Context, address string) (int64, block_manager.ChainState, error) {
+	if m.committing {
+		return 0, block_manager.ChainState{}, block_manager.ErrCommitInProgress
+	}
+	return m.balances[address], m.state, nil
+}
+
+func (m *mockManager) ReadBalances(ctx context.Context, addresses []string) (map[string]int64, block_manager.ChainState, error) {
+	if m.committing {
+		return nil, block_manager.ChainState{}, block_manager.ErrCommitInProgress
+	}
+	balances := make(map[string]int64, len(addresses))
+	for _, addr := range addresses {
+		balances[addr] = m.balances[addr]
+	}
+	return balances, m.state, nil
+}
+
+func (m *mockManager) GetBalance(ctx context.Context, address string, minconf int) (int64, block_manager.ChainState, error) {
+	m.requests++
+	return m.balances[address], m.state, nil
+}
+
+func (m *mockManager) GetBalances(ctx context.Context, addresses []string) (map[string]int64, block_manager.ChainState, error) {
+	m.requests++
+	balances := make(map[string]int64, len(addresses))
+	for _, addr := range addresses {
+		balances[addr] = m.balances[addr]
+	}
+	return balances, m.state, nil
+}
+
+// mockBlock returns a block paying value to address
+func mockBlock(height uint64, prev chainhash.Hash, address string, value int64) *primitives.Block {
+	hash := chainhash.Hash{byte(height), 1}
+	tx := primitives.NewTx(&hash)
+	tx.AddOut(primitives.NewTxOut(&hash, 0, address, value))
+	return &primitives.Block {
+		Hash:         chainhash.Hash{byte(height)},
+		PrevHash:     prev,
+		Height:       height,
+		Transactions: []*primitives.Tx{tx},
+	}
+}
+
+// Test min confirmations balances are cached for the tip they were retrieved at
+func TestCacheMinConf(t *testing.T) {
+	block1 := mockBlock(1, chainhash.Hash{}, "addr1", 5)
+	block2 := mockBlock(2, block1.Hash, "addr1", 7)
+	manager := &mockManager {
+		balances: map[string]int64{"addr1": 12},
+		state:    block_manager.ChainState{Height: 2, Hash: block2.Hash},
+	}
+
+	// Manager ahead of the cache
+	c := NewCache(100, manager)
+	c.NewBlock(block1)
+	c.GetBalance(context.Background(), "addr1", 3)
+	balance, state, err := c.GetBalance(context.Background(), "addr1", 3)
+	if err != nil || balance != 12 || state != manager.state || manager.requests != 2 {
+		t.Errorf("GetBalance(): Unexpected %v %v %v after %v requests", balance, state, err, manager.requests)
+	}
+
+	// Cache up to date with the manager
+	c.NewBlock(block2)
+	balance, state, _ = c.GetBalance(context.Background(), "addr1", 3)
+	if manager.requests != 2 || balance != 12 || state != manager.state {
+		t.Errorf("GetBalance(): Unexpected cached %v %v after %v requests", balance, state, manager.requests)
+	}
+}
+
+// Test plain balances are read from the manager snapshot, or requested from
+// its routine while committing
+func TestCacheReadBalance(t *testing.T) {
+	manager := &mockManager {
+		balances: map[string]int64{"addr1": 12},
+		state:    block_manager.ChainState{Height: 2, Hash: chainhash.Hash{2}},
+	}
+	c := NewCache(100, manager)
+
+	balances, state, err := c.GetBalances(context.Background(), []string{"addr1", "addr2"})
+	if err != nil || balances["addr1"] != 12 || state != manager.state || manager.requests != 0 {
+		t.Errorf("GetBalances(): Unexpected %v %v %v", balances, state, err)
+	}
+
+	manager.committing = true
+	balance, state, err := c.GetBalance(context.Background(), "addr1", 0)
+	if err != nil || balance != 12 || state != manager.state || manager.requests != 1 {
+		t.Errorf("GetBalance(): Unexpected %v %v %v after %v requests", balance, state, err, manager.requests)
+	}
+}
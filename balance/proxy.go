@@ -0,0 +1,218 @@
+package balance
+
+import (
+	"fmt"
+	"bytes"
+	"errors"
+	"context"
+	"time"
+	"net"
+	"net/url"
+	"net/http"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/block_manager"
+)
+
+const (
+	// Max number of requests proxied to other peers at the same time
+	MaxProxyRequests = 20
+
+	// Max number of different peers tried for each proxied balance
+	ProxyRetries = 3
+)
+
+var (
+	// No peer could serve the request, it must wait for the local commit
+	ErrNoProxyPeer = errors.New("No peer available to proxy request")
+
+	// The peer was reachable but didn't return a balance
+	errPeerStatus = errors.New("Peer returned an error status")
+)
+
+//
+var proxyClient = &http.Client {
+	Timeout:    2 * time.Second,
+	Transport : &http.Transport{MaxIdleConnsPerHost: 20},
+}
+
+// proxy launches the proxy function if there is room for another proxied
+// request, otherwise serve is delayed until the local commit finishes.
+func (b *BalanceCache) proxy(serve func(), proxyFunc func()) {
+	select {
+	case b.proxySem <- struct{}{}:
+		go func() {
+			defer func() { <- b.proxySem }()
+			proxyFunc()
+		}()
+	default:
+		b.waiting = append(b.waiting, serve)
+	}
+}
+
+// fallback sends serve back to the balance routine to wait for the commit
+func (b *BalanceCache) fallback(serve func()) {
+	b.fallbackChan <- serve
+}
+
+// proxyBalanceRequest responds a balance request from another peer
+func (b *BalanceCache) proxyBalanceRequest(request BalanceRequest, serve func()) {
+	balance, state, err := b.proxyBalance(request.Ctx, request.Address, request.MinConf, request.IP)
+	if err == ErrNoProxyPeer {
+		b.fallback(serve)
+		return
+	}
+	request.ResponseCh <- BalanceResponse{balance: balance, state: state, err: err}
+}
+
+// proxyBalancesRequest responds a multiple balance request from other peers,
+// all the addresses are requested from the same peer with its batch api.
+func (b *BalanceCache) proxyBalancesRequest(request BalancesRequest, serve func()) {
+	var balances map[string]int64
+	var state block_manager.ChainState
+	err := b.proxyPeers(request.Ctx, request.IP, func(peer string) (err error) {
+		balances, state, err = requestPeerBalances(request.Ctx, peer, request.Addresses)
+		return err
+	})
+	if err == ErrNoProxyPeer {
+		b.fallback(serve)
+		return
+	}
+	request.ResponseCh <- BalancesResponse{balances: balances, state: state, err: err}
+}
+
+// proxyBalance requests an address balance from other peers. The chain state
+// is the one reported by the peer (zero hash if it didn't).
+func (b *BalanceCache) proxyBalance(ctx context.Context, address string, minconf int, ip net.IP) (int64, block_manager.ChainState, error) {
+	var balance int64
+	var state block_manager.ChainState
+	err := b.proxyPeers(ctx, ip, func(peer string) (err error) {
+		balance, state, err = requestPeerBalance(ctx, peer, address, minconf)
+		return err
+	})
+	if err != nil {
+		return 0, block_manager.ChainState{}, err
+	}
+	return balance, state, nil
+}
+
+// proxyPeers calls request with other peers until one succeeds, the first one
+// is the peer assigned to the requester ip and the rest are tried in round
+// robin. Peers that can't be reached are reported to the peer manager.
+func (b *BalanceCache) proxyPeers(ctx context.Context, ip net.IP, request func(peer string) error) error {
+	tried := make(map[string]bool, ProxyRetries)
+	for attempt := 0; attempt < ProxyRetries; attempt++ {
+		var peer string
+		var err error
+		if attempt == 0 {
+			peer, err = b.PeerM.GetPeerPersistent(ip.String())
+		} else {
+			peer, err = b.PeerM.GetPeer()
+		}
+		if err != nil || tried[peer] {
+			break
+		}
+		tried[peer] = true
+
+		err = request(peer)
+		switch {
+		case err == nil:
+			return nil
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != errPeerStatus:
+			b.PeerM.MarkPeerUnreachable(peer)
+		}
+	}
+	return ErrNoProxyPeer
+}
+
+// requestPeerBalance retrieves an address balance and chain state from a peer
+// balance api
+func requestPeerBalance(ctx context.Context, peer string, address string, minconf int) (int64, block_manager.ChainState, error) {
+	reqUrl := fmt.Sprintf("http://%s/%s/%s", peer, api_common.BalancePath, url.PathEscape(address))
+	if minconf > 1 {
+		reqUrl = fmt.Sprintf("%s?minconf=%v", reqUrl, minconf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl, nil)
+	if err != nil {
+		return 0, block_manager.ChainState{}, err
+	}
+
+	resp, err := proxyClient.Do(req)
+	if err != nil {
+		return 0, block_manager.ChainState{}, err
+	}
+	defer resp.Body.Close()
+	defer ioutil.ReadAll(resp.Body) // Exhaust body data
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, block_manager.ChainState{}, errPeerStatus
+	}
+
+	var response api_common.Address
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return 0, block_manager.ChainState{}, errPeerStatus
+	}
+
+	state := block_manager.ChainState{Height: response.Height}
+	if hash, err := chainhash.NewHashFromStr(response.BlockHash); err == nil && response.BlockHash != "" {
+		state.Hash = *hash
+	}
+	return response.Balance, state, nil
+}
+
+// requestPeerBalances retrieves the balance of several addresses and the chain
+// state from a peer batch balance api
+func requestPeerBalances(ctx context.Context, peer string, addresses []string) (map[string]int64, block_manager.ChainState, error) {
+	reqUrl := fmt.Sprintf("http://%s/%s", peer, api_common.BalancesPath)
+	body, err := json.Marshal(api_common.BalancesRequest{Addresses: addresses})
+	if err != nil {
+		return nil, block_manager.ChainState{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, block_manager.ChainState{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := proxyClient.Do(req)
+	if err != nil {
+		return nil, block_manager.ChainState{}, err
+	}
+	defer resp.Body.Close()
+	defer ioutil.ReadAll(resp.Body) // Exhaust body data
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, block_manager.ChainState{}, errPeerStatus
+	}
+
+	var response api_common.Balances
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, block_manager.ChainState{}, errPeerStatus
+	}
+
+	balances := make(map[string]int64, len(response.Balances))
+	for _, balance := range response.Balances {
+		if balance.Error != "" {
+			return nil, block_manager.ChainState{}, errPeerStatus
+		}
+		balances[balance.Address] = balance.Balance
+	}
+	for _, addr := range addresses {
+		if _, ok := balances[addr]; !ok {
+			return nil, block_manager.ChainState{}, errPeerStatus
+		}
+	}
+
+	state := block_manager.ChainState{Height: response.Height}
+	if hash, err := chainhash.NewHashFromStr(response.BlockHash); err == nil && response.BlockHash != "" {
+		state.Hash = *hash
+	}
+	return balances, state, nil
+}
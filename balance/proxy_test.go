@@ -0,0 +1,136 @@
+package balance
+
+import (
+	"fmt"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// mockPeerManager returns peers in round robin and records unreachable marks
+type mockPeerManager struct {
+	peers       []string
+	next        int
+	unreachable []string
+}
+
+func (m *mockPeerManager) Start() error { return nil }
+func (m *mockPeerManager) Stop() {}
+func (m *mockPeerManager) SetCommitting(flag bool) {}
+func (m *mockPeerManager) CommittingPeers() int { return 0 }
+
+func (m *mockPeerManager) MarkPeerUnreachable(peer string) {
+	m.unreachable = append(m.unreachable, peer)
+}
+
+func (m *mockPeerManager) GetPeer() (string, error) {
+	if len(m.peers) == 0 {
+		return "", ErrNoProxyPeer
+	}
+	peer := m.peers[m.next % len(m.peers)]
+	m.next++
+	return peer, nil
+}
+
+func (m *mockPeerManager) GetPeerPersistent(id string) (string, error) {
+	return m.GetPeer()
+}
+
+// balanceServer returns a peer api that replies with the balance or status
+func balanceServer(t *testing.T, balance int64, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/address/an_address") {
+			t.Errorf("Unexpected proxied path %v", r.URL.Path)
+		}
+		if status != http.StatusOK {
+			http.Error(w, "error", status)
+			return
+		}
+		fmt.Fprintf(w, `{"address": "an_address", "balance": %v, "height": 7, "block_hash": "%v"}`,
+			balance, chainhash.Hash{7})
+	}))
+}
+
+// serverPeer returns the server address as a peer
+func serverPeer(server *httptest.Server) string {
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// Test failed peers are skipped and unreachable ones reported
+func TestProxyBalanceRetries(t *testing.T) {
+	failing := balanceServer(t, 0, http.StatusServiceUnavailable)
+	defer failing.Close()
+	working := balanceServer(t, 1234, http.StatusOK)
+	defer working.Close()
+
+	// Closed listener, connections are refused
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	unreachable := listener.Addr().String()
+	listener.Close()
+
+	peerM := &mockPeerManager{peers: []string{unreachable, serverPeer(failing), serverPeer(working)}}
+	b := &BalanceCache{PeerM: peerM}
+
+	balance, state, err := b.proxyBalance(context.Background(), "an_address", 0, nil)
+	if err != nil || balance != 1234 {
+		t.Errorf("proxyBalance(): Expecting 1234 returned %v %v", balance, err)
+	}
+	if state.Height != 7 || state.Hash != (chainhash.Hash{7}) {
+		t.Errorf("proxyBalance(): Unexpected peer chain state %v", state)
+	}
+	if len(peerM.unreachable) != 1 || peerM.unreachable[0] != unreachable {
+		t.Errorf("proxyBalance(): Unexpected unreachable peers %v", peerM.unreachable)
+	}
+}
+
+// Test ErrNoProxyPeer is returned when no peer can serve the request
+func TestProxyBalanceNoPeer(t *testing.T) {
+	failing := balanceServer(t, 0, http.StatusInternalServerError)
+	defer failing.Close()
+
+	// Peers aren't retried
+	peerM := &mockPeerManager{peers: []string{serverPeer(failing)}}
+	b := &BalanceCache{PeerM: peerM}
+	if _, _, err := b.proxyBalance(context.Background(), "an_address", 0, nil); err != ErrNoProxyPeer {
+		t.Errorf("proxyBalance(): Expecting ErrNoProxyPeer returned %v", err)
+	}
+	if peerM.next != 2 || len(peerM.unreachable) != 0 {
+		t.Errorf("proxyBalance(): Unexpected peer requests %v unreachable %v", peerM.next, peerM.unreachable)
+	}
+
+	// No peers
+	b = &BalanceCache{PeerM: &mockPeerManager{}}
+	if _, _, err := b.proxyBalance(context.Background(), "an_address", 0, nil); err != ErrNoProxyPeer {
+		t.Errorf("proxyBalance(): Expecting ErrNoProxyPeer returned %v", err)
+	}
+}
+
+// Test multiple balances are requested from a single peer with the batch api
+func TestProxyBalancesRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/addresses/balance" {
+			t.Errorf("Unexpected proxied request %v %v", r.Method, r.URL.Path)
+		}
+		fmt.Fprintf(w, `{"height": 7, "block_hash": "%v", "balances": [` +
+			`{"address": "addr1", "balance": 10}, {"address": "addr2", "balance": 20}]}`, chainhash.Hash{7})
+	}))
+	defer server.Close()
+
+	b := &BalanceCache{PeerM: &mockPeerManager{peers: []string{serverPeer(server)}}}
+	responseCh := make(chan BalancesResponse, 1)
+	request := BalancesRequest{Ctx: context.Background(), Addresses: []string{"addr1", "addr2"}, ResponseCh: responseCh}
+	b.proxyBalancesRequest(request, func() { t.Error("Unexpected fallback") })
+
+	response := <-responseCh
+	if response.err != nil || response.balances["addr1"] != 10 || response.balances["addr2"] != 20 {
+		t.Errorf("proxyBalancesRequest(): Unexpected response %v", response)
+	}
+	if response.state.Height != 7 || response.state.Hash != (chainhash.Hash{7}) {
+		t.Errorf("proxyBalancesRequest(): Unexpected peer chain state %v", response.state)
+	}
+}
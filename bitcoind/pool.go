@@ -0,0 +1,453 @@
+package bitcoind
+
+import (
+	"log"
+	"sync"
+	"time"
+	"errors"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// Backend selection policy
+type Policy string
+
+const (
+	// Always use the first healthy backend in configuration order
+	FailoverPolicy   Policy = "failover"
+
+	// Rotate requests between all the healthy backends
+	RoundRobinPolicy Policy = "roundrobin"
+)
+
+const (
+	// Period between backend health checks
+	DefaultHealthCheckPeriod = 10*time.Second
+
+	// Max number of blocks a backend can be behind the best backend before
+	// it is considered unhealthy
+	DefaultMaxLag = 3
+)
+
+var (
+	ErrNoBackendAvailable = errors.New("bitcoind: No backend available")
+	ErrBackendsDisagree   = errors.New("bitcoind: Backends disagree on block hash")
+	ErrUnknownPolicy      = errors.New("bitcoind: Unknown backend policy")
+)
+
+
+// Backend is a single bitcoind RPC server
+type Backend struct {
+	sync.RWMutex
+
+	// host:port of the RPC server
+	host string
+
+	// RPC client (HTTP POST mode)
+	client *rpcclient.Client
+
+	// Backend responded to the last request or health check
+	healthy bool
+
+	// Number of blocks in the backend best chain at the last health check
+	height int64
+
+	// Consecutive failed requests/health checks
+	failures uint32
+}
+
+// newBackend initializes a Backend, it isn't healthy until the first health check
+func newBackend(config rpcclient.ConnConfig) (*Backend, error) {
+
+	// The notification parameter is nil since notifications are
+	// not supported in HTTP POST mode.
+	client, err := rpcclient.New(&config, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backend{
+		host:     config.Host,
+		client:   client,
+		healthy:  false,
+		height:   -1,
+		failures: 0,
+	}, nil
+}
+
+// Host returns the backend host:port
+func (b *Backend) Host() string {
+	return b.host
+}
+
+// Client returns backend rpc client
+func (b *Backend) Client() *rpcclient.Client {
+	return b.client
+}
+
+// Healthy returns true if the backend was reachable and synced on the last check
+func (b *Backend) Healthy() bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.healthy
+}
+
+// Height returns the backend block count at the last check
+func (b *Backend) Height() int64 {
+	b.RLock()
+	defer b.RUnlock()
+	return b.height
+}
+
+// setHealthy marks backend reachable with the given height
+func (b *Backend) setHealthy(height int64) {
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.healthy {
+		log.Printf("bitcoind: %v is available (height %v)", b.host, height)
+	}
+	b.healthy  = true
+	b.height   = height
+	b.failures = 0
+}
+
+// setUnhealthy marks backend unreachable or lagging
+func (b *Backend) setUnhealthy(reason error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.healthy {
+		log.Printf("bitcoind: %v is unavailable (%v)", b.host, reason)
+	}
+	b.healthy   = false
+	b.failures += 1
+}
+
+
+// Pool of bitcoind backends with health checks and failover
+type Pool struct {
+	sync.Mutex
+
+	// Backend selection policy
+	Policy Policy
+
+	// Period between health checks
+	HealthCheckPeriod time.Duration
+
+	// Max blocks a backend can lag behind the best one and still be used
+	MaxLag int64
+
+	// Configured backends, in priority order
+	backends []*Backend
+
+	// Next backend for round robin policy
+	next int
+
+	// Stop health check routine
+	stopChan chan chan bool
+
+	started bool
+}
+
+// NewPool creates a pool of backends, one for each configuration
+func NewPool(configs []rpcclient.ConnConfig, policy Policy) (*Pool, error) {
+
+	if len(configs) == 0 {
+		return nil, ErrNoBackendAvailable
+	}
+
+	if policy != FailoverPolicy && policy != RoundRobinPolicy {
+		return nil, ErrUnknownPolicy
+	}
+
+	backends := make([]*Backend, 0, len(configs))
+	for _, config := range configs {
+		backend, err := newBackend(config)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, backend)
+	}
+
+	return &Pool{
+		Policy:            policy,
+		HealthCheckPeriod: DefaultHealthCheckPeriod,
+		MaxLag:            DefaultMaxLag,
+		backends:          backends,
+		next:              0,
+	}, nil
+}
+
+// Start runs a first health check and launches the health check routine
+func (p *Pool) Start() {
+	p.Lock()
+	if p.started {
+		p.Unlock()
+		return
+	}
+	if p.HealthCheckPeriod <= 0 {
+		p.HealthCheckPeriod = DefaultHealthCheckPeriod
+	}
+	p.stopChan = make(chan chan bool)
+	p.started  = true
+	p.Unlock()
+
+	p.CheckHealth()
+	go p.healthRoutine()
+}
+
+// Stop health check routine and shutdown all the clients
+func (p *Pool) Stop() {
+	p.Lock()
+	if !p.started {
+		p.Unlock()
+		return
+	}
+	p.started = false
+	stopChan := p.stopChan
+	p.Unlock()
+
+	doneCh := make(chan bool)
+	stopChan <- doneCh
+	<-doneCh
+
+	for _, backend := range p.backends {
+		backend.client.Shutdown()
+	}
+}
+
+// healthRoutine checks backends health periodically
+func (p *Pool) healthRoutine() {
+	ticker := time.NewTicker(p.HealthCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.CheckHealth()
+
+		case ch := <-p.stopChan:
+			ch <- true
+			return
+		}
+	}
+}
+
+// CheckHealth requests the block count to all the backends, backends that
+// don't respond or lag behind the best one are marked unhealthy.
+func (p *Pool) CheckHealth() {
+
+	heights := make([]int64, len(p.backends))
+	errs    := make([]error, len(p.backends))
+
+	// Query all backends in parallel
+	var wg sync.WaitGroup
+	for n, backend := range p.backends {
+		wg.Add(1)
+		go func(n int, backend *Backend) {
+			defer wg.Done()
+			heights[n], errs[n] = backend.client.GetBlockCount()
+		}(n, backend)
+	}
+	wg.Wait()
+
+	// Best known height
+	var best int64 = -1
+	for n, _ := range p.backends {
+		if errs[n] == nil && heights[n] > best {
+			best = heights[n]
+		}
+	}
+
+	for n, backend := range p.backends {
+		switch {
+		case errs[n] != nil:
+			backend.setUnhealthy(errs[n])
+		case best - heights[n] > p.MaxLag:
+			backend.setUnhealthy(errors.New("lagging behind best backend"))
+		default:
+			backend.setHealthy(heights[n])
+		}
+	}
+}
+
+// healthy returns all the healthy backends in priority order
+func (p *Pool) healthy() []*Backend {
+	healthy := make([]*Backend, 0, len(p.backends))
+	for _, backend := range p.backends {
+		if backend.Healthy() {
+			healthy = append(healthy, backend)
+		}
+	}
+	return healthy
+}
+
+// Backends returns healthy backends ordered by preference according to the
+// pool policy, the first one should be used and the rest tried on failure.
+func (p *Pool) Backends() []*Backend {
+	healthy := p.healthy()
+	if len(healthy) == 0 || p.Policy == FailoverPolicy {
+		return healthy
+	}
+
+	// Round robin: rotate starting backend
+	p.Lock()
+	start := p.next % len(healthy)
+	p.next = (p.next + 1) % len(p.backends)
+	p.Unlock()
+
+	return append(healthy[start:], healthy[:start]...)
+}
+
+// MarkFailed marks backend unhealthy after a failed request, until the next
+// successful health check.
+func (p *Pool) MarkFailed(backend *Backend, err error) {
+	backend.setUnhealthy(err)
+}
+
+// BlockCount returns the height all healthy backends have reached
+func (p *Pool) BlockCount() (int64, error) {
+	healthy := p.healthy()
+	if len(healthy) == 0 {
+		return -1, ErrNoBackendAvailable
+	}
+
+	var count int64 = -1
+	for _, backend := range healthy {
+		height, err := backend.client.GetBlockCount()
+		if err != nil {
+			p.MarkFailed(backend, err)
+			continue
+		}
+		backend.setHealthy(height)
+		if count == -1 || height < count {
+			count = height
+		}
+	}
+
+	if count == -1 {
+		return -1, ErrNoBackendAvailable
+	}
+	return count, nil
+}
+
+// BlockHash returns the hash of the block at the given height, all the healthy
+// backends that have reached that height must agree on it.
+func (p *Pool) BlockHash(height int64) (*chainhash.Hash, error) {
+	var agreed *chainhash.Hash = nil
+
+	for _, backend := range p.Backends() {
+		if backend.Height() < height {
+			continue
+		}
+
+		hash, err := backend.client.GetBlockHash(height)
+		if err != nil {
+			p.MarkFailed(backend, err)
+			continue
+		}
+
+		if agreed == nil {
+			agreed = hash
+		} else if !agreed.IsEqual(hash) {
+			log.Printf("bitcoind: %v block %v hash %v != %v", backend.host, height, hash, agreed)
+			return nil, ErrBackendsDisagree
+		}
+	}
+
+	if agreed == nil {
+		return nil, ErrNoBackendAvailable
+	}
+	return agreed, nil
+}
+
+// Block retrieves a block, trying all healthy backends until one succeeds
+func (p *Pool) Block(hash *chainhash.Hash) (*wire.MsgBlock, error) {
+
+	for _, backend := range p.Backends() {
+		block, err := backend.client.GetBlock(hash)
+		if err != nil {
+			p.MarkFailed(backend, err)
+			continue
+		}
+		return block, nil
+	}
+
+	return nil, ErrNoBackendAvailable
+}
+
+// BlockHeader retrieves a block header with its height, trying all healthy
+// backends until one succeeds or reports the block is unknown
+func (p *Pool) BlockHeader(hash *chainhash.Hash) (*btcjson.GetBlockHeaderVerboseResult, error) {
+
+	for _, backend := range p.Backends() {
+		header, err := backend.client.GetBlockHeaderVerbose(hash)
+		if _, ok := err.(*btcjson.RPCError); ok {
+			// Unknown block, it isn't a backend failure
+			return nil, err
+		}
+		if err != nil {
+			p.MarkFailed(backend, err)
+			continue
+		}
+		return header, nil
+	}
+
+	return nil, ErrNoBackendAvailable
+}
+
+// RawMempool returns the hashes of all the transactions in the mempool of
+// one of the healthy backends.
+func (p *Pool) RawMempool() ([]*chainhash.Hash, error) {
+
+	for _, backend := range p.Backends() {
+		hashes, err := backend.client.GetRawMempool()
+		if err != nil {
+			p.MarkFailed(backend, err)
+			continue
+		}
+		return hashes, nil
+	}
+
+	return nil, ErrNoBackendAvailable
+}
+
+// RawTransaction retrieves a transaction, when the transaction isn't in
+// the backend mempool the node must have txindex enabled.
+func (p *Pool) RawTransaction(hash *chainhash.Hash) (*wire.MsgTx, error) {
+	var lastErr error = ErrNoBackendAvailable
+
+	for _, backend := range p.Backends() {
+		tx, err := backend.client.GetRawTransaction(hash)
+		if err != nil {
+			// The transaction may have left the mempool, it isn't a backend failure
+			lastErr = err
+			continue
+		}
+		return tx.MsgTx(), nil
+	}
+
+	return nil, lastErr
+}
+
+// RawTransactionVerbose retrieves a transaction with the hash of the block
+// containing it (empty while in the mempool), the same as RawTransaction the
+// node must have txindex enabled for confirmed transactions.
+func (p *Pool) RawTransactionVerbose(hash *chainhash.Hash) (*btcjson.TxRawResult, error) {
+	var lastErr error = ErrNoBackendAvailable
+
+	for _, backend := range p.Backends() {
+		tx, err := backend.client.GetRawTransactionVerbose(hash)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return tx, nil
+	}
+
+	return nil, lastErr
+}
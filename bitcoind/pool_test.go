@@ -0,0 +1,233 @@
+package bitcoind
+
+import (
+	"bytes"
+	"sync"
+	"strings"
+	"testing"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// fakeBitcoind is a minimal bitcoind JSON-RPC server serving a chain of empty blocks
+type fakeBitcoind struct {
+	sync.Mutex
+	server *httptest.Server
+	chain  []*wire.MsgBlock
+
+	// Number of requests received by method
+	calls map[string]int
+}
+
+// mockChain generates a chain of empty blocks, the nonce is used to generate
+// different chains with the same length.
+func mockChain(length int, nonce uint32) []*wire.MsgBlock {
+	chain := make([]*wire.MsgBlock, length)
+	prev := chainhash.Hash{}
+	for n := 0; n < length; n++ {
+		header := wire.BlockHeader{PrevBlock: prev, Nonce: nonce + uint32(n)}
+		chain[n] = wire.NewMsgBlock(&header)
+		prev = header.BlockHash()
+	}
+	return chain
+}
+
+func newFakeBitcoind(chain []*wire.MsgBlock) *fakeBitcoind {
+	f := &fakeBitcoind{chain: chain, calls: make(map[string]int)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.handler))
+	return f
+}
+
+func (f *fakeBitcoind) host() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+func (f *fakeBitcoind) handler(writer http.ResponseWriter, request *http.Request) {
+	var req struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+		Id     interface{}       `json:"id"`
+	}
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.Lock()
+	f.calls[req.Method] += 1
+	f.Unlock()
+
+	var result interface{}
+	switch req.Method {
+	case "getblockcount":
+		result = len(f.chain) - 1
+
+	case "getblockhash":
+		var height int
+		json.Unmarshal(req.Params[0], &height)
+		result = f.chain[height].BlockHash().String()
+
+	case "getblock":
+		var hash string
+		json.Unmarshal(req.Params[0], &hash)
+		for _, block := range f.chain {
+			if block.BlockHash().String() == hash {
+				var buf bytes.Buffer
+				block.Serialize(&buf)
+				result = hex.EncodeToString(buf.Bytes())
+			}
+		}
+	}
+
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"result": result,
+		"error":  nil,
+		"id":     req.Id,
+	})
+}
+
+func newTestPool(t *testing.T, policy Policy, servers ...*fakeBitcoind) *Pool {
+	configs := make([]rpcclient.ConnConfig, len(servers))
+	for n, server := range servers {
+		configs[n] = rpcclient.ConnConfig{
+			Host:         server.host(),
+			User:         "user",
+			Pass:         "pass",
+			HTTPPostMode: true,
+			DisableTLS:   true,
+		}
+	}
+
+	pool, err := NewPool(configs, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.CheckHealth()
+	return pool
+}
+
+
+// Test requests fail over to the next backend when the first is down
+func TestPoolFailover(t *testing.T) {
+	chain := mockChain(10, 0)
+	first  := newFakeBitcoind(chain)
+	second := newFakeBitcoind(chain)
+	defer second.server.Close()
+
+	pool := newTestPool(t, FailoverPolicy, first, second)
+
+	// Both healthy, the first one is always used
+	hash, err := pool.BlockHash(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := pool.Block(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.BlockHash() != chain[5].BlockHash() {
+		t.Errorf("Block(): returned unexpected block %v", block.BlockHash())
+	}
+	if first.calls["getblock"] != 1 || second.calls["getblock"] != 0 {
+		t.Errorf("Block(): Expecting request to first backend")
+	}
+
+	// Shutdown first backend
+	first.server.Close()
+
+	block, err = pool.Block(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.BlockHash() != chain[5].BlockHash() {
+		t.Errorf("Block(): returned unexpected block %v", block.BlockHash())
+	}
+	if second.calls["getblock"] != 1 {
+		t.Errorf("Block(): Expecting request to second backend")
+	}
+
+	if len(pool.Backends()) != 1 {
+		t.Errorf("Backends(): Failed backend should have been discarded")
+	}
+
+	// All backends down
+	second.server.Close()
+	pool.CheckHealth()
+	if _, err := pool.BlockCount(); err != ErrNoBackendAvailable {
+		t.Errorf("BlockCount(): Expecting ErrNoBackendAvailable returned %v", err)
+	}
+}
+
+// Test round robin distributes requests between healthy backends
+func TestPoolRoundRobin(t *testing.T) {
+	chain := mockChain(10, 0)
+	first  := newFakeBitcoind(chain)
+	second := newFakeBitcoind(chain)
+	defer first.server.Close()
+	defer second.server.Close()
+
+	pool := newTestPool(t, RoundRobinPolicy, first, second)
+
+	hash := chain[3].BlockHash()
+	for n := 0; n < 10; n++ {
+		if _, err := pool.Block(&hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if first.calls["getblock"] != 5 || second.calls["getblock"] != 5 {
+		t.Errorf("Block(): Unbalanced requests %v/%v",
+			first.calls["getblock"], second.calls["getblock"])
+	}
+}
+
+// Test blocks are only returned when all healthy backends agree on them
+func TestPoolAgreement(t *testing.T) {
+	chain := mockChain(10, 0)
+	fork  := append(append([]*wire.MsgBlock{}, chain[:8]...), mockChain(2, 1000)...)
+	first  := newFakeBitcoind(chain)
+	second := newFakeBitcoind(fork)
+	defer first.server.Close()
+	defer second.server.Close()
+
+	pool := newTestPool(t, FailoverPolicy, first, second)
+
+	if _, err := pool.BlockHash(7); err != nil {
+		t.Errorf("BlockHash(7): %v", err)
+	}
+
+	if _, err := pool.BlockHash(9); err != ErrBackendsDisagree {
+		t.Errorf("BlockHash(9): Expecting ErrBackendsDisagree returned %v", err)
+	}
+}
+
+// Test backends lagging behind are not used, and the block count is the
+// height all healthy backends have reached.
+func TestPoolLagging(t *testing.T) {
+	chain := mockChain(20, 0)
+	first  := newFakeBitcoind(chain)
+	second := newFakeBitcoind(chain[:18])
+	third  := newFakeBitcoind(chain[:10])
+	defer first.server.Close()
+	defer second.server.Close()
+	defer third.server.Close()
+
+	pool := newTestPool(t, FailoverPolicy, first, second, third)
+
+	if len(pool.Backends()) != 2 {
+		t.Errorf("Backends(): Expecting 2 healthy backends returned %v", len(pool.Backends()))
+	}
+
+	count, err := pool.BlockCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 17 {
+		t.Errorf("BlockCount(): Expecting 17 returned %v", count)
+	}
+}
@@ -3,13 +3,16 @@ package block_manager
 
 import (
 	"log"
+	"sort"
+	"context"
 	"time"
 	"errors"
+	"sync/atomic"
 
-	"github.com/btcsuite/btcd/wire"
 	"github.com/secnot/gobalance/primitives"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/secnot/gobalance/crawler"
+	"github.com/secnot/gobalance/events"
 	"github.com/secnot/gobalance/block_manager/storage"
 )
 
@@ -19,16 +22,34 @@ const (
 
 	// Block commit delay
 	DefaultCommitDelay = 30*time.Second
+
+	// Period used to measure the block processing rate
+	SyncRatePeriod = 10*time.Second
+
+	// Max time waiting for crawler updates still in flight when stopping
+	StopDrainTimeout = time.Second
 )
 
-var ErrBacktrackLimit = errors.New("Backtrack limit reached")
+var (
+	ErrBacktrackLimit = errors.New("Backtrack limit reached")
+	ErrStopped        = errors.New("Block manager stopped")
+	ErrInvalidMinConf = errors.New("Min confirmations out of range")
+)
 
 type BlockManager struct {
 
+	// Incremented when a commit starts and ends (odd while committing), first
+	// field so it's 64-bit aligned for atomic operations
+	commitSeq uint64
+
 	// Sync mode flag, when in sync mode the balance is disabled to save memory
 	// and for a faster syncing
 	Sync bool
 	
+	// Decides when to commit, if nil DefaultCommitPolicy is used with 
+	// CommitSize, CommitMinBlocks and CommitDelay
+	CommitPolicy CommitPolicy
+
 	// Max number of txout cached in memory before a commit is required
 	CommitSize int
 
@@ -43,11 +64,61 @@ type BlockManager struct {
 	// Confirmations required for a block to be elegible for storage
 	Confirmations uint16
 
-	// Last block height
+	// Commit all the confirmed blocks still in the storage cache when stopped,
+	// otherwise they are fetched again on the next start.
+	CommitOnStop bool
+
+	// Max number of blocks behind bitcoind chain tip to be considered synced
+	SyncMaxLag int64
+
+	// Updates buffered for each subscriber before SubscriberPolicy is applied
+	SubscriberBufferSize int
+
+	// What to do with SubscribeExternal subscribers (the notify hub) that fall
+	// SubscriberBufferSize updates behind, the remaining ones always block.
+	SubscriberPolicy events.Policy
+
+	// Number of routines decoding blocks (0 for one per cpu)
+	DecoderWorkers int
+
+	// Indexers maintaining data derived from blocks, must be set before Start
+	Indexers []Indexer
+
+	// Archive spent outputs so the spending transaction can be queried
+	SpentArchive bool
+
+	// Max number of stored balances cached (0 for StoredBalanceCacheSize)
+	StoredBalancesSize int
+
+	// File where the stored balance cache is saved on stop and every
+	// BalanceSnapshotPeriod (never when 0) to warm it on the next start,
+	// disabled if empty.
+	BalanceSnapshotPath   string
+	BalanceSnapshotPeriod time.Duration
+
+	// Indexers by name
+	indexers map[string]Indexer
+
+	// Block decoding stage between crawler and manager routine
+	decoder *Decoder
+
+	// Last block height and hash
 	height int64
+	hash   chainhash.Hash
+
+	// Time of the last commit
+	lastCommit time.Time
+
+	// Bitcoind chain tip height (-1 until reported by crawler)
+	tipHeight int64
 
-	// Last time a block was added 
-	lastTime time.Time
+	// Block processing rate measurement
+	rateTime        time.Time
+	rateHeight      int64
+	blocksPerSecond float64
+
+	// Requests waiting until the manager is synced
+	syncWaiters []chan bool
 
 	// 
 	storageCache *storage.StorageCache
@@ -55,26 +126,35 @@ type BlockManager struct {
 	// Blocks waiting for enough confirmations before committing to storage
 	pendingBlocks *primitives.BlockQueue
 
+	// Outputs spent by pending blocks
+	pendingSpent map[storage.TxOutId]storage.SpentData
+
+	// Storage used by the lock-free read path
+	storage storage.Storage
+
+	// Balance layers for uncommitted and pending blocks, and the last
+	// snapshot built from them (*Snapshot)
+	confirmedLayers []balanceLayer
+	pendingLayers   []balanceLayer
+	snapshot        atomic.Value
+
+	// Storage balances cached for the lock-free read path
+	storedBalances *storedBalanceCache
+
 	//
 	commitTimer *time.Timer
 	commitTimerStartedFlag bool
 	
-	// subscriberts
-	subscribers map[UpdateChan] bool
+	// Block updates subscribers
+	bus *events.Bus
 	
 	// CONTROL CHANNELS
 
-	// New subscription channel
-	SubscribeChan   chan UpdateChan
-
-	// Unsubscribe existing subscription channel
-	UnsubscribeChan chan UpdateChan
-
 	// Signal crawler to start fetching.
 	StartChan       chan chan bool
 
-	// Signal crawler to stop fetching and exit.
-	StopChan        chan chan bool
+	// Cancels the manager context to stop processing and exit.
+	cancel          context.CancelFunc
 
 	// Balance request channel
 	BalanceChan     chan BalanceRequest
@@ -84,12 +164,37 @@ type BlockManager struct {
 
 	// Sync status request channel
 	SyncChan        chan chan bool
+
+	// Wait until synced request channel
+	SyncWaitChan    chan chan bool
+
+	// Sync progress request channel
+	ProgressChan    chan chan SyncProgress
+
+	// TxOut address and value request channel
+	TxOutChan       chan TxOutRequest
+
+	// Batch balance request channel
+	BalancesChan    chan BalancesRequest
+
+	// Address unspent outputs request channel
+	UtxoChan        chan UtxoRequest
+
+	// Indexer query request channel
+	IndexerQueryChan chan IndexerQueryRequest
+
+	// Outpoint status request channel
+	OutpointChan    chan OutpointRequest
+
+	// Closed once manager routine has exited
+	done            chan bool
 }
 
-// Start initializes and launches BlockManager routines
-func (b *BlockManager) Start(sto storage.Storage, blockUpdateChan crawler.UpdateChan) error {
+// Start initializes and launches BlockManager routines, they exit when ctx
+// is cancelled or Stop is called.
+func (b *BlockManager) Start(ctx context.Context, sto storage.Storage, blockUpdateChan crawler.UpdateChan) error {
 
-	cache, err := storage.NewStorageCache(sto, !b.Sync)
+	cache, err := storage.NewStorageCache(sto, !b.Sync, b.SpentArchive)
 	if err != nil {
 		return err
 	}
@@ -102,20 +207,58 @@ func (b *BlockManager) Start(sto storage.Storage, blockUpdateChan crawler.Update
 		b.CommitSize = 1
 	}
 
+	if b.CommitPolicy == nil {
+		b.CommitPolicy = &DefaultCommitPolicy {
+			MaxEntries: b.CommitSize,
+			MinBlocks:  b.CommitMinBlocks,
+			Delay:      DefaultCommitDelay+b.CommitDelay,
+		}
+	}
+
+	if b.SyncMaxLag < 0 {
+		b.SyncMaxLag = 0
+	}
+
+	if b.SubscriberBufferSize < 1 {
+		b.SubscriberBufferSize = events.DefaultBufferSize
+	}
+
+	if b.SubscriberPolicy == "" {
+		b.SubscriberPolicy = events.BlockPolicy
+	} else if !events.ValidPolicy(b.SubscriberPolicy) {
+		return events.ErrUnknownPolicy
+	}
+
+	if err := b.initIndexers(sto, cache.GetHeight()); err != nil {
+		return err
+	}
+
 	b.storageCache = cache
+	b.storage      = sto
 	b.height       = cache.GetHeight()
+	b.hash         = cache.GetHash()
+	b.tipHeight    = -1
+	b.lastCommit   = time.Now()
+	b.rateTime     = time.Now()
+	b.rateHeight   = b.height
+	b.syncWaiters  = make([]chan bool, 0)
 	
 	// Initialize subscribers
-	b.subscribers = make(map[UpdateChan]bool)
+	b.bus = events.NewBus()
 
 	// Initialize channels
-	b.SubscribeChan   = make(chan UpdateChan, 10)
-	b.UnsubscribeChan = make(chan UpdateChan)
 	b.StartChan       = make(chan chan bool)
-	b.StopChan        = make(chan chan bool)
 	b.BalanceChan     = make(chan BalanceRequest, BalanceRequestQueueSize)
 	b.HeightChan      = make(chan chan int64)
 	b.SyncChan        = make(chan chan bool)
+	b.SyncWaitChan    = make(chan chan bool)
+	b.ProgressChan    = make(chan chan SyncProgress)
+	b.TxOutChan       = make(chan TxOutRequest, TxOutRequestQueueSize)
+	b.BalancesChan    = make(chan BalancesRequest, BalancesRequestQueueSize)
+	b.UtxoChan        = make(chan UtxoRequest, UtxoRequestQueueSize)
+	b.IndexerQueryChan = make(chan IndexerQueryRequest, IndexerQueryQueueSize)
+	b.OutpointChan    = make(chan OutpointRequest, OutpointRequestQueueSize)
+	b.done            = make(chan bool)
 	
 	// Initialize timer so its channel can be added to select loop, but stop signal
 	b.commitTimer  = time.NewTimer(10*time.Second)
@@ -124,9 +267,26 @@ func (b *BlockManager) Start(sto storage.Storage, blockUpdateChan crawler.Update
 	
 	// Queue
 	b.pendingBlocks = primitives.NewBlockQueue()
+	b.pendingSpent  = make(map[storage.TxOutId]storage.SpentData)
+
+	// Lock-free read path
+	if b.StoredBalancesSize < 1 {
+		b.StoredBalancesSize = StoredBalanceCacheSize
+	}
+	b.storedBalances  = newStoredBalanceCache(b.StoredBalancesSize)
+	if b.BalanceSnapshotPath != "" {
+		b.restoreStoredBalances()
+	}
+	b.confirmedLayers = nil
+	b.pendingLayers   = nil
+	b.publishSnapshot()
 
 	// Launch main routine
-	go b.managerRoutine(blockUpdateChan)
+	// Decode blocks in parallel before they reach the manager routine
+	b.decoder = NewDecoder(blockUpdateChan, b.DecoderWorkers)
+
+	ctx, b.cancel = context.WithCancel(ctx)
+	go b.managerRoutine(ctx, b.decoder.Updates)
 	
 	return nil
 }
@@ -199,56 +359,58 @@ func (b *BlockManager) getBlockInputs(block *primitives.Block) (*primitives.Bloc
 	return block, nil
 }
 
-// buildTx returns a primitives.Tx for the MsgTx (without inputs addresses and values)
-func (b *BlockManager) buildTx(wireTx *wire.MsgTx) *primitives.Tx {
-	hash := wireTx.TxHash()
-	tx := primitives.NewTx(&hash)
+// resolveTxOuts populates the address and value of TxOuts from pending blocks
+// or storage, the ones not found are left with an empty address and 0 value.
+func (b *BlockManager) resolveTxOuts(outs []*primitives.TxOut) error {
 
-	for nout, txOut := range wireTx.TxOut {
-		address := primitives.PkScriptToAddr(txOut.PkScript)
-		txout := primitives.NewTxOut(&hash, uint32(nout), address, txOut.Value)
-		if txout != nil {
-			tx.AddOut(txout)
+	missingIds  := make([]storage.TxOutId, 0, len(outs))
+	missingOuts := make([]*primitives.TxOut, 0, len(outs))
+	
+	for _, out := range outs {
+		if tx, _ := b.pendingBlocks.Tx(*out.TxHash); tx != nil {
+			// The output is from a pending block
+			if int(out.Nout) < len(tx.Out) {
+				out.Addr  = tx.Out[out.Nout].Addr
+				out.Value = tx.Out[out.Nout].Value
+			}
+			continue
 		}
+
+		missingIds  = append(missingIds, storage.TxOutId{TxHash: *out.TxHash, Nout: out.Nout})
+		missingOuts = append(missingOuts, out)
 	}
 
-	for _, txIn := range wireTx.TxIn {
-		prevOutHash := txIn.PreviousOutPoint.Hash
-		txin := primitives.NewTxOut(&prevOutHash, uint32(txIn.PreviousOutPoint.Index), "", 0)
-		if txin != nil {
-			tx.AddIn(txin)
-		}
+	if len(missingIds) == 0 {
+		return nil
 	}
 
-	return tx
-}
+	// Get remaining outputs from storage
+	missingData, err := b.storageCache.BulkGetTxOut(missingIds)
+	if err != nil {
+		return err
+	}
 
-func (b *BlockManager) buildBlock(bHash *chainhash.Hash, block *wire.MsgBlock, height uint64) (*primitives.Block, error) {
-	
-	// Build all the block transactions (without inputs)
-	transactions := make([]*primitives.Tx, 0, len(block.Transactions))
-	for _, wireTx := range block.Transactions {
-		tx := b.buildTx(wireTx)
-		transactions = append(transactions, tx)
+	for n, out := range missingOuts {
+		out.Addr  = missingData[n].Addr
+		out.Value = missingData[n].Value
 	}
+	return nil
+}
 
-	pBlock := primitives.NewBlock(*bHash, block.Header.PrevBlock, height)
-	pBlock.Transactions = transactions
+// buildBlock returns the primitives.Block for a decoded block update
+func (b *BlockManager) buildBlock(update decodedUpdate, height uint64) (*primitives.Block, error) {
+	pBlock := primitives.NewBlock(*update.Hash, update.Block.Header.PrevBlock, height)
+	pBlock.Time         = update.Block.Header.Timestamp
+	pBlock.Transactions = update.Transactions
 
 	return pBlock, nil
 }
 
-// TimeSinceLastBlock returns the seconds elapsed since the last block was processed
-func (b *BlockManager) timeSinceLastBlock() float64 {
-	now := time.Now()
-	return now.Sub(b.lastTime).Seconds() // time since last block
-}
-
-// AddBlock adds a wire.Block to the manager returning primitives.Block equivalent
-func (b *BlockManager) addBlock(block *wire.MsgBlock, blockHash *chainhash.Hash) (*primitives.Block, error) {
+// AddBlock adds a decoded block to the manager returning primitives.Block equivalent
+func (b *BlockManager) addBlock(update decodedUpdate) (*primitives.Block, error) {
 	
 	// Generate	block and add it to pending of confirmation block queue
-	pBlock, err := b.buildBlock(blockHash, block, uint64(b.height+1))
+	pBlock, err := b.buildBlock(update, uint64(b.height+1))
 	if err != nil {
 		return nil, err
 	}
@@ -261,17 +423,26 @@ func (b *BlockManager) addBlock(block *wire.MsgBlock, blockHash *chainhash.Hash)
 	}
 
 	b.pendingBlocks.PushBack(pBlock)
+	b.addPendingSpent(pBlock)
+	b.pushLayer(pBlock)
+	if err := b.connectIndexers(pBlock); err != nil {
+		return nil, err
+	}
 
 	// Update current height
 	b.height += 1
+	b.hash = pBlock.Hash
 
 	// Add confirmed block and height to storage cache
 	if b.pendingBlocks.Len() > int(b.Confirmations) {
 		confirmedBlock := b.pendingBlocks.PopFront()
+		b.delPendingSpent(confirmedBlock)
 		b.storageCache.AddBlock(confirmedBlock)
+		b.confirmLayer()
 	}
+	b.publishSnapshot()
 
-	b.lastTime = time.Now()
+	b.updateRate()
 
 	return pBlock, nil
 }
@@ -284,6 +455,13 @@ func (b *BlockManager) backtrackBlock() (*primitives.Block, error){
 
 	b.height -= 1
 	block := b.pendingBlocks.PopBack()
+	b.hash = block.PrevHash
+	b.delPendingSpent(block)
+	b.popLayer()
+	b.publishSnapshot()
+	if err := b.disconnectIndexers(block); err != nil {
+		return nil, err
+	}
 	return block, nil
 }
 
@@ -295,52 +473,47 @@ func  (b *BlockManager) uncommittedBlocks() int {
 
 // CommitRequired returns true if it's time for a commit
 func (b *BlockManager)commitRequired() bool {
-	
-	// Check there is something to commit
-	if b.uncommittedBlocks() < 1 {
-		return false
-	}
-
-	// If the max cache size has been reached is time to commit
-	if b.storageCache.UncommittedLen() > b.CommitSize {
-		return true
-	}
-
-	// Sync mode: we need to commit the last blocks as soon as the top of the 
-	// chain is reached, so if too much time has passed since the previous block
-	// the top has been probably been reached
-	if b.Sync {
-		if b.timeSinceLastBlock() > 120.0 {
-			return true
-		}
-		return false
-	}
+	return b.CommitPolicy.CommitRequired(b.commitState())
+}
 
-	// Normal mode: commit when min uncommited blocks are reached
-	if b.uncommittedBlocks() > b.CommitMinBlocks {
-		return true
+// commitState returns the information used by the commit policy
+func (b *BlockManager) commitState() CommitState {
+	return CommitState {
+		UncommittedBlocks:  b.uncommittedBlocks(),
+		UncommittedEntries: b.storageCache.UncommittedLen(),
+		SinceLastCommit:    time.Since(b.lastCommit),
+		Synced:             b.synced(),
+		Sync:               b.Sync,
 	}
-
-	return false
 }
 
 // Commit all cached blocks to storage
 func (b *BlockManager) commit() error {	
 	log.Print("Commit: ", b.storageCache.GetHeight())
-	err := b.storageCache.Commit()
+	height := b.storageCache.GetHeight()
+	b.beginCommit()
+	err := b.storageCache.Commit(b.indexerHooks(height)...)
+	b.endCommit(err == nil)
 	if err != nil {
 		return err
 	}
-	b.lastTime = time.Now()
+	b.indexersCommitted(height)
+
+	// Don't count commit time in the block processing rate
+	b.lastCommit = time.Now()
+	b.rateTime   = time.Now()
+	b.rateHeight = b.height
 	return nil
 }
 
+// state returns the current chain state
+func (b *BlockManager) state() ChainState {
+	return ChainState{Height: b.height, Hash: b.hash}
+}
+
 // GetBalance returns address balance
-func (b *BlockManager) getBalance(address string) (int64, error) {
-	pendingBalance, ok := b.pendingBlocks.GetBalance(address)
-	if !ok {
-		pendingBalance = 0
-	}
+func (b *BlockManager) getBalance(address string, minconf int) (int64, error) {
+	pendingBalance := b.pendingBlocks.GetBalanceMinConf(address, minconf)
 	storedBalance, err  := b.storageCache.GetBalance(address)
 	if err != nil  {
 		return 0, err
@@ -349,18 +522,138 @@ func (b *BlockManager) getBalance(address string) (int64, error) {
 	return pendingBalance + storedBalance, nil
 }
 
-// Synced returns true when the manager is with the last blockchain block
+// getBalances returns the balance of several addresses including pending
+// blocks, with a single storage query.
+func (b *BlockManager) getBalances(addresses []string) (map[string]int64, error) {
+	balances, err := b.storageCache.GetBalances(addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	for addr := range balances {
+		if pendingBalance, ok := b.pendingBlocks.GetBalance(addr); ok {
+			balances[addr] += pendingBalance
+		}
+	}
+	return balances, nil
+}
+
+// getUtxos returns address unspent outputs from storage, uncommitted and
+// pending blocks, minus the ones spent in any of them.
+func (b *BlockManager) getUtxos(address string) ([]Utxo, error) {
+	outs, err := b.storageCache.GetUtxos(address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add pending blocks outputs first, so they can be removed if spent by
+	// a later pending transaction.
+	txs := b.pendingBlocks.GetTx(address)
+	for _, tx := range txs {
+		_, block := b.pendingBlocks.Tx(*tx.Hash)
+		for _, out := range tx.Out {
+			if out.Addr == address && out.Value != 0 {
+				id := storage.TxOutId{TxHash: *out.TxHash, Nout: out.Nout}
+				outs[id] = storage.TxOutData{Addr: out.Addr, Value: out.Value, Height: int64(block.Height)}
+			}
+		}
+	}
+	for _, tx := range txs {
+		for _, in := range tx.In {
+			if in.Addr == address {
+				delete(outs, storage.TxOutId{TxHash: *in.TxHash, Nout: in.Nout})
+			}
+		}
+	}
+
+	utxos := make([]Utxo, 0, len(outs))
+	for id, data := range outs {
+		utxo := Utxo {
+			TxHash: id.TxHash,
+			Nout:   id.Nout,
+			Value:  data.Value,
+			Height: data.Height,
+		}
+		if data.Height > 0 {
+			utxo.Confirmations = b.height - data.Height + 1
+		}
+		utxos = append(utxos, utxo)
+	}
+
+	sort.Slice(utxos, func(i, j int) bool {
+		if utxos[i].Height != utxos[j].Height {
+			return utxos[i].Height < utxos[j].Height
+		}
+		if utxos[i].TxHash != utxos[j].TxHash {
+			return utxos[i].TxHash.String() < utxos[j].TxHash.String()
+		}
+		return utxos[i].Nout < utxos[j].Nout
+	})
+	return utxos, nil
+}
+
+// Synced returns true when the manager is within SyncMaxLag blocks of the
+// bitcoind chain tip.
 func (b *BlockManager) synced() bool {
-	return b.timeSinceLastBlock() > 30.0
+	if b.tipHeight < 0 {
+		return false
+	}
+	return b.height >= b.tipHeight - b.SyncMaxLag
+}
+
+// updateRate updates block processing rate once every SyncRatePeriod
+func (b *BlockManager) updateRate() {
+	elapsed := time.Since(b.rateTime)
+	if elapsed < SyncRatePeriod {
+		return
+	}
+
+	b.blocksPerSecond = float64(b.height - b.rateHeight)/elapsed.Seconds()
+	if b.blocksPerSecond < 0 {
+		b.blocksPerSecond = 0
+	}
+	b.rateTime   = time.Now()
+	b.rateHeight = b.height
+}
+
+// syncProgress returns current sync progress
+func (b *BlockManager) syncProgress() SyncProgress {
+	progress := SyncProgress {
+		Height:          b.height,
+		Hash:            b.hash,
+		CommittedHeight: b.storageCache.CommittedHeight(),
+		TargetHeight:    b.tipHeight,
+		BlocksPerSecond: b.blocksPerSecond,
+		Synced:          b.synced(),
+	}
+
+	remaining := b.tipHeight - b.height
+	if !progress.Synced && remaining > 0 && b.blocksPerSecond > 0 {
+		seconds := float64(remaining)/b.blocksPerSecond
+		progress.ETA = time.Duration(seconds*float64(time.Second))
+	}
+	return progress
+}
+
+// notifySyncWaiters responds to the requests waiting for the manager to be
+// synced once it is.
+func (b *BlockManager) notifySyncWaiters() {
+	if len(b.syncWaiters) == 0 || !b.synced() {
+		return
+	}
+	for _, ch := range b.syncWaiters {
+		ch <- true
+	}
+	b.syncWaiters = b.syncWaiters[:0]
 }
 
 
 // processBlockUpdate handles raw block updates from crawler
-func (b *BlockManager) processBlockUpdate(update crawler.BlockUpdate) (BlockUpdate, error){
+func (b *BlockManager) processBlockUpdate(update decodedUpdate) (BlockUpdate, error){
 	
 	switch update.Class {
 	case crawler.OP_NEWBLOCK:
-		block, err := b.addBlock(update.Block, update.Hash)
+		block, err := b.addBlock(update)
 		return  NewBlockUpdate(OP_NEWBLOCK, block), err
 	
 	case crawler.OP_BACKTRACK:
@@ -375,20 +668,14 @@ func (b *BlockManager) processBlockUpdate(update crawler.BlockUpdate) (BlockUpda
 
 // signalSubscribers
 func (b *BlockManager) signalSubscribers(update BlockUpdate) {
-	for subscriber, _ := range b.subscribers {
-		subscriber <- update
-	}
+	b.bus.Publish(update)
 }
 
 // startCommitTimer
 func (b *BlockManager) startCommitTimer() {
 
 	// Start timer
-	if b.Sync { // In Sync mode commit as fast as possible
-		b.commitTimer.Reset(10*time.Millisecond)
-	} else {
-		b.commitTimer.Reset(DefaultCommitDelay+b.CommitDelay)
-	}
+	b.commitTimer.Reset(b.CommitPolicy.CommitDelay(b.commitState()))
 }
 
 // stopCommitTimer stop commit timer prematurely, WARNING: if it is called after
@@ -400,46 +687,106 @@ func (b *BlockManager) stopCommitTimer() {
 	}
 }
 
-// Block Manager routine handling block update and other requests
-func (b *BlockManager) managerRoutine(blockUpdateChan crawler.UpdateChan) {
+// processUpdate handles a crawler update and notifies subscribers 
+func (b *BlockManager) processUpdate(update decodedUpdate) {
+	b.tipHeight = int64(update.TipHeight)
+	defer b.notifySyncWaiters()
+
+	// Tip updates are only used to track sync status
+	if update.Class == crawler.OP_TIP {
+		return
+	}
 
-	// Block updates subscribers 
-	var subscribers = make(map[UpdateChan]bool)
+	blockUpdate, err := b.processBlockUpdate(update)
+	if err != nil {
+		log.Panic(err)
+		return
+	}
+	b.signalSubscribers(blockUpdate)
+}
+
+// shutdown processes the crawler updates already queued, commits confirmed
+// blocks if CommitOnStop is set, and closes subscriber channels.
+func (b *BlockManager) shutdown(blockUpdateChan chan decodedUpdate) {
+
+	// Drain updates until crawler channel is closed, or no update is 
+	// received for a while (crawler still running)
+	for drained := blockUpdateChan == nil; !drained; {
+		select {
+		case update, ok := <- blockUpdateChan:
+			if !ok {
+				drained = true
+				break
+			}
+			b.processUpdate(update)
+		case <- time.After(StopDrainTimeout):
+			drained = true
+		}
+	}
+
+	if b.commitTimerStartedFlag {
+		b.stopCommitTimer()
+		b.commitTimerStartedFlag = false
+	}
+
+	// Discard blocks still being decoded
+	b.decoder.Stop()
+
+	if b.CommitOnStop && b.uncommittedBlocks() > 0 {
+		if err := b.commit(); err != nil {
+			log.Print("Commit: ", err)
+		}
+	}
+
+	if b.BalanceSnapshotPath != "" {
+		b.saveStoredBalances()
+	}
+
+	// Release requests still waiting for the manager to be synced
+	for _, ch := range b.syncWaiters {
+		ch <- false
+	}
+	b.syncWaiters = nil
+
+	// Signal subscribers (and Logger) there won't be more updates
+	b.bus.Close()
+}
+
+// Block Manager routine handling block update and other requests
+func (b *BlockManager) managerRoutine(ctx context.Context, blockUpdateChan chan decodedUpdate) {
 
 	// Start logging routine for new blocks and backtracks
 	go Logger(b)
 
+	var snapshotTick <-chan time.Time
+	if b.BalanceSnapshotPath != "" && b.BalanceSnapshotPeriod > 0 {
+		ticker := time.NewTicker(b.BalanceSnapshotPeriod)
+		defer ticker.Stop()
+		snapshotTick = ticker.C
+	}
+
 	// Accept subscriptions and wait until the start signal is received
-	// Fetch blocks until the stop signal is received
+	// Fetch blocks until the context is cancelled
 	for {
 		select {		
-			// Subscription request
-			case subscriber := <-b.SubscribeChan:
-				subscribers[subscriber] = true
-
-			// Unsusbription request
-			case subscriber := <-b.UnsubscribeChan:
-				delete(subscribers, subscriber)
-
 			// Current height
 			case ch := <- b.HeightChan:
 				ch <- b.height
 
-			// Stop crawler and exit
-			case ch := <- b.StopChan:
-				ch <- true	// signal stopped
-				// TODO: Stop logger
-				break
+			// Stop manager and exit
+			case <- ctx.Done():
+				b.shutdown(blockUpdateChan)
+				close(b.done)
+				return
 
 			// New block available
-			case update := <- blockUpdateChan:
-				
-				blockUpdate, err := b.processBlockUpdate(update)
-				if err != nil {
-					log.Panic(err)
-					return
+			case update, ok := <- blockUpdateChan:
+				if !ok {
+					// Crawler stopped
+					blockUpdateChan = nil
+					continue
 				}
-				b.signalSubscribers(blockUpdate)
+				b.processUpdate(update)
 
 				if b.commitRequired() && !b.commitTimerStartedFlag {
 					// Start commit timer
@@ -450,76 +797,325 @@ func (b *BlockManager) managerRoutine(blockUpdateChan crawler.UpdateChan) {
 					b.signalSubscribers(NewBlockUpdate(OP_COMMIT, nil))
 				}
 
+			// Save stored balances
+			case <- snapshotTick:
+				b.saveStoredBalances()
+
 			// Commit timer expired
 			case <- b.commitTimer.C:
 				b.commitTimerStartedFlag = false
 				if b.commitRequired() {
-					b.commit()
-					b.signalSubscribers(NewBlockUpdate(OP_COMMIT_DONE, nil))
+					observer, _ := b.CommitPolicy.(CommitObserver)
+					if observer != nil {
+						observer.CommitStarted()
+					}
+					if err := b.commit(); err != nil {
+						log.Print("Commit: ", err)
+					}
+					if observer != nil {
+						observer.CommitDone()
+					}
 				}
 
+				// Always signal, subscribers were told a commit was scheduled
+				b.signalSubscribers(NewBlockUpdate(OP_COMMIT_DONE, nil))
+
 			// Request balance for one address.
 			case req := <- b.BalanceChan:
-				balance, err := b.getBalance(req.Address)
-				req.Resp <- BalanceResponse{Balance: balance, Err: err}
+				if err := req.Ctx.Err(); err != nil {
+					req.Resp <- BalanceResponse{Balance: 0, Err: err}
+					continue
+				}
+				balance, err := b.getBalance(req.Address, req.MinConf)
+				req.Resp <- BalanceResponse{Balance: balance, State: b.state(), Err: err}
 
 			case ch := <- b.SyncChan:
 				ch <- b.synced()
+
+			case ch := <- b.SyncWaitChan:
+				b.syncWaiters = append(b.syncWaiters, ch)
+				b.notifySyncWaiters()
+
+			case ch := <- b.ProgressChan:
+				ch <- b.syncProgress()
+
+			// Request balance for several addresses.
+			case req := <- b.BalancesChan:
+				if err := req.Ctx.Err(); err != nil {
+					req.Resp <- BalancesResponse{Err: err}
+					continue
+				}
+				balances, err := b.getBalances(req.Addresses)
+				req.Resp <- BalancesResponse{Balances: balances, State: b.state(), Err: err}
+
+			// Request unspent outputs for an address.
+			case req := <- b.UtxoChan:
+				if err := req.Ctx.Err(); err != nil {
+					req.Resp <- UtxoResponse{Err: err}
+					continue
+				}
+				utxos, err := b.getUtxos(req.Address)
+				req.Resp <- UtxoResponse{Utxos: utxos, State: b.state(), Err: err}
+
+			// Query a registered indexer.
+			case req := <- b.IndexerQueryChan:
+				if err := req.Ctx.Err(); err != nil {
+					req.Resp <- IndexerQueryResponse{Err: err}
+					continue
+				}
+				req.Resp <- b.queryIndexer(req.Ctx, req.Name, req.Query)
+
+			// Request the status of a transaction output
+			case req := <- b.OutpointChan:
+				if err := req.Ctx.Err(); err != nil {
+					req.Resp <- OutpointResponse{Err: err}
+					continue
+				}
+				outpoint, err := b.getOutpoint(req.Id)
+				req.Resp <- OutpointResponse{Outpoint: outpoint, Err: err}
+
+			// Request address and value for a list of TxOuts
+			case req := <- b.TxOutChan:
+				if err := req.Ctx.Err(); err != nil {
+					req.Resp <- err
+					continue
+				}
+				req.Resp <- b.resolveTxOuts(req.Outs)
 		}
 	}
 }
 
 
-// Subscribe to crawler helper that returns channel where updates are sent
-func (b *BlockManager) Subscribe(chanSize uint) UpdateChan {
+// Subscribe to manager helper that returns channel where updates are sent,
+// using the manager subscriber buffer size. Internal subscribers keep state
+// derived from every update so they always use events.BlockPolicy.
+func (b *BlockManager) Subscribe(name string, chanSize uint) UpdateChan {
+	return b.SubscribePolicy(name, chanSize, b.SubscriberBufferSize, events.BlockPolicy)
+}
+
+// SubscribeExternal subscribes a consumer that tolerates missing updates (i.e.
+// the notify hub), using the configured SubscriberPolicy.
+func (b *BlockManager) SubscribeExternal(name string, chanSize uint) UpdateChan {
+	return b.SubscribePolicy(name, chanSize, b.SubscriberBufferSize, b.SubscriberPolicy)
+}
+
+// SubscribePolicy subscribes with a custom buffer size and slow consumer 
+// policy, the channel is closed when the manager stops or the subscriber 
+// is disconnected.
+func (b *BlockManager) SubscribePolicy(name string, chanSize uint, bufferSize int, policy events.Policy) UpdateChan {
 	ch := make(UpdateChan, int(chanSize))
-	b.SubscribeChan <- ch
+
+	deliver := func(event interface{}, quit chan bool) bool {
+		select {
+		case ch <- event.(BlockUpdate):
+			return true
+		case <- quit:
+			return false
+		}
+	}
+	if err := b.bus.Subscribe(ch, name, bufferSize, policy, deliver, func() { close(ch) }); err != nil {
+		log.Panic(err)
+	}
 	return ch
 }
 
-// Unsubscribe from crawler
+// Unsubscribe from manager, pending updates are discarded.
 func (b *BlockManager) Unsubscribe(ch UpdateChan) {
-	b.UnsubscribeChan <- ch
+	b.bus.Unsubscribe(ch)
+}
+
+// SubscriberStats returns delivery metrics for each subscriber
+func (b *BlockManager) SubscriberStats() []events.Stats {
+	return b.bus.Stats()
+}
+
+// GetBalance returns the address balance only including blocks with at least
+// minconf confirmations, from 0 (all the pending blocks) up to the blocks
+// required to commit, and the chain state it was computed at.
+func (b *BlockManager) GetBalance(ctx context.Context, address string, minconf int) (int64, ChainState, error) {
+	if minconf < 0 || minconf > int(b.Confirmations)+1 {
+		return 0, ChainState{}, ErrInvalidMinConf
+	}
+
+	// Buffered so the manager never blocks if the request is cancelled
+	responseCh := make(chan BalanceResponse, 1)
+	select {
+	case b.BalanceChan <- BalanceRequest{Ctx: ctx, Address: address, MinConf: minconf, Resp: responseCh}:
+	case <- ctx.Done():
+		return 0, ChainState{}, ctx.Err()
+	case <- b.done:
+		return 0, ChainState{}, ErrStopped
+	}
+
+	select {
+	case response := <- responseCh:
+		return response.Balance, response.State, response.Err
+	case <- ctx.Done():
+		return 0, ChainState{}, ctx.Err()
+	case <- b.done:
+		return 0, ChainState{}, ErrStopped
+	}
+}
+
+// GetBalances returns the balance of several addresses, including the blocks
+// pending of confirmation, and the chain state they were computed at.
+func (b *BlockManager) GetBalances(ctx context.Context, addresses []string) (map[string]int64, ChainState, error) {
+	responseCh := make(chan BalancesResponse, 1)
+	select {
+	case b.BalancesChan <- BalancesRequest{Ctx: ctx, Addresses: addresses, Resp: responseCh}:
+	case <- ctx.Done():
+		return nil, ChainState{}, ctx.Err()
+	case <- b.done:
+		return nil, ChainState{}, ErrStopped
+	}
+
+	select {
+	case response := <- responseCh:
+		return response.Balances, response.State, response.Err
+	case <- ctx.Done():
+		return nil, ChainState{}, ctx.Err()
+	case <- b.done:
+		return nil, ChainState{}, ErrStopped
+	}
+}
+
+// GetUtxos returns address unspent outputs, including the blocks pending of
+// confirmation, sorted by height and the chain state they were retrieved at.
+func (b *BlockManager) GetUtxos(ctx context.Context, address string) ([]Utxo, ChainState, error) {
+	responseCh := make(chan UtxoResponse, 1)
+	select {
+	case b.UtxoChan <- UtxoRequest{Ctx: ctx, Address: address, Resp: responseCh}:
+	case <- ctx.Done():
+		return nil, ChainState{}, ctx.Err()
+	case <- b.done:
+		return nil, ChainState{}, ErrStopped
+	}
+
+	select {
+	case response := <- responseCh:
+		return response.Utxos, response.State, response.Err
+	case <- ctx.Done():
+		return nil, ChainState{}, ctx.Err()
+	case <- b.done:
+		return nil, ChainState{}, ErrStopped
+	}
 }
 
-// GetBalance sends a request for the balance of an address and returns the channel
-// where the response will be sent
-func (b *BlockManager) GetBalance(address string) int64 {
+// ResolveTxOuts populates TxOuts address and value from pending blocks or 
+// storage, unknown or spent TxOuts are left with empty address and 0 value.
+func (b *BlockManager) ResolveTxOuts(ctx context.Context, outs []*primitives.TxOut) error {
+	responseCh := make(chan error, 1)
+	select {
+	case b.TxOutChan <- TxOutRequest{Ctx: ctx, Outs: outs, Resp: responseCh}:
+	case <- ctx.Done():
+		return ctx.Err()
+	case <- b.done:
+		return ErrStopped
+	}
 
-	// Channel that will be used by crawler to send the response
-	responseCh := make(chan BalanceResponse)
-	b.BalanceChan <- BalanceRequest{ Address: address, Resp: responseCh}
-	balance := <- responseCh
-	close(responseCh)
-	return balance.Balance
+	select {
+	case err := <- responseCh:
+		return err
+	case <- ctx.Done():
+		return ctx.Err()
+	case <- b.done:
+		return ErrStopped
+	}
 }
 
 // GetHeight returs current height
-func (b *BlockManager) GetHeight() (height int64) {
-	responseCh := make(chan int64)
-	b.HeightChan <- responseCh
-	height = <- responseCh
-	close(responseCh)
-	return
+func (b *BlockManager) GetHeight(ctx context.Context) (int64, error) {
+	responseCh := make(chan int64, 1)
+	select {
+	case b.HeightChan <- responseCh:
+	case <- ctx.Done():
+		return -1, ctx.Err()
+	case <- b.done:
+		return -1, ErrStopped
+	}
+
+	select {
+	case height := <- responseCh:
+		return height, nil
+	case <- ctx.Done():
+		return -1, ctx.Err()
+	case <- b.done:
+		return -1, ErrStopped
+	}
 }
 
-// Wait until the manager is synced
-func (b *BlockManager) Synced() (sync bool) {
-	responseCh := make(chan bool)
-	b.SyncChan <- responseCh
-	sync = <-responseCh
-	close(responseCh)
-	return
+// Synced returns true if the manager is synced with bitcoind chain tip
+func (b *BlockManager) Synced(ctx context.Context) (bool, error) {
+	responseCh := make(chan bool, 1)
+	select {
+	case b.SyncChan <- responseCh:
+	case <- ctx.Done():
+		return false, ctx.Err()
+	case <- b.done:
+		return false, ErrStopped
+	}
+
+	select {
+	case sync := <- responseCh:
+		return sync, nil
+	case <- ctx.Done():
+		return false, ctx.Err()
+	case <- b.done:
+		return false, ErrStopped
+	}
+}
+
+// WaitSynced blocks until the manager is synced, returns an error if ctx is
+// cancelled or the manager stopped before.
+func (b *BlockManager) WaitSynced(ctx context.Context) error {
+	responseCh := make(chan bool, 1)
+	select {
+	case b.SyncWaitChan <- responseCh:
+	case <- ctx.Done():
+		return ctx.Err()
+	case <- b.done:
+		return ErrStopped
+	}
+
+	select {
+	case sync := <- responseCh:
+		if !sync {
+			return ErrStopped
+		}
+		return nil
+	case <- ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SyncProgress returns current and target heights, and processing rate
+func (b *BlockManager) SyncProgress(ctx context.Context) (SyncProgress, error) {
+	responseCh := make(chan SyncProgress, 1)
+	select {
+	case b.ProgressChan <- responseCh:
+	case <- ctx.Done():
+		return SyncProgress{}, ctx.Err()
+	case <- b.done:
+		return SyncProgress{}, ErrStopped
+	}
+
+	select {
+	case progress := <- responseCh:
+		return progress, nil
+	case <- ctx.Done():
+		return SyncProgress{}, ctx.Err()
+	case <- b.done:
+		return SyncProgress{}, ErrStopped
+	}
 }
 
-// Stop crawler blocks until successfull exit
+// Stop processes queued updates, commits if CommitOnStop is set, and closes
+// subscriber channels. Blocks until successfull exit
 func (b *BlockManager) Stop() {
-	doneCh := make(chan bool)
-	b.StopChan <- doneCh
+	b.cancel()
 
 	// Wait until it has stopped
-	<- doneCh
-	close(doneCh)
+	<- b.done
 }
 
 
@@ -0,0 +1,203 @@
+package block_manager
+
+import (
+	"runtime"
+	"time"
+)
+
+const (
+	// Delay between a commit is scheduled and started when in sync mode
+	SyncCommitDelay = 10*time.Millisecond
+)
+
+// CommitState is the information available to commit policies
+type CommitState struct {
+
+	// Confirmed blocks in the storage cache not yet committed
+	UncommittedBlocks int
+
+	// Uncommitted utxo inserts and deletions in the storage cache
+	UncommittedEntries int
+
+	// Time elapsed since the last commit (or manager start)
+	SinceLastCommit time.Duration
+
+	// Manager is synced with bitcoind chain tip
+	Synced bool
+
+	// Manager is in sync mode
+	Sync bool
+}
+
+// CommitPolicy decides when the confirmed blocks in the storage cache are
+// committed to storage, CommitRequired is checked after each block and once
+// more when the commit delay has expired.
+type CommitPolicy interface {
+
+	// CommitRequired returns true when it's time for a commit
+	CommitRequired(state CommitState) bool
+
+	// CommitDelay returns the time between a commit is scheduled and started
+	CommitDelay(state CommitState) time.Duration
+}
+
+// CommitObserver can be implemented by commit policies to be notified when a
+// commit starts and ends.
+type CommitObserver interface {
+	CommitStarted()
+	CommitDone()
+}
+
+// syncCommitRequired commits the last blocks as soon as the top of the
+// chain is reached in sync mode.
+func syncCommitRequired(state CommitState) bool {
+	return state.Sync && state.Synced
+}
+
+
+// DefaultCommitPolicy commits when the cache size is exceeded, or once
+// MinBlocks have been confirmed.
+type DefaultCommitPolicy struct {
+
+	// Max number of uncommitted entries before a commit is required
+	MaxEntries int
+
+	// Min number of blocks before a commit is recommended
+	MinBlocks int
+
+	// Delay between when a commit is required and it starts, the purpose together
+	// with MinBlocks is to assure not many nodes start a commit at the same time
+	Delay time.Duration
+}
+
+func (p *DefaultCommitPolicy) CommitRequired(state CommitState) bool {
+	if state.UncommittedBlocks < 1 {
+		return false
+	}
+
+	// If the max cache size has been reached is time to commit
+	if state.UncommittedEntries > p.MaxEntries {
+		return true
+	}
+
+	if state.Sync {
+		return syncCommitRequired(state)
+	}
+
+	return state.UncommittedBlocks > p.MinBlocks
+}
+
+func (p *DefaultCommitPolicy) CommitDelay(state CommitState) time.Duration {
+	if state.Sync {
+		return SyncCommitDelay
+	}
+	return p.Delay
+}
+
+
+// TimeCommitPolicy commits periodically, or when the cache size is exceeded
+type TimeCommitPolicy struct {
+
+	// Min time between commits
+	Period time.Duration
+
+	// Max number of uncommitted entries before a commit is required
+	MaxEntries int
+
+	// Delay between when a commit is required and it starts
+	Delay time.Duration
+}
+
+func (p *TimeCommitPolicy) CommitRequired(state CommitState) bool {
+	if state.UncommittedBlocks < 1 {
+		return false
+	}
+
+	if state.UncommittedEntries > p.MaxEntries || syncCommitRequired(state) {
+		return true
+	}
+
+	return state.SinceLastCommit >= p.Period
+}
+
+func (p *TimeCommitPolicy) CommitDelay(state CommitState) time.Duration {
+	if state.Sync {
+		return SyncCommitDelay
+	}
+	return p.Delay
+}
+
+
+// MemoryCommitPolicy commits when the process heap exceeds MaxHeap bytes, so
+// the cache can grow as much as the available memory allows.
+type MemoryCommitPolicy struct {
+
+	// Max heap size in bytes
+	MaxHeap uint64
+
+	// Delay between when a commit is required and it starts
+	Delay time.Duration
+}
+
+func (p *MemoryCommitPolicy) CommitRequired(state CommitState) bool {
+	if state.UncommittedBlocks < 1 {
+		return false
+	}
+
+	if syncCommitRequired(state) {
+		return true
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc > p.MaxHeap
+}
+
+func (p *MemoryCommitPolicy) CommitDelay(state CommitState) time.Duration {
+	if state.Sync {
+		return SyncCommitDelay
+	}
+	return p.Delay
+}
+
+
+// PeerStatus is the part of the peer manager used by PeerCommitPolicy
+type PeerStatus interface {
+	SetCommitting(flag bool)
+	CommittingPeers() int
+}
+
+// PeerCommitPolicy delays the commits required by Policy while another peer
+// is committing, so there is always a peer available to proxy requests.
+type PeerCommitPolicy struct {
+	Policy CommitPolicy
+	Peers  PeerStatus
+
+	// Commit even if other peers are committing when the uncommitted
+	// entries exceed ForceEntries (0 to never force)
+	ForceEntries int
+}
+
+func (p *PeerCommitPolicy) CommitRequired(state CommitState) bool {
+	if !p.Policy.CommitRequired(state) {
+		return false
+	}
+
+	if state.Sync || p.Peers.CommittingPeers() == 0 {
+		return true
+	}
+
+	return p.ForceEntries > 0 && state.UncommittedEntries > p.ForceEntries
+}
+
+func (p *PeerCommitPolicy) CommitDelay(state CommitState) time.Duration {
+	return p.Policy.CommitDelay(state)
+}
+
+func (p *PeerCommitPolicy) CommitStarted() {
+	p.Peers.SetCommitting(true)
+}
+
+func (p *PeerCommitPolicy) CommitDone() {
+	p.Peers.SetCommitting(false)
+}
@@ -0,0 +1,102 @@
+package block_manager
+
+import (
+	"time"
+	"testing"
+)
+
+type mockPeerStatus struct {
+	committing      bool
+	committingPeers int
+}
+
+func (m *mockPeerStatus) SetCommitting(flag bool) {
+	m.committing = flag
+}
+
+func (m *mockPeerStatus) CommittingPeers() int {
+	return m.committingPeers
+}
+
+// Test default policy keeps the original commit behaviour
+func TestDefaultCommitPolicy(t *testing.T) {
+	policy := &DefaultCommitPolicy{MaxEntries: 100, MinBlocks: 3, Delay: time.Minute}
+
+	if policy.CommitRequired(CommitState{UncommittedBlocks: 0, UncommittedEntries: 1000}) {
+		t.Errorf("CommitRequired(): Nothing to commit")
+	}
+	if policy.CommitRequired(CommitState{UncommittedBlocks: 3, UncommittedEntries: 10}) {
+		t.Errorf("CommitRequired(): MinBlocks not exceeded")
+	}
+	if !policy.CommitRequired(CommitState{UncommittedBlocks: 4, UncommittedEntries: 10}) {
+		t.Errorf("CommitRequired(): MinBlocks exceeded")
+	}
+	if !policy.CommitRequired(CommitState{UncommittedBlocks: 1, UncommittedEntries: 101}) {
+		t.Errorf("CommitRequired(): MaxEntries exceeded")
+	}
+
+	// Sync mode only commits when the cache is full or synced
+	state := CommitState{UncommittedBlocks: 10, UncommittedEntries: 10, Sync: true}
+	if policy.CommitRequired(state) {
+		t.Errorf("CommitRequired(): Sync mode not synced")
+	}
+	state.Synced = true
+	if !policy.CommitRequired(state) {
+		t.Errorf("CommitRequired(): Sync mode synced")
+	}
+
+	if policy.CommitDelay(state) != SyncCommitDelay {
+		t.Errorf("CommitDelay(): Unexpected sync mode delay")
+	}
+	state.Sync = false
+	if policy.CommitDelay(state) != time.Minute {
+		t.Errorf("CommitDelay(): Unexpected delay")
+	}
+}
+
+// Test time policy commits once the period has elapsed
+func TestTimeCommitPolicy(t *testing.T) {
+	policy := &TimeCommitPolicy{Period: time.Hour, MaxEntries: 100}
+
+	state := CommitState{UncommittedBlocks: 50, UncommittedEntries: 10, SinceLastCommit: time.Minute}
+	if policy.CommitRequired(state) {
+		t.Errorf("CommitRequired(): Period not elapsed")
+	}
+	state.SinceLastCommit = time.Hour
+	if !policy.CommitRequired(state) {
+		t.Errorf("CommitRequired(): Period elapsed")
+	}
+}
+
+// Test peer policy waits while other peers are committing
+func TestPeerCommitPolicy(t *testing.T) {
+	peers := &mockPeerStatus{}
+	policy := &PeerCommitPolicy {
+		Policy:       &DefaultCommitPolicy{MaxEntries: 100, MinBlocks: 1},
+		Peers:        peers,
+		ForceEntries: 200,
+	}
+
+	state := CommitState{UncommittedBlocks: 5, UncommittedEntries: 10}
+	if !policy.CommitRequired(state) {
+		t.Errorf("CommitRequired(): No peer committing")
+	}
+
+	peers.committingPeers = 1
+	if policy.CommitRequired(state) {
+		t.Errorf("CommitRequired(): Another peer is committing")
+	}
+	state.UncommittedEntries = 201
+	if !policy.CommitRequired(state) {
+		t.Errorf("CommitRequired(): ForceEntries exceeded")
+	}
+
+	policy.CommitStarted()
+	if !peers.committing {
+		t.Errorf("CommitStarted(): Committing flag not set")
+	}
+	policy.CommitDone()
+	if peers.committing {
+		t.Errorf("CommitDone(): Committing flag not cleared")
+	}
+}
@@ -0,0 +1,152 @@
+package block_manager
+
+import (
+	"runtime"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/secnot/gobalance/crawler"
+	"github.com/secnot/gobalance/primitives"
+)
+
+const (
+	// Max number of blocks being decoded or waiting for the manager
+	DecoderQueueSize = 20
+)
+
+// decodedUpdate is a crawler update with the block transactions already
+// decoded (hashes and output addresses), inputs aren't resolved.
+type decodedUpdate struct {
+	crawler.BlockUpdate
+
+	// Decoded block transactions (nil when update has no block)
+	Transactions []*primitives.Tx
+}
+
+// decodeJob is a block sent to a decoder worker, the result is sent through
+// the job channel once done.
+type decodeJob struct {
+	update crawler.BlockUpdate
+	result chan decodedUpdate
+}
+
+// Decoder decodes crawler blocks in parallel, and delivers them in the same
+// order they were received.
+type Decoder struct {
+	// Number of decoding routines
+	Workers int
+
+	// Decoded updates in order, closed once the crawler channel is closed
+	// and all the pending blocks delivered.
+	Updates chan decodedUpdate
+
+	jobs    chan decodeJob
+	ordered chan chan decodedUpdate
+	quit    chan bool
+}
+
+// NewDecoder starts a decoder for the updates received from crawler, if
+// workers < 1 one worker per cpu is used.
+func NewDecoder(updates crawler.UpdateChan, workers int) *Decoder {
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	d := &Decoder {
+		Workers: workers,
+		Updates: make(chan decodedUpdate),
+		jobs:    make(chan decodeJob, DecoderQueueSize),
+		ordered: make(chan chan decodedUpdate, DecoderQueueSize),
+		quit:    make(chan bool),
+	}
+
+	for n := 0; n < workers; n++ {
+		go d.worker()
+	}
+	go d.dispatcher(updates)
+	go d.collector()
+	return d
+}
+
+// decodeBlock builds all the block transactions (without inputs)
+func decodeBlock(block *wire.MsgBlock) []*primitives.Tx {
+	transactions := make([]*primitives.Tx, 0, len(block.Transactions))
+	for _, wireTx := range block.Transactions {
+		transactions = append(transactions, primitives.NewTxFromMsgTx(wireTx))
+	}
+	return transactions
+}
+
+// worker decodes blocks until the jobs channel is closed
+func (d *Decoder) worker() {
+	for job := range d.jobs {
+		decoded := decodedUpdate{BlockUpdate: job.update}
+		if job.update.Block != nil {
+			decoded.Transactions = decodeBlock(job.update.Block)
+		}
+		job.result <- decoded
+	}
+}
+
+// dispatcher sends crawler updates to workers, and queues their result
+// channels in the same order for the collector.
+func (d *Decoder) dispatcher(updates crawler.UpdateChan) {
+	defer close(d.jobs)
+	defer close(d.ordered)
+
+	for {
+		var update crawler.BlockUpdate
+		var ok bool
+		select {
+		case update, ok = <- updates:
+			if !ok {
+				return
+			}
+		case <- d.quit:
+			return
+		}
+
+		job := decodeJob{update: update, result: make(chan decodedUpdate, 1)}
+		select {
+		case d.ordered <- job.result:
+		case <- d.quit:
+			return
+		}
+
+		// Updates without a block are passed through without using a worker
+		if update.Block == nil {
+			job.result <- decodedUpdate{BlockUpdate: update}
+			continue
+		}
+
+		select {
+		case d.jobs <- job:
+		case <- d.quit:
+			return
+		}
+	}
+}
+
+// collector waits for each result in order and delivers it to the manager
+func (d *Decoder) collector() {
+	defer close(d.Updates)
+
+	for result := range d.ordered {
+		var decoded decodedUpdate
+		select {
+		case decoded = <- result:
+		case <- d.quit:
+			return
+		}
+
+		select {
+		case d.Updates <- decoded:
+		case <- d.quit:
+			return
+		}
+	}
+}
+
+// Stop decoder routines, blocks not yet delivered are discarded
+func (d *Decoder) Stop() {
+	close(d.quit)
+}
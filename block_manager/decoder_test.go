@@ -0,0 +1,70 @@
+package block_manager
+
+import (
+	"time"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/secnot/gobalance/crawler"
+)
+
+// mockWireBlock returns a block with txs transactions, the first output value
+// identifies the block.
+func mockWireBlock(id int64, txs int) *wire.MsgBlock {
+	block := wire.NewMsgBlock(&wire.BlockHeader{Nonce: uint32(id)})
+	for n := 0; n < txs; n++ {
+		tx := wire.NewMsgTx(1)
+		tx.AddTxOut(wire.NewTxOut(id, []byte{}))
+		block.AddTransaction(tx)
+	}
+	return block
+}
+
+// Test blocks are delivered in order, and the channel closed with the input
+func TestDecoderOrder(t *testing.T) {
+	updates := make(crawler.UpdateChan)
+	decoder := NewDecoder(updates, 4)
+	defer decoder.Stop()
+
+	go func() {
+		for n := int64(0); n < 100; n++ {
+			// Larger blocks first so workers finish out of order
+			block := mockWireBlock(n, int(100-n))
+			hash  := block.BlockHash()
+			updates <- crawler.BlockUpdate{Class: crawler.OP_NEWBLOCK, Block: block, Hash: &hash}
+			if n % 10 == 0 {
+				updates <- crawler.BlockUpdate{Class: crawler.OP_TIP, TipHeight: uint64(n)}
+			}
+		}
+		close(updates)
+	}()
+
+	expected := int64(0)
+	for {
+		select {
+		case update, ok := <- decoder.Updates:
+			if !ok {
+				if expected != 100 {
+					t.Errorf("Updates closed after %v blocks", expected)
+				}
+				return
+			}
+			if update.Class == crawler.OP_TIP {
+				if update.Transactions != nil || int64(update.TipHeight) != expected-1 {
+					t.Errorf("Unexpected tip update %v", update)
+				}
+				continue
+			}
+
+			if len(update.Transactions) != int(100-expected) {
+				t.Errorf("Expecting %v transactions received %v", 100-expected, len(update.Transactions))
+			}
+			if value := update.Transactions[0].Out[0].Value; value != expected {
+				t.Errorf("Expecting block %v received %v", expected, value)
+			}
+			expected++
+		case <- time.After(time.Second):
+			t.Fatal("Timeout waiting for update")
+		}
+	}
+}
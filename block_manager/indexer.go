@@ -0,0 +1,309 @@
+package block_manager
+
+import (
+	"fmt"
+	"errors"
+	"context"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/primitives"
+	"github.com/secnot/gobalance/block_manager/storage"
+)
+
+const (
+	// Indexer query channel size
+	IndexerQueryQueueSize = 10
+
+	// Name of the address history indexer queried by GetBalanceAt
+	HistoryIndexerName = "history"
+
+	// Name of the address transactions indexer queried by GetAddressTxs
+	AddressTxIndexerName = "address_tx"
+)
+
+var (
+	ErrUnknownIndexer     = errors.New("Unknown indexer")
+	ErrHistoryUnavailable = errors.New("Address history unavailable")
+	ErrAddressTxUnavailable = errors.New("Address transactions unavailable")
+	ErrInvalidCursor      = errors.New("Invalid cursor")
+)
+
+// Indexer maintains data derived from the blocks processed by the manager,
+// all the hooks are called from the manager routine so the indexer sees the
+// same chain state as the balance and utxo queries.
+type Indexer interface {
+
+	// Unique name used to route queries
+	Name() string
+
+	// Init is called once when the manager starts with the storage and the
+	// height of the last committed block, the indexer may create its tables.
+	Init(sto storage.Storage, height int64) error
+
+	// ConnectBlock is called for every new block, the inputs have already
+	// been resolved unless the manager is in sync mode.
+	ConnectBlock(block *primitives.Block) error
+
+	// DisconnectBlock is called when the last connected block is backtracked
+	DisconnectBlock(block *primitives.Block) error
+
+	// Commit stores the data for blocks up to height within the storage
+	// transaction committing the utxo set for the same last block.
+	Commit(tx storage.Tx, height int64) error
+
+	// Query returns indexer specific data
+	Query(ctx context.Context, query interface{}) (interface{}, error)
+}
+
+// StoredQuery reads storage to complete an indexer query, it runs outside the
+// manager routine.
+type StoredQuery func(ctx context.Context) (interface{}, error)
+
+// StoredQueryIndexer can be implemented by indexers whose queries read
+// storage, so the manager routine isn't blocked by them. PrepareQuery is
+// called from the manager routine instead of Query, it copies the pending
+// data needed and returns the StoredQuery reading the committed data.
+type StoredQueryIndexer interface {
+	PrepareQuery(query interface{}) (StoredQuery, error)
+}
+
+// IndexerCommitObserver can be implemented by indexers to be notified once
+// the storage transaction calling Commit succeeded.
+type IndexerCommitObserver interface {
+	Committed(height int64)
+}
+
+// BalanceAtQuery is the history indexer query for the balance of an address
+// at the given height, the result is an int64.
+type BalanceAtQuery struct {
+	Address string
+	Height  int64
+}
+
+// AddressTxQuery is the address transactions indexer query, it returns an
+// AddressTxPage with the transactions between MinHeight and MaxHeight (no
+// upper limit when negative) newest first, starting after Cursor.
+type AddressTxQuery struct {
+	Address   string
+	MinHeight int64
+	MaxHeight int64
+
+	// Cursor returned with the previous page, empty for the first one
+	Cursor string
+
+	// Max number of transactions returned
+	Limit int
+}
+
+// EncodeCursor returns a pagination cursor pointing to the transaction at
+// position within the block at height.
+func EncodeCursor(height int64, position int) string {
+	return fmt.Sprintf("%v:%v", height, position)
+}
+
+// DecodeCursor returns the height and position in a cursor returned by
+// EncodeCursor.
+func DecodeCursor(cursor string) (height int64, position int, err error) {
+	parts := strings.Split(cursor, ":")
+	if len(parts) != 2 {
+		return 0, 0, ErrInvalidCursor
+	}
+	if height, err = strconv.ParseInt(parts[0], 10, 64); err != nil || height < 0 {
+		return 0, 0, ErrInvalidCursor
+	}
+	if position, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, ErrInvalidCursor
+	}
+	return height, position, nil
+}
+
+// AddressTx is a transaction involving an address
+type AddressTx struct {
+	TxHash   chainhash.Hash
+	Height   int64
+
+	// Transaction position within the block
+	Position int
+
+	// Address balance delta generated by the transaction
+	Delta    int64
+}
+
+// AddressTxPage is a page of address transactions, Cursor is empty when there
+// are no more transactions.
+type AddressTxPage struct {
+	Txs    []AddressTx
+	Cursor string
+}
+
+// IndexerQueryRequest is used to query an indexer through IndexerQueryChan
+type IndexerQueryRequest struct {
+
+	// Request context, the request is discarded if cancelled while queued
+	Ctx context.Context
+
+	// Indexer name and its query
+	Name  string
+	Query interface{}
+
+	// Channel used to send the response
+	Resp chan IndexerQueryResponse
+}
+
+// Indexer query response
+type IndexerQueryResponse struct {
+	Result interface{}
+	State  ChainState
+	Err    error
+
+	// Storage read completing the query for a StoredQueryIndexer, it is only
+	// consistent with the result while the commit sequence is still Seq.
+	Read StoredQuery
+	Seq  uint64
+}
+
+// initIndexers calls Init for all the registered indexers
+func (b *BlockManager) initIndexers(sto storage.Storage, height int64) error {
+	b.indexers = make(map[string]Indexer, len(b.Indexers))
+	for _, indexer := range b.Indexers {
+		if err := indexer.Init(sto, height); err != nil {
+			return err
+		}
+		b.indexers[indexer.Name()] = indexer
+	}
+	return nil
+}
+
+// connectIndexers adds a new block to all indexers
+func (b *BlockManager) connectIndexers(block *primitives.Block) error {
+	for _, indexer := range b.Indexers {
+		if err := indexer.ConnectBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// disconnectIndexers removes the last block from all indexers, in reverse
+// order to connectIndexers.
+func (b *BlockManager) disconnectIndexers(block *primitives.Block) error {
+	for n := len(b.Indexers)-1; n >= 0; n-- {
+		if err := b.Indexers[n].DisconnectBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexerHooks returns the storage commit hooks for all the indexers
+func (b *BlockManager) indexerHooks(height int64) []storage.CommitHook {
+	hooks := make([]storage.CommitHook, len(b.Indexers))
+	for n, indexer := range b.Indexers {
+		indexer := indexer
+		hooks[n] = func(tx storage.Tx) error {
+			return indexer.Commit(tx, height)
+		}
+	}
+	return hooks
+}
+
+// indexersCommitted notifies indexers a commit up to height succeeded
+func (b *BlockManager) indexersCommitted(height int64) {
+	for _, indexer := range b.Indexers {
+		if observer, ok := indexer.(IndexerCommitObserver); ok {
+			observer.Committed(height)
+		}
+	}
+}
+
+// queryIndexer runs a query from the manager routine, queries reading
+// storage are only prepared and returned to be completed by the requester.
+func (b *BlockManager) queryIndexer(ctx context.Context, name string, query interface{}) IndexerQueryResponse {
+	indexer, ok := b.indexers[name]
+	if !ok {
+		return IndexerQueryResponse{State: b.state(), Err: ErrUnknownIndexer}
+	}
+
+	if stored, ok := indexer.(StoredQueryIndexer); ok {
+		read, err := stored.PrepareQuery(query)
+		return IndexerQueryResponse {
+			State: b.state(),
+			Err:   err,
+			Read:  read,
+			Seq:   atomic.LoadUint64(&b.commitSeq),
+		}
+	}
+
+	result, err := indexer.Query(ctx, query)
+	return IndexerQueryResponse{Result: result, State: b.state(), Err: err}
+}
+
+// GetBalanceAt returns the address balance at a past height and the chain
+// state the query was run at, it requires the history indexer.
+func (b *BlockManager) GetBalanceAt(ctx context.Context, address string, height int64) (int64, ChainState, error) {
+	result, state, err := b.QueryIndexer(ctx, HistoryIndexerName, BalanceAtQuery{Address: address, Height: height})
+	if err == ErrUnknownIndexer {
+		return 0, ChainState{}, ErrHistoryUnavailable
+	}
+	if err != nil {
+		return 0, ChainState{}, err
+	}
+	return result.(int64), state, nil
+}
+
+// GetAddressTxs returns a page of the transactions involving an address, it
+// requires the address transactions indexer.
+func (b *BlockManager) GetAddressTxs(ctx context.Context, query AddressTxQuery) (AddressTxPage, error) {
+	result, _, err := b.QueryIndexer(ctx, AddressTxIndexerName, query)
+	if err == ErrUnknownIndexer {
+		return AddressTxPage{}, ErrAddressTxUnavailable
+	}
+	if err != nil {
+		return AddressTxPage{}, err
+	}
+	return result.(AddressTxPage), nil
+}
+
+// QueryIndexer sends a query to the named indexer and returns its result and
+// the chain state it was run at. Storage reads are done outside the
+// manager routine, the query is prepared again if a commit ran meanwhile.
+func (b *BlockManager) QueryIndexer(ctx context.Context, name string, query interface{}) (interface{}, ChainState, error) {
+	for {
+		response, err := b.requestIndexerQuery(ctx, name, query)
+		if err != nil {
+			return nil, ChainState{}, err
+		}
+		if response.Err != nil || response.Read == nil {
+			return response.Result, response.State, response.Err
+		}
+
+		result, err := response.Read(ctx)
+		if atomic.LoadUint64(&b.commitSeq) == response.Seq {
+			return result, response.State, err
+		}
+	}
+}
+
+// requestIndexerQuery sends a query to the manager routine
+func (b *BlockManager) requestIndexerQuery(ctx context.Context, name string, query interface{}) (IndexerQueryResponse, error) {
+	responseCh := make(chan IndexerQueryResponse, 1)
+	select {
+	case b.IndexerQueryChan <- IndexerQueryRequest{Ctx: ctx, Name: name, Query: query, Resp: responseCh}:
+	case <- ctx.Done():
+		return IndexerQueryResponse{}, ctx.Err()
+	case <- b.done:
+		return IndexerQueryResponse{}, ErrStopped
+	}
+
+	select {
+	case response := <- responseCh:
+		return response, nil
+	case <- ctx.Done():
+		return IndexerQueryResponse{}, ctx.Err()
+	case <- b.done:
+		return IndexerQueryResponse{}, ErrStopped
+	}
+}
@@ -0,0 +1,95 @@
+package block_manager
+
+import (
+	"context"
+	"testing"
+	"sync/atomic"
+
+	"github.com/secnot/gobalance/crawler"
+	"github.com/secnot/gobalance/primitives"
+	"github.com/secnot/gobalance/block_manager/storage"
+)
+
+// storedIndexer prepares queries whose storage read queries the manager,
+// which would deadlock if the read ran in the manager routine.
+type storedIndexer struct {
+	manager  *BlockManager
+	prepared int
+
+	// Simulate a commit during the first read
+	commitOnRead bool
+}
+
+func (i *storedIndexer) Name() string                                { return "stored" }
+func (i *storedIndexer) Init(sto storage.Storage, height int64) error { return nil }
+func (i *storedIndexer) ConnectBlock(block *primitives.Block) error   { return nil }
+func (i *storedIndexer) DisconnectBlock(block *primitives.Block) error { return nil }
+func (i *storedIndexer) Commit(tx storage.Tx, height int64) error     { return nil }
+
+func (i *storedIndexer) Query(ctx context.Context, query interface{}) (interface{}, error) {
+	return nil, ErrInvalidCursor
+}
+
+func (i *storedIndexer) PrepareQuery(query interface{}) (StoredQuery, error) {
+	i.prepared++
+	prepared := i.prepared
+	read := func(ctx context.Context) (interface{}, error) {
+		if i.commitOnRead {
+			i.commitOnRead = false
+			atomic.AddUint64(&i.manager.commitSeq, 2)
+		}
+		if _, err := i.manager.GetHeight(ctx); err != nil {
+			return nil, err
+		}
+		return prepared, nil
+	}
+	return read, nil
+}
+
+// Test stored queries are read outside the manager routine, and prepared
+// again when a commit ran before the read finished
+func TestStoredIndexerQuery(t *testing.T) {
+	indexer := &storedIndexer{commitOnRead: true}
+	manager := &BlockManager{Confirmations: 6, Indexers: []Indexer{indexer}}
+	indexer.manager = manager
+	updates := make(crawler.UpdateChan)
+	if err := manager.Start(context.Background(), newStorage(t, 1), updates); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Stop()
+	defer close(updates)
+
+	result, _, err := manager.QueryIndexer(context.Background(), "stored", nil)
+	if err != nil || result.(int) != 2 || indexer.prepared != 2 {
+		t.Errorf("QueryIndexer(): Unexpected %v %v after %v prepares", result, err, indexer.prepared)
+	}
+}
+
+// historyIndexer returns a fixed balance for any query
+type historyIndexer struct{}
+
+func (i *historyIndexer) Name() string                                { return HistoryIndexerName }
+func (i *historyIndexer) Init(sto storage.Storage, height int64) error { return nil }
+func (i *historyIndexer) ConnectBlock(block *primitives.Block) error   { return nil }
+func (i *historyIndexer) DisconnectBlock(block *primitives.Block) error { return nil }
+func (i *historyIndexer) Commit(tx storage.Tx, height int64) error     { return nil }
+
+func (i *historyIndexer) Query(ctx context.Context, query interface{}) (interface{}, error) {
+	return int64(42), nil
+}
+
+// Test historical balances are returned with the chain state
+func TestGetBalanceAt(t *testing.T) {
+	manager := &BlockManager{Confirmations: 6, Indexers: []Indexer{&historyIndexer{}}}
+	updates := make(crawler.UpdateChan)
+	if err := manager.Start(context.Background(), newStorage(t, 1), updates); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Stop()
+	defer close(updates)
+
+	balance, state, err := manager.GetBalanceAt(context.Background(), "address_0", 0)
+	if err != nil || balance != 42 || state != manager.state() {
+		t.Errorf("GetBalanceAt(): Unexpected %v %v %v", balance, state, err)
+	}
+}
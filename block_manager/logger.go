@@ -2,20 +2,39 @@ package block_manager
 
 import (
 	"log"
+
+	"github.com/secnot/gobalance/events"
+)
+
+const (
+	// Logger updates buffer, older updates are dropped when full
+	LoggerBufferSize = 100
 )
 
 
 func Logger(manager *BlockManager) {
-	blocks := manager.Subscribe(10) 
+	blocks := manager.SubscribePolicy("logger", 10, LoggerBufferSize, events.DropPolicy) 
 
 	for update := range blocks {
 		block := update.Block
-		if update.Class == OP_NEWBLOCK {
+		switch update.Class {
+		case OP_NEWBLOCK:
 			if block.Height % 1000 == 0 {
 				log.Printf("New: %v\n", block)
+				logLaggingSubscribers(manager)
 			}
-		} else {
+		case OP_BACKTRACK:
 			log.Printf("Backtrack: %v\n", block)
 		}
 	}
 }
+
+// logLaggingSubscribers logs subscribers with pending or dropped updates
+func logLaggingSubscribers(manager *BlockManager) {
+	for _, stats := range manager.SubscriberStats() {
+		if stats.Pending > 0 || stats.Dropped > 0 {
+			log.Printf("Subscriber %v: %v pending (max %v), %v dropped\n", 
+				stats.Name, stats.Pending, stats.MaxPending, stats.Dropped)
+		}
+	}
+}
@@ -1,7 +1,14 @@
 package block_manager
 
 import (
+	"time"
+	"context"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+
+	"github.com/secnot/gobalance/events"
 	"github.com/secnot/gobalance/primitives"
+	"github.com/secnot/gobalance/block_manager/storage"
 )
 
 // Subscriber updates types
@@ -10,6 +17,15 @@ type UpdateClass int
 const (
 	// Balance request channel size
 	BalanceRequestQueueSize = 20
+
+	// Batch balance request channel size
+	BalancesRequestQueueSize = 10
+
+	// Utxo request channel size
+	UtxoRequestQueueSize = 10
+
+	// TxOut request channel size
+	TxOutRequestQueueSize = 10
 )
 
 const (
@@ -44,12 +60,26 @@ func NewBlockUpdate(class UpdateClass, block *primitives.Block) BlockUpdate{
 // Channel type used to send subscriber updates
 type UpdateChan chan BlockUpdate
 
+// ChainState identifies the chain tip a response was computed at
+type ChainState struct {
+
+	// Last block height and hash
+	Height int64
+	Hash   chainhash.Hash
+}
+
 // BalanceRequest is used to send balance requests to the crawler
 // throug BalanceChan channel
 type BalanceRequest struct {
 
+	// Request context, the request is discarded if cancelled while queued
+	Ctx context.Context
+
 	// Bitcoin address
 	Address string
+
+	// Only include blocks with at least MinConf confirmations (0 for all)
+	MinConf int
 	
 	// Channel used to send the response
 	Resp chan BalanceResponse
@@ -61,26 +91,181 @@ type BalanceResponse struct {
 	// Bitcoin address balance or 0 if not found
 	Balance int64
 
+	// Chain tip when the balance was computed
+	State ChainState
+
+	// Error generated while processing request
+	Err error
+}
+
+// BalancesRequest is used to request the balance of several addresses at
+// once through BalancesChan
+type BalancesRequest struct {
+
+	// Request context, the request is discarded if cancelled while queued
+	Ctx context.Context
+
+	// Bitcoin addresses
+	Addresses []string
+
+	// Channel used to send the response
+	Resp chan BalancesResponse
+}
+
+// Batch balance request response
+type BalancesResponse struct {
+
+	// Balance for each of the requested addresses
+	Balances map[string]int64
+
+	// Chain tip when the balances were computed
+	State ChainState
+
+	// Error generated while processing request
+	Err error
+}
+
+// Utxo is an unspent output for an address
+type Utxo struct {
+
+	// Transaction hash and output number
+	TxHash chainhash.Hash
+	Nout   uint32
+
+	// Output amount
+	Value int64
+
+	// Height of the block containing the output (0 when unknown)
+	Height int64
+
+	// Number of blocks since the output was included (0 when unknown)
+	Confirmations int64
+}
+
+// UtxoRequest is used to request address unspent outputs through UtxoChan
+type UtxoRequest struct {
+
+	// Request context, the request is discarded if cancelled while queued
+	Ctx context.Context
+
+	// Bitcoin address
+	Address string
+
+	// Channel used to send the response
+	Resp chan UtxoResponse
+}
+
+// Utxo request response
+type UtxoResponse struct {
+
+	// Address unspent outputs sorted by height
+	Utxos []Utxo
+
+	// Chain tip when the outputs were retrieved
+	State ChainState
+
 	// Error generated while processing request
 	Err error
 }
 
+// TxOutRequest is used to request the address and value of TxOuts through
+// TxOutChan, the TxOuts are populated in place.
+type TxOutRequest struct {
+
+	// Request context, the request is discarded if cancelled while queued
+	Ctx context.Context
+
+	// TxOuts with hash and output number
+	Outs []*primitives.TxOut
+
+	// Channel used to send the error (or nil when done)
+	Resp chan error
+}
+
+// SyncProgress describes how far the manager is from bitcoind chain tip
+type SyncProgress struct {
+
+	// Last block processed
+	Height int64
+
+	// Last block processed hash
+	Hash chainhash.Hash
+
+	// Last block committed to storage
+	CommittedHeight int64
+
+	// Bitcoind chain tip height (-1 when unknown)
+	TargetHeight int64
+
+	// Block processing rate in the last measurement period
+	BlocksPerSecond float64
+
+	// Estimated time remaining until synced (0 when unknown or synced)
+	ETA time.Duration
+
+	// Manager is within the allowed lag from chain tip
+	Synced bool
+}
+
 // Block manager interface only purpose is to allow mock testing
 type BlockManagerInterface interface {
 	// Subscribe to new block updates
-	Subscribe(chanSize uint) UpdateChan
+	Subscribe(name string, chanSize uint) UpdateChan
+
+	// Subscribe a consumer tolerating missing updates, the configured slow
+	// consumer policy is applied
+	SubscribeExternal(name string, chanSize uint) UpdateChan
 
 	// Cancel subscription
 	Unsubscribe(ch UpdateChan)
 
-	// Return address balance
-	GetBalance(address string) int64
+	// Return address balance with at least minconf confirmations and the
+	// chain state it was computed at
+	GetBalance(ctx context.Context, address string, minconf int) (int64, ChainState, error)
+
+	// Return address balance without a manager round trip, fails with
+	// ErrCommitInProgress while committing
+	ReadBalance(ctx context.Context, address string) (int64, ChainState, error)
+
+	// Return the balance of several addresses
+	GetBalances(ctx context.Context, addresses []string) (map[string]int64, ChainState, error)
+
+	// Return the balance of several addresses without a manager round trip,
+	// fails with ErrCommitInProgress while committing
+	ReadBalances(ctx context.Context, addresses []string) (map[string]int64, ChainState, error)
+
+	// Return address unspent outputs
+	GetUtxos(ctx context.Context, address string) ([]Utxo, ChainState, error)
+
+	// Return address balance at a past height (requires history indexer)
+	GetBalanceAt(ctx context.Context, address string, height int64) (int64, ChainState, error)
+
+	// Return a page of address transactions (requires address tx indexer)
+	GetAddressTxs(ctx context.Context, query AddressTxQuery) (AddressTxPage, error)
+
+	// Return transaction output status
+	GetOutpoint(ctx context.Context, id storage.TxOutId) (Outpoint, error)
+
+	// Query a registered indexer, also returning the chain state
+	QueryIndexer(ctx context.Context, name string, query interface{}) (interface{}, ChainState, error)
+
+	// Populate TxOuts address and value
+	ResolveTxOuts(ctx context.Context, outs []*primitives.TxOut) error
 
 	// Get current blockchain height
-	GetHeight() (height int64)
+	GetHeight(ctx context.Context) (height int64, err error)
 
 	// Return true if manager synced with bitcoind
-	Synced() (sync bool)
+	Synced(ctx context.Context) (sync bool, err error)
+
+	// Block until manager is synced with bitcoind
+	WaitSynced(ctx context.Context) error
+
+	// Return sync progress
+	SyncProgress(ctx context.Context) (progress SyncProgress, err error)
+
+	// Return subscribers lag and delivery metrics
+	SubscriberStats() []events.Stats
 
 	// Safely stop block manager
 	Stop()
@@ -0,0 +1,164 @@
+package block_manager
+
+import (
+	"context"
+
+	"github.com/secnot/gobalance/primitives"
+	"github.com/secnot/gobalance/block_manager/storage"
+)
+
+const (
+	// Outpoint request channel size
+	OutpointRequestQueueSize = 10
+)
+
+type OutpointStatus int
+
+const (
+	// The output was never seen, or it was spent and isn't archived
+	OUTPOINT_UNKNOWN OutpointStatus = iota
+
+	// The output is in the utxo set
+	OUTPOINT_UNSPENT
+
+	// The output was spent
+	OUTPOINT_SPENT
+)
+
+func (s OutpointStatus) String() string {
+	switch s {
+	case OUTPOINT_UNSPENT:
+		return "unspent"
+	case OUTPOINT_SPENT:
+		return "spent"
+	default:
+		return "unknown"
+	}
+}
+
+// Outpoint is the status of a transaction output
+type Outpoint struct {
+	Status OutpointStatus
+
+	// Output address, value and height (only for unspent outputs)
+	Addr   string
+	Value  int64
+	Height int64
+
+	// Input spending the output (only for spent outputs)
+	SpentBy storage.SpentData
+}
+
+// OutpointRequest is used to request the status of an output through
+// OutpointChan
+type OutpointRequest struct {
+
+	// Request context, the request is discarded if cancelled while queued
+	Ctx context.Context
+
+	// Transaction hash and output number
+	Id storage.TxOutId
+
+	// Channel used to send the response
+	Resp chan OutpointResponse
+}
+
+// Outpoint request response
+type OutpointResponse struct {
+	Outpoint Outpoint
+	Err      error
+}
+
+// addPendingSpent indexes the outputs spent by a new pending block
+func (b *BlockManager) addPendingSpent(block *primitives.Block) {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinBase() {
+			continue
+		}
+		for nin, in := range tx.In {
+			id := storage.TxOutId{TxHash: *in.TxHash, Nout: in.Nout}
+			b.pendingSpent[id] = storage.SpentData{TxHash: *tx.Hash, Nin: uint32(nin), Height: int64(block.Height)}
+		}
+	}
+}
+
+// delPendingSpent removes the outputs spent by a block no longer pending,
+// either backtracked or confirmed and added to the storage cache.
+func (b *BlockManager) delPendingSpent(block *primitives.Block) {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinBase() {
+			continue
+		}
+		for _, in := range tx.In {
+			delete(b.pendingSpent, storage.TxOutId{TxHash: *in.TxHash, Nout: in.Nout})
+		}
+	}
+}
+
+// getOutpoint returns the output status from pending blocks, storage cache
+// or the spent archive.
+func (b *BlockManager) getOutpoint(id storage.TxOutId) (Outpoint, error) {
+	if spent, ok := b.pendingSpent[id]; ok {
+		return Outpoint{Status: OUTPOINT_SPENT, SpentBy: spent}, nil
+	}
+
+	// Output from a pending block
+	if tx, block := b.pendingBlocks.Tx(id.TxHash); tx != nil {
+		for _, out := range tx.Out {
+			if out.Nout == id.Nout && out.Addr != "" && out.Value != 0 {
+				return Outpoint {
+					Status: OUTPOINT_UNSPENT,
+					Addr:   out.Addr,
+					Value:  out.Value,
+					Height: int64(block.Height),
+				}, nil
+			}
+		}
+		return Outpoint{Status: OUTPOINT_UNKNOWN}, nil
+	}
+
+	// Output from storage
+	data, err := b.storageCache.GetTxOut(id)
+	if err != nil {
+		return Outpoint{}, err
+	}
+	if data.Addr != "" {
+		return Outpoint {
+			Status: OUTPOINT_UNSPENT,
+			Addr:   data.Addr,
+			Value:  data.Value,
+			Height: data.Height,
+		}, nil
+	}
+
+	spent, ok, err := b.storageCache.GetSpent(id)
+	if err != nil {
+		return Outpoint{}, err
+	}
+	if ok {
+		return Outpoint{Status: OUTPOINT_SPENT, SpentBy: spent}, nil
+	}
+	return Outpoint{Status: OUTPOINT_UNKNOWN}, nil
+}
+
+// GetOutpoint returns the status of a transaction output, spent outputs are
+// only identified within pending blocks unless the spent archive is enabled.
+func (b *BlockManager) GetOutpoint(ctx context.Context, id storage.TxOutId) (Outpoint, error) {
+	responseCh := make(chan OutpointResponse, 1)
+	select {
+	case b.OutpointChan <- OutpointRequest{Ctx: ctx, Id: id, Resp: responseCh}:
+	case <- ctx.Done():
+		return Outpoint{}, ctx.Err()
+	case <- b.done:
+		return Outpoint{}, ErrStopped
+	}
+
+	select {
+	case response := <- responseCh:
+		return response.Outpoint, response.Err
+	case <- ctx.Done():
+		return Outpoint{}, ctx.Err()
+	case <- b.done:
+		return Outpoint{}, ErrStopped
+	}
+}
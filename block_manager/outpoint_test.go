@@ -0,0 +1,61 @@
+package block_manager
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/primitives"
+	"github.com/secnot/gobalance/block_manager/storage"
+)
+
+// outpointIs checks the status returned by getOutpoint
+func outpointIs(t *testing.T, b *BlockManager, id storage.TxOutId, expected Outpoint) {
+	outpoint, err := b.getOutpoint(id)
+	if err != nil {
+		t.Errorf("getOutpoint(%v): %v", id, err)
+		return
+	}
+	if outpoint != expected {
+		t.Errorf("getOutpoint(%v): Expecting %v returned %v", id, expected, outpoint)
+	}
+}
+
+// Test output status from storage and pending blocks
+func TestGetOutpoint(t *testing.T) {
+	cache, err := storage.NewStorageCache(newStorage(t, 1), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &BlockManager {
+		storageCache:  cache,
+		pendingBlocks: primitives.NewBlockQueue(),
+		pendingSpent:  make(map[storage.TxOutId]storage.SpentData),
+	}
+
+	stored  := storage.TxOutId{TxHash: chainhash.Hash{0, 0, 0xff}}
+	block   := mockBlock(2, "address_1")
+	pending := storage.TxOutId{TxHash: block.Hash}
+	b.pendingBlocks.PushBack(block)
+	b.addPendingSpent(block)
+
+	outpointIs(t, b, stored, Outpoint{Status: OUTPOINT_UNSPENT, Addr: "address_0", Value: 100, Height: 1})
+	outpointIs(t, b, pending, Outpoint{Status: OUTPOINT_UNSPENT, Addr: "address_1", Value: 10, Height: 2})
+	outpointIs(t, b, storage.TxOutId{TxHash: block.Hash, Nout: 5}, Outpoint{Status: OUTPOINT_UNKNOWN})
+
+	// Spend both outputs in a new pending block
+	spendHash := chainhash.Hash{3}
+	tx := primitives.NewTx(&spendHash)
+	tx.AddIn(primitives.NewTxOut(&stored.TxHash, stored.Nout, "address_0", 100))
+	tx.AddIn(primitives.NewTxOut(&pending.TxHash, pending.Nout, "address_1", 10))
+	spendBlock := primitives.NewBlock(spendHash, block.Hash, 3)
+	spendBlock.AddTx(tx)
+	b.pendingBlocks.PushBack(spendBlock)
+	b.addPendingSpent(spendBlock)
+
+	outpointIs(t, b, stored, Outpoint{Status: OUTPOINT_SPENT, SpentBy: storage.SpentData{TxHash: spendHash, Nin: 0, Height: 3}})
+	outpointIs(t, b, pending, Outpoint{Status: OUTPOINT_SPENT, SpentBy: storage.SpentData{TxHash: spendHash, Nin: 1, Height: 3}})
+
+	// Backtracked spends are discarded
+	b.delPendingSpent(b.pendingBlocks.PopBack())
+	outpointIs(t, b, stored, Outpoint{Status: OUTPOINT_UNSPENT, Addr: "address_0", Value: 100, Height: 1})
+}
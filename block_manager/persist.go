@@ -0,0 +1,117 @@
+package block_manager
+
+import (
+	"os"
+	"log"
+	"path/filepath"
+	"io/ioutil"
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// BalanceSnapshot is the persisted stored balance cache, the storage balances
+// of the hot addresses when the block Hash at Height was the last committed.
+type BalanceSnapshot struct {
+	Height   int64            `json:"height"`
+	Hash     string           `json:"hash"`
+	Balances map[string]int64 `json:"balances"`
+}
+
+// SaveBalanceSnapshot writes the snapshot to path, replacing any previous one
+// only once it has been completely written.
+func SaveBalanceSnapshot(path string, snapshot *BalanceSnapshot) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(snapshot); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadBalanceSnapshot reads a snapshot saved with SaveBalanceSnapshot, returns
+// nil when there is no snapshot.
+func LoadBalanceSnapshot(path string) (*BalanceSnapshot, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	snapshot := &BalanceSnapshot{}
+	if err := json.NewDecoder(file).Decode(snapshot); err != nil {
+		return nil, err
+	}
+	if _, err := chainhash.NewHashFromStr(snapshot.Hash); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// saveStoredBalances persists the stored balance cache keyed by the last
+// committed block, it must be called from the manager routine so no commit
+// is in progress.
+func (b *BlockManager) saveStoredBalances() {
+	height, hash, err := b.storage.GetLastBlock()
+	if err != nil {
+		log.Print("Balance snapshot: ", err)
+		return
+	}
+
+	snapshot := &BalanceSnapshot {
+		Height:   height,
+		Hash:     hash.String(),
+		Balances: b.storedBalances.balances(atomic.LoadUint64(&b.commitSeq)),
+	}
+	if err := SaveBalanceSnapshot(b.BalanceSnapshotPath, snapshot); err != nil {
+		log.Print("Balance snapshot: ", err)
+	}
+}
+
+// restoreStoredBalances warms the stored balance cache with the saved
+// snapshot. When it wasn't taken at the last committed block the balances of
+// its addresses are read again from storage with a single query.
+func (b *BlockManager) restoreStoredBalances() {
+	snapshot, err := LoadBalanceSnapshot(b.BalanceSnapshotPath)
+	if err != nil {
+		log.Print("Balance snapshot: ", err)
+		return
+	}
+	if snapshot == nil {
+		return
+	}
+
+	height, hash, err := b.storage.GetLastBlock()
+	if err != nil {
+		log.Print("Balance snapshot: ", err)
+		return
+	}
+
+	balances := snapshot.Balances
+	if snapshot.Height != height || snapshot.Hash != hash.String() {
+		addresses := make([]string, 0, len(balances))
+		for addr := range balances {
+			addresses = append(addresses, addr)
+		}
+		if balances, err = b.storage.GetBalances(addresses); err != nil {
+			log.Print("Balance snapshot: ", err)
+			return
+		}
+		log.Printf("Balance snapshot: Taken at height %v, reloaded at %v", snapshot.Height, height)
+	}
+
+	b.storedBalances.install(atomic.LoadUint64(&b.commitSeq), balances)
+	log.Printf("Balance snapshot: %v addresses at height %v", len(balances), height)
+}
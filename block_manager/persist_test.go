@@ -0,0 +1,84 @@
+package block_manager
+
+import (
+	"context"
+	"testing"
+	"path/filepath"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/crawler"
+	"github.com/secnot/gobalance/block_manager/storage"
+)
+
+// Test stored balances are restored after a restart without reading storage
+func TestStoredBalancesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "balance_cache.json")
+	sto  := &countingStorage{Storage: newStorage(t, 10)}
+	ctx  := context.Background()
+
+	start := func() (*BlockManager, crawler.UpdateChan) {
+		manager := &BlockManager{Confirmations: 6, BalanceSnapshotPath: path}
+		updates := make(crawler.UpdateChan)
+		if err := manager.Start(context.Background(), sto, updates); err != nil {
+			t.Fatal(err)
+		}
+		return manager, updates
+	}
+	stop := func(manager *BlockManager, updates crawler.UpdateChan) {
+		close(updates)
+		manager.Stop()
+	}
+
+	addresses := []string{"address_0", "address_1", "address_2"}
+	manager, updates := start()
+	if _, _, err := manager.ReadBalances(ctx, addresses); err != nil {
+		t.Fatal("ReadBalances(): ", err)
+	}
+	stop(manager, updates)
+
+	// Restored balances don't query storage
+	sto.reads = 0
+	manager, updates = start()
+	for _, addr := range addresses {
+		if balance, _, err := manager.ReadBalance(ctx, addr); balance != 100 || err != nil {
+			t.Errorf("ReadBalance(): Expecting 100 returned %v, %v", balance, err)
+		}
+	}
+	if sto.reads != 0 {
+		t.Errorf("ReadBalance(): Expecting no storage reads, %v found", sto.reads)
+	}
+	if _, _, err := manager.ReadBalance(ctx, "address_3"); err != nil || sto.reads != 1 {
+		t.Errorf("ReadBalance(): Expecting a storage read, %v found %v", sto.reads, err)
+	}
+	stop(manager, updates)
+
+	// Reloaded with a single storage query once another block was committed
+	// after the snapshot was taken
+	inserts := map[storage.TxOutId]storage.TxOutData {
+		storage.TxOutId{TxHash: chainhash.Hash{0xee}}: storage.TxOutData{Addr: "address_0", Value: 50, Height: 2},
+	}
+	if err := sto.BulkUpdateFromMap(inserts, nil, 2, chainhash.Hash{2}); err != nil {
+		t.Fatal(err)
+	}
+	sto.reads, sto.queries = 0, 0
+	manager, updates = start()
+	defer stop(manager, updates)
+	if sto.queries != 1 || sto.reads != 4 {
+		t.Errorf("Start(): Expecting a single query for 4 balances, %v queries %v balances found", sto.queries, sto.reads)
+	}
+	for n, addr := range addresses {
+		expected := int64(100)
+		if n == 0 {
+			expected = 150
+		}
+		if balance, _, err := manager.ReadBalance(ctx, addr); balance != expected || err != nil {
+			t.Errorf("ReadBalance(): Expecting %v returned %v, %v", expected, balance, err)
+		}
+	}
+	if sto.queries != 1 {
+		t.Errorf("ReadBalance(): Expecting no storage queries after reload, %v found", sto.queries-1)
+	}
+	if snapshot, err := LoadBalanceSnapshot(path); err != nil || snapshot.Height != 1 || len(snapshot.Balances) != 4 {
+		t.Errorf("LoadBalanceSnapshot(): Unexpected %v, %v", snapshot, err)
+	}
+}
@@ -0,0 +1,368 @@
+package block_manager
+
+import (
+	"sync"
+	"errors"
+	"context"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/simplelru"
+	"github.com/secnot/gobalance/primitives"
+)
+
+const (
+	// Number of stored balances cached for the lock-free read path
+	StoredBalanceCacheSize = 100000
+)
+
+// ErrCommitInProgress is returned by the lock-free read path while a commit
+// is modifying storage, the request must be retried through the manager.
+var ErrCommitInProgress = errors.New("Commit in progress")
+
+// balanceLayer contains the balance delta for one or more blocks, once it is
+// published in a snapshot it is never modified.
+type balanceLayer map[string]int64
+
+// newBlockLayer builds the layer for a single block
+func newBlockLayer(block *primitives.Block) balanceLayer {
+	layer := make(balanceLayer)
+	for _, tx := range block.Transactions {
+		tx.ForEachAddress(func(addr string, balance int64, tx *primitives.Tx) {
+			layer[addr] += balance
+		})
+	}
+	return layer
+}
+
+// mergeLayers returns a new layer with the sum of a and b
+func mergeLayers(a, b balanceLayer) balanceLayer {
+	merged := make(balanceLayer, len(a)+len(b))
+	for addr, balance := range a {
+		merged[addr] = balance
+	}
+	for addr, balance := range b {
+		merged[addr] += balance
+	}
+	return merged
+}
+
+// Snapshot is an immutable view of the balance deltas not yet committed to
+// storage, it can be read concurrently while the manager builds the next one.
+type Snapshot struct {
+
+	// Height and hash of the last block included
+	Height int64
+	Hash   chainhash.Hash
+
+	// Confirmed blocks in the storage cache waiting for a commit
+	confirmed []balanceLayer
+
+	// One layer for each block pending of confirmation
+	pending []balanceLayer
+}
+
+// Balance returns the address balance delta for all the snapshot blocks
+func (s *Snapshot) Balance(address string) (balance int64) {
+	for _, layer := range s.confirmed {
+		balance += layer[address]
+	}
+	for _, layer := range s.pending {
+		balance += layer[address]
+	}
+	return balance
+}
+
+// storedBalanceCache caches storage balances for a commit sequence, after
+// each commit the cached balances are updated with the committed deltas.
+type storedBalanceCache struct {
+	sync.Mutex
+	size int
+	seq  uint64
+	lru  *simplelru.LRUCache
+
+	// Cached addresses, it may contain evicted addresses until the next prune
+	hot map[string]struct{}
+}
+
+func newStoredBalanceCache(size int) *storedBalanceCache {
+	return &storedBalanceCache {
+		size: size,
+		lru:  simplelru.NewLRUCache(size, size/10+1),
+		hot:  make(map[string]struct{}),
+	}
+}
+
+// reset discards cached balances if seq is newer, must be called with the lock held
+func (c *storedBalanceCache) reset(seq uint64) {
+	if seq > c.seq {
+		c.seq = seq
+		c.lru = simplelru.NewLRUCache(c.size, c.size/10+1)
+		c.hot = make(map[string]struct{})
+	}
+}
+
+// add caches a balance, must be called with the lock held
+func (c *storedBalanceCache) add(address string, balance int64) {
+	c.lru.Set(address, balance)
+	c.hot[address] = struct{}{}
+	if len(c.hot) > 2*c.size {
+		c.pruneHot()
+	}
+}
+
+// pruneHot removes evicted addresses from the hot set, must be called with
+// the lock held
+func (c *storedBalanceCache) pruneHot() {
+	for addr := range c.hot {
+		if _, ok := c.lru.Peek(addr); !ok {
+			delete(c.hot, addr)
+		}
+	}
+}
+
+// balances returns a copy of the balances cached for seq
+func (c *storedBalanceCache) balances(seq uint64) map[string]int64 {
+	c.Lock()
+	defer c.Unlock()
+	balances := make(map[string]int64)
+	if seq != c.seq {
+		return balances
+	}
+	c.pruneHot()
+	for addr := range c.hot {
+		if balance, ok := c.lru.Peek(addr); ok {
+			balances[addr] = balance.(int64)
+		}
+	}
+	return balances
+}
+
+// install caches all the balances for seq
+func (c *storedBalanceCache) install(seq uint64, balances map[string]int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.reset(seq)
+	if seq != c.seq {
+		return
+	}
+	for addr, balance := range balances {
+		c.add(addr, balance)
+	}
+}
+
+// purge discards all cached balances and moves the cache to seq
+func (c *storedBalanceCache) purge(seq uint64) {
+	c.Lock()
+	defer c.Unlock()
+	c.reset(seq)
+}
+
+// advance moves the cache to seq adding the committed layers deltas to the
+// cached balances, so they remain valid after the commit.
+func (c *storedBalanceCache) advance(seq uint64, committed []balanceLayer) {
+	c.Lock()
+	defer c.Unlock()
+	for _, layer := range committed {
+		for addr, delta := range layer {
+			if balance, ok := c.lru.Peek(addr); ok {
+				c.lru.Set(addr, balance.(int64)+delta)
+			}
+		}
+	}
+	c.seq = seq
+}
+
+func (c *storedBalanceCache) get(seq uint64, address string) (int64, bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.reset(seq)
+	if seq != c.seq {
+		return 0, false
+	}
+	balance, ok := c.lru.Get(address)
+	if !ok {
+		return 0, false
+	}
+	return balance.(int64), true
+}
+
+func (c *storedBalanceCache) set(seq uint64, address string, balance int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.reset(seq)
+	if seq == c.seq {
+		c.add(address, balance)
+	}
+}
+
+// pushLayer adds the layer for a new pending block
+func (b *BlockManager) pushLayer(block *primitives.Block) {
+	b.pendingLayers = append(b.pendingLayers, newBlockLayer(block))
+}
+
+// popLayer removes the layer for the last pending block
+func (b *BlockManager) popLayer() {
+	if n := len(b.pendingLayers); n > 0 {
+		b.pendingLayers[n-1] = nil
+		b.pendingLayers = b.pendingLayers[:n-1]
+	}
+}
+
+// confirmLayer moves the oldest pending layer to the confirmed ones, adjacent
+// confirmed layers are merged while the older isn't much larger than the
+// newer, so the number of layers grows logarithmically with the blocks.
+func (b *BlockManager) confirmLayer() {
+	if len(b.pendingLayers) == 0 {
+		return
+	}
+	layer := b.pendingLayers[0]
+	b.pendingLayers = b.pendingLayers[1:]
+
+	// Without balance index the storage cache doesn't count confirmed blocks
+	if b.Sync {
+		return
+	}
+
+	confirmed := append(b.confirmedLayers, layer)
+	for n := len(confirmed); n > 1 && len(confirmed[n-2]) <= 2*len(confirmed[n-1]); n = len(confirmed) {
+		merged := mergeLayers(confirmed[n-2], confirmed[n-1])
+		confirmed = append(confirmed[:n-2], merged)
+	}
+	b.confirmedLayers = confirmed
+}
+
+// publishSnapshot makes the current layers visible to readers
+func (b *BlockManager) publishSnapshot() {
+	snapshot := &Snapshot {
+		Height:    b.height,
+		Hash:      b.hash,
+		confirmed: append([]balanceLayer(nil), b.confirmedLayers...),
+		pending:   append([]balanceLayer(nil), b.pendingLayers...),
+	}
+	b.snapshot.Store(snapshot)
+}
+
+// currentSnapshot returns the last published snapshot
+func (b *BlockManager) currentSnapshot() *Snapshot {
+	return b.snapshot.Load().(*Snapshot)
+}
+
+// beginCommit marks a commit as started, readers retry through the manager
+// until endCommit is called.
+func (b *BlockManager) beginCommit() {
+	atomic.AddUint64(&b.commitSeq, 1)
+}
+
+// endCommit publishes the post commit snapshot and marks the commit as done,
+// the stored balance cache is updated before readers can use it again.
+func (b *BlockManager) endCommit(committed bool) {
+	seq := atomic.LoadUint64(&b.commitSeq) + 1
+	switch {
+	case !committed:
+		b.storedBalances.advance(seq, nil)
+	case b.Sync:
+		// Confirmed blocks aren't tracked, the balances can't be updated
+		b.storedBalances.purge(seq)
+	default:
+		b.storedBalances.advance(seq, b.confirmedLayers)
+		b.confirmedLayers = nil
+	}
+	b.publishSnapshot()
+	atomic.AddUint64(&b.commitSeq, 1)
+}
+
+// ReadBalance returns the address balance and the chain state of the snapshot
+// it was read from, without going through the manager routine so it can be
+// called concurrently from any goroutine. It returns ErrCommitInProgress while
+// storage is being modified by a commit.
+func (b *BlockManager) ReadBalance(ctx context.Context, address string) (int64, ChainState, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, ChainState{}, err
+	}
+
+	select {
+	case <- b.done:
+		return 0, ChainState{}, ErrStopped
+	default:
+	}
+
+	// The commit sequence is odd while a commit is in progress, if it
+	// changes the snapshot and storage balance may not be consistent.
+	seq := atomic.LoadUint64(&b.commitSeq)
+	if seq % 2 == 1 {
+		return 0, ChainState{}, ErrCommitInProgress
+	}
+
+	snapshot := b.currentSnapshot()
+	stored, ok := b.storedBalances.get(seq, address)
+	if !ok {
+		var err error
+		stored, err = b.storage.GetBalance(address)
+		if err != nil {
+			return 0, ChainState{}, err
+		}
+	}
+
+	if atomic.LoadUint64(&b.commitSeq) != seq {
+		return 0, ChainState{}, ErrCommitInProgress
+	}
+
+	if !ok {
+		b.storedBalances.set(seq, address, stored)
+	}
+	state := ChainState{Height: snapshot.Height, Hash: snapshot.Hash}
+	return stored + snapshot.Balance(address), state, nil
+}
+
+// ReadBalances is the batch version of ReadBalance, the balances not in the
+// stored balance cache are retrieved with a single storage query.
+func (b *BlockManager) ReadBalances(ctx context.Context, addresses []string) (map[string]int64, ChainState, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ChainState{}, err
+	}
+
+	select {
+	case <- b.done:
+		return nil, ChainState{}, ErrStopped
+	default:
+	}
+
+	seq := atomic.LoadUint64(&b.commitSeq)
+	if seq % 2 == 1 {
+		return nil, ChainState{}, ErrCommitInProgress
+	}
+
+	snapshot := b.currentSnapshot()
+	balances := make(map[string]int64, len(addresses))
+	missing  := make([]string, 0)
+	for _, addr := range addresses {
+		if stored, ok := b.storedBalances.get(seq, addr); ok {
+			balances[addr] = stored
+		} else {
+			missing = append(missing, addr)
+		}
+	}
+
+	var stored map[string]int64
+	if len(missing) > 0 {
+		var err error
+		if stored, err = b.storage.GetBalances(missing); err != nil {
+			return nil, ChainState{}, err
+		}
+	}
+
+	if atomic.LoadUint64(&b.commitSeq) != seq {
+		return nil, ChainState{}, ErrCommitInProgress
+	}
+
+	for addr, balance := range stored {
+		b.storedBalances.set(seq, addr, balance)
+		balances[addr] = balance
+	}
+	for addr := range balances {
+		balances[addr] += snapshot.Balance(addr)
+	}
+	state := ChainState{Height: snapshot.Height, Hash: snapshot.Hash}
+	return balances, state, nil
+}
@@ -0,0 +1,201 @@
+package block_manager
+
+import (
+	"fmt"
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/crawler"
+	"github.com/secnot/gobalance/primitives"
+	"github.com/secnot/gobalance/block_manager/storage"
+)
+
+// Number of addresses stored for benchmarks
+const benchAddresses = 1000
+
+// mockBlock returns a block with a single output for each address
+func mockBlock(height uint64, addresses ...string) *primitives.Block {
+	hash := chainhash.Hash{byte(height), byte(height >> 8)}
+	tx   := primitives.NewTx(&hash)
+	for n, addr := range addresses {
+		tx.AddOut(primitives.NewTxOut(&hash, uint32(n), addr, 10))
+	}
+	block := primitives.NewBlock(hash, primitives.ZeroHash, height)
+	block.AddTx(tx)
+	return block
+}
+
+// newStorage returns a storage where each address has a balance of 100
+func newStorage(t testing.TB, addresses int) storage.Storage {
+	sto, err := storage.NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inserts := make(map[storage.TxOutId]storage.TxOutData, addresses)
+	for n := 0; n < addresses; n++ {
+		id := storage.TxOutId{TxHash: chainhash.Hash{byte(n), byte(n >> 8), 0xff}}
+		inserts[id] = storage.TxOutData{Addr: fmt.Sprintf("address_%v", n), Value: 100, Height: 1}
+	}
+	if err := sto.BulkUpdateFromMap(inserts, nil, 1, primitives.ZeroHash); err != nil {
+		t.Fatal(err)
+	}
+	return sto
+}
+
+// countingStorage counts the balances read from storage, and the queries
+type countingStorage struct {
+	storage.Storage
+	reads   int
+	queries int
+}
+
+func (s *countingStorage) GetBalance(address string) (int64, error) {
+	s.reads++
+	s.queries++
+	return s.Storage.GetBalance(address)
+}
+
+func (s *countingStorage) GetBalances(addresses []string) (map[string]int64, error) {
+	s.reads += len(addresses)
+	s.queries++
+	return s.Storage.GetBalances(addresses)
+}
+
+// Test snapshot balances include pending and confirmed layers
+func TestSnapshotLayers(t *testing.T) {
+	sto := &countingStorage{Storage: newStorage(t, 2)}
+	b := &BlockManager {
+		storage:        sto,
+		storedBalances: newStoredBalanceCache(10),
+		done:           make(chan bool),
+	}
+	b.publishSnapshot()
+
+	for height := uint64(1); height <= 20; height++ {
+		b.pushLayer(mockBlock(height, "address_0"))
+		if len(b.pendingLayers) > 3 {
+			b.confirmLayer()
+		}
+	}
+	block21 := mockBlock(21, "address_0", "address_1")
+	b.pushLayer(block21)
+	b.height, b.hash = 21, block21.Hash
+	b.publishSnapshot()
+
+	if len(b.confirmedLayers) > 5 {
+		t.Errorf("confirmLayer(): Confirmed layers weren't merged %v", len(b.confirmedLayers))
+	}
+
+	ctx := context.Background()
+	if balance, _, err := b.ReadBalance(ctx, "address_0"); balance != 310 || err != nil {
+		t.Errorf("ReadBalance(): Expecting 310 returned %v, %v", balance, err)
+	}
+	if balance, _, err := b.ReadBalance(ctx, "address_1"); balance != 110 || err != nil {
+		t.Errorf("ReadBalance(): Expecting 110 returned %v, %v", balance, err)
+	}
+	state := ChainState{Height: 21, Hash: block21.Hash}
+	if _, st, err := b.ReadBalance(ctx, "address_1"); st != state || err != nil {
+		t.Errorf("ReadBalance(): Expecting %v returned %v, %v", state, st, err)
+	}
+	balances, st, err := b.ReadBalances(ctx, []string{"address_0", "address_1", "address_9"})
+	if err != nil || st != state || len(balances) != 3 || balances["address_0"] != 310 ||
+		balances["address_1"] != 110 || balances["address_9"] != 0 {
+		t.Errorf("ReadBalances(): Unexpected %v %v %v", balances, st, err)
+	}
+
+	// Published snapshots aren't modified by later blocks
+	snapshot := b.currentSnapshot()
+	b.popLayer()
+	b.publishSnapshot()
+	if snapshot.Balance("address_1") != 10 || b.currentSnapshot().Balance("address_1") != 0 {
+		t.Errorf("popLayer(): Published snapshot was modified")
+	}
+
+	// Readers fall back to the manager while committing
+	b.beginCommit()
+	if _, _, err := b.ReadBalance(ctx, "address_0"); err != ErrCommitInProgress {
+		t.Errorf("ReadBalance(): Expecting ErrCommitInProgress returned %v", err)
+	}
+	if _, _, err := b.ReadBalances(ctx, []string{"address_0"}); err != ErrCommitInProgress {
+		t.Errorf("ReadBalances(): Expecting ErrCommitInProgress returned %v", err)
+	}
+
+	// Commit the confirmed layers, cached balances are updated with them
+	confirmed := int64(0)
+	for _, layer := range b.confirmedLayers {
+		confirmed += layer["address_0"]
+	}
+	insert := map[storage.TxOutId]storage.TxOutData {
+		storage.TxOutId{TxHash: chainhash.Hash{0xee}}: {Addr: "address_0", Value: confirmed, Height: 20},
+	}
+	if err := sto.BulkUpdateFromMap(insert, nil, 20, primitives.ZeroHash); err != nil {
+		t.Fatal(err)
+	}
+	reads := sto.reads
+	b.endCommit(true)
+	if balance, _, err := b.ReadBalance(ctx, "address_0"); balance != 300 || err != nil {
+		t.Errorf("ReadBalance(): Expecting 300 returned %v, %v", balance, err)
+	}
+	if sto.reads != reads {
+		t.Errorf("endCommit(): Expecting cached balances kept, %v storage reads", sto.reads-reads)
+	}
+
+	close(b.done)
+	if _, _, err := b.ReadBalance(ctx, "address_0"); err != ErrStopped {
+		t.Errorf("ReadBalance(): Expecting ErrStopped returned %v", err)
+	}
+}
+
+// startBenchManager starts a manager with benchAddresses stored balances
+func startBenchManager(b *testing.B) (*BlockManager, crawler.UpdateChan) {
+	manager := &BlockManager{Confirmations: 6}
+	updates := make(crawler.UpdateChan)
+	if err := manager.Start(context.Background(), newStorage(b, benchAddresses), updates); err != nil {
+		b.Fatal(err)
+	}
+	return manager, updates
+}
+
+// Balance requests through the manager routine
+func BenchmarkGetBalanceChannel(b *testing.B) {
+	manager, updates := startBenchManager(b)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		for pb.Next() {
+			if _, _, err := manager.GetBalance(ctx, fmt.Sprintf("address_%v", n%benchAddresses), 0); err != nil {
+				b.Error(err)
+			}
+			n++
+		}
+	})
+	b.StopTimer()
+
+	close(updates)
+	manager.Stop()
+}
+
+// Balance requests reading snapshots concurrently
+func BenchmarkReadBalanceSnapshot(b *testing.B) {
+	manager, updates := startBenchManager(b)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		for pb.Next() {
+			if _, _, err := manager.ReadBalance(ctx, fmt.Sprintf("address_%v", n%benchAddresses)); err != nil {
+				b.Error(err)
+			}
+			n++
+		}
+	})
+	b.StopTimer()
+
+	close(updates)
+	manager.Stop()
+}
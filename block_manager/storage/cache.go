@@ -19,23 +19,36 @@ type StorageCache struct {
 	// pending deletions
 	deletions map[TxOutId]bool
 
+	// spent outputs pending of archiving
+	spent map[TxOutId]SpentData
+
 	// uncommited txouts address balance
 	balance map[string]int64
 
+	// pending inserts by address, so GetUtxos doesn't scan all the inserts
+	addrInserts map[string]map[TxOutId]struct{}
+
 	// Heigh and has for the last block in cache (NOT THE SAME AS STORED)
 	height int64
 
 	lastBlockHash chainhash.Hash
+
+	// Height of the last block committed to storage
+	committedHeight int64
 	
 	//
 	balanceIndexEnabled bool
 
+	// Archive spent outputs on commit
+	spentArchiveEnabled bool
+
 	// Number of blocks added but not yet commited
 	uncommittedBlocks int
 }
 
-// NewStorageCache creates a new cache, with or without balance indexing
-func NewStorageCache(sto Storage, balanceIndex bool) (s *StorageCache, err error) {
+// NewStorageCache creates a new cache, with or without balance indexing and
+// spent outputs archive.
+func NewStorageCache(sto Storage, balanceIndex bool, spentArchive bool) (s *StorageCache, err error) {
 	height, hash, err := sto.GetLastBlock()
 	if err != nil {
 		return nil, err
@@ -45,11 +58,15 @@ func NewStorageCache(sto Storage, balanceIndex bool) (s *StorageCache, err error
 		sto:                 sto,
 		inserts:             make(map[TxOutId]TxOutData, InitialQueueSize),
 		deletions:           make(map[TxOutId]bool, InitialQueueSize),
+		spent:               make(map[TxOutId]SpentData),
 		balance :            make(map[string]int64, InitialQueueSize),
+		addrInserts:         make(map[string]map[TxOutId]struct{}),
 		height:              height,
 		lastBlockHash:       hash,
+		committedHeight:     height,
 		uncommittedBlocks:   0,
 		balanceIndexEnabled: balanceIndex,
+		spentArchiveEnabled: spentArchive,
 	}
 
 	return &cache, nil
@@ -66,9 +83,9 @@ func (s *StorageCache) Len() (length int, err error){
 	return length, nil
 }
 
-// UncommitedLen returns the number of uncommitted inserts+deletions
+// UncommitedLen returns the number of uncommitted inserts+deletions+spent
 func (s *StorageCache) UncommittedLen() (size int) {
-	return len(s.inserts) + len(s.deletions)
+	return len(s.inserts) + len(s.deletions) + len(s.spent)
 }
 
 // UncommittedBlocks returns the number of blocks not co
@@ -86,6 +103,11 @@ func (s *StorageCache) GetHeight() int64 {
 	return s.height
 }
 
+// CommittedHeight returns the height of the last block in storage
+func (s *StorageCache) CommittedHeight() int64 {
+	return s.committedHeight
+}
+
 // SetHash sets last block hash
 func (s *StorageCache) SetHash(hash chainhash.Hash) {
 	s.lastBlockHash = hash
@@ -186,7 +208,7 @@ func (s *StorageCache) updateBalance(address string, balance int64) {
 }
 
 // AddTxOut queues a TxOut for insertion into storage (without updating balance index
-func (s *StorageCache) addTxOut(utxo primitives.TxOut) {
+func (s *StorageCache) addTxOut(utxo primitives.TxOut, height int64) {
 	
 	id   := TxOutId{TxHash: *utxo.TxHash, Nout: utxo.Nout}
 	if _, ok := s.deletions[id]; ok {
@@ -194,15 +216,25 @@ func (s *StorageCache) addTxOut(utxo primitives.TxOut) {
 		return
 	}
 	
-	s.inserts[id] = TxOutData{Addr: utxo.Addr, Value: utxo.Value}
+	s.inserts[id] = TxOutData{Addr: utxo.Addr, Value: utxo.Value, Height: height}
+	if s.addrInserts[utxo.Addr] == nil {
+		s.addrInserts[utxo.Addr] = make(map[TxOutId]struct{})
+	}
+	s.addrInserts[utxo.Addr][id] = struct{}{}
 }
 
 // DelTxOut queues TxOutId for deletion from storage
 func (s *StorageCache) delTxOut(id TxOutId) {
 	
 	// If utxo is a pending insert discard it and return
-	if _, ok := s.inserts[id]; ok {
+	if data, ok := s.inserts[id]; ok {
 		delete(s.inserts, id)
+		if ids := s.addrInserts[data.Addr]; ids != nil {
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(s.addrInserts, data.Addr)
+			}
+		}
 		return
 	}
 
@@ -218,17 +250,21 @@ func (s *StorageCache) AddBlock(block *primitives.Block) error {
 		// Add transaction outputs
 		for _, out := range tx.Out {
 			if out.Addr != "" && out.Value != 0 {
-				s.addTxOut(*out)
+				s.addTxOut(*out, int64(block.Height))
 				s.updateBalance(out.Addr, out.Value)
 			}
 		}
 
 		// Delete transaction inputs
-		for _, in := range tx.In {
-			s.delTxOut(TxOutId{TxHash: *in.TxHash, Nout: in.Nout})
+		for nin, in := range tx.In {
+			id := TxOutId{TxHash: *in.TxHash, Nout: in.Nout}
+			s.delTxOut(id)
 			if in.Addr != "" && in.Value != 0 {
 				s.updateBalance(in.Addr, -in.Value)
 			}
+			if s.spentArchiveEnabled && !tx.IsCoinBase() {
+				s.spent[id] = SpentData{TxHash: *tx.Hash, Nin: uint32(nin), Height: int64(block.Height)}
+			}
 		}
 	}
 		
@@ -239,22 +275,78 @@ func (s *StorageCache) AddBlock(block *primitives.Block) error {
 	return nil
 }
 
+// GetSpent returns the input spending an output from the uncommitted blocks
+// or the archive, ok is false when unknown.
+func (s *StorageCache) GetSpent(id TxOutId) (spent SpentData, ok bool, err error) {
+	if spent, ok := s.spent[id]; ok {
+		return spent, true, nil
+	}
+	if !s.spentArchiveEnabled {
+		return spent, false, nil
+	}
+	return s.sto.GetSpent(id)
+}
+
 // GetBalance returns the address balance
 func (s *StorageCache) GetBalance(address string) (int64, error) {
 	storedBalance, err := s.sto.GetBalance(address)
 	if err != nil {
-		return -1, nil
+		return 0, err
 	}
 	
 	cachedBalance := s.balance[address]
 	return cachedBalance+storedBalance, nil
 }
 
-// Commit pending insertion, deletions, and height into storage
-func (s *StorageCache) Commit() (err error){
+// GetUtxos returns the address unspent txouts in storage and the uncommitted
+// inserts, minus the uncommitted deletions.
+func (s *StorageCache) GetUtxos(address string) (map[TxOutId]TxOutData, error) {
+	utxos, err := s.sto.GetUtxos(address)
+	if err != nil {
+		return nil, err
+	}
+
+	for id := range utxos {
+		if _, ok := s.deletions[id]; ok {
+			delete(utxos, id)
+		}
+	}
+
+	for id := range s.addrInserts[address] {
+		utxos[id] = s.inserts[id]
+	}
+	return utxos, nil
+}
+
+// GetBalances returns the balance of several addresses with a single
+// storage query
+func (s *StorageCache) GetBalances(addresses []string) (map[string]int64, error) {
+	balances, err := s.sto.GetBalances(addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	for addr := range balances {
+		balances[addr] += s.balance[addr]
+	}
+	return balances, nil
+}
+
+// Commit pending insertion, deletions, and height into storage, the
+// hooks are called within the storage transaction.
+func (s *StorageCache) Commit(hooks ...CommitHook) (err error){
+
+	// Spent outputs are archived within the same transaction
+	if len(s.spent) > 0 {
+		spent := s.spent
+		archive := func(tx Tx) error {
+			return s.sto.ArchiveSpent(tx, spent)
+		}
+		hooks = append([]CommitHook{archive}, hooks...)
+	}
 
 	// Update DB
-	err = s.sto.BulkUpdateFromMap(s.inserts, s.deletions, s.height, s.lastBlockHash)
+	err = s.sto.BulkUpdateFromMap(s.inserts, s.deletions, s.height, s.lastBlockHash, hooks...)
 	if err != nil {
 		return err
 	}
@@ -263,9 +355,12 @@ func (s *StorageCache) Commit() (err error){
 	s.inserts   = make(map[TxOutId]TxOutData, InitialQueueSize)
 	s.deletions = make(map[TxOutId]bool, InitialQueueSize)
 	s.balance   = make(map[string]int64, InitialQueueSize)
+	s.spent     = make(map[TxOutId]SpentData)
+	s.addrInserts = make(map[string]map[TxOutId]struct{})
 
 	// All blocks have beeen committed
 	s.uncommittedBlocks = 0
+	s.committedHeight   = s.height
 
 	// Clean inserts and deletions
 	return nil
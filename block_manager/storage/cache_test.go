@@ -72,7 +72,7 @@ func TestCacheLen(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	cache, err := NewStorageCache(storage, true)
+	cache, err := NewStorageCache(storage, true, false)
 	if err != nil {
 		t.Error(err)
 		return
@@ -89,7 +89,7 @@ func TestCacheLen(t *testing.T) {
 	outsId := TxOutToId(outs)
 
 	for _, out := range outs {
-		cache.addTxOut(out)
+		cache.addTxOut(out, 0)
 	}
 	cacheLen(t, cache, 0)
 	cacheUncommittedLen(t, cache, len(outs))
@@ -114,14 +114,14 @@ func TestCacheLen(t *testing.T) {
 
 	// Delete some uncommitted TxOuts
 	storage, _ = NewSQLiteStorage(":memory:")
-	cache, _ = NewStorageCache(storage, true)
+	cache, _ = NewStorageCache(storage, true, false)
 	cache.SetHeight(100)
 	
 	more   := mockTxOuts(4000, 5000, 1, 0)
 	moreId := TxOutToId(more)
 	
 	for _, out := range more {
-		cache.addTxOut(out)
+		cache.addTxOut(out, 0)
 	}
 	cacheLen(t, cache, 0)
 	cacheUncommittedLen(t, cache, len(more))
@@ -134,11 +134,11 @@ func TestCacheLen(t *testing.T) {
 
 	// Mixed add and delete
 	storage, _ = NewSQLiteStorage(":memory:")
-	cache, _ = NewStorageCache(storage, true)
+	cache, _ = NewStorageCache(storage, true, false)
 	cache.SetHeight(100)
 	
 	for _, out := range more {
-		cache.addTxOut(out)
+		cache.addTxOut(out, 0)
 	}
 	for _, out := range outsId {
 		cache.delTxOut(out)
@@ -157,7 +157,7 @@ func TestCacheLen(t *testing.T) {
 		storage.Set(out)
 	}
 	
-	cache, _ = NewStorageCache(storage, true)
+	cache, _ = NewStorageCache(storage, true, false)
 	cache.SetHeight(100)
 	
 	cacheLen(t, cache, len(outs))
@@ -173,7 +173,7 @@ func TestUncommittedBlocks(t *testing.T) {
 		return
 	}
 
-	cache, err := NewStorageCache(storage, true)
+	cache, err := NewStorageCache(storage, true, false)
 	if err != nil {
 		t.Error("NewStorageCache(): ", err)
 		return
@@ -192,12 +192,19 @@ func TestUncommittedBlocks(t *testing.T) {
 		return
 	}
 
+	if height := cache.CommittedHeight(); height != -1 {
+		t.Errorf("CommittedHeight(): Expecting -1 returned %v", height)
+	}
+
 	// Commit and check it is 0 again
 	cache.Commit()
 	if num := cache.UncommittedBlocks(); num != 0 {
 		t.Errorf("UncommittedBlocks(): Expecting 0 returned %s", num)
 		return
 	}
+	if height := cache.CommittedHeight(); height != 1 {
+		t.Errorf("CommittedHeight(): Expecting 1 returned %v", height)
+	}
 }
 
 
@@ -212,7 +219,7 @@ func TestCacheGetHashHeight(t *testing.T) {
 		return
 	}
 
-	cache, err := NewStorageCache(storage, true)
+	cache, err := NewStorageCache(storage, true, false)
 	if err != nil {
 		t.Error("NewStorageCache(): ", err)
 		return
@@ -237,7 +244,7 @@ func TestCacheGetHashHeight(t *testing.T) {
 	
 	storage.SetLastBlock(999, primitives.MainNetGenesisHash)
 
-	cache, err = NewStorageCache(storage, true)
+	cache, err = NewStorageCache(storage, true, false)
 	if err != nil {
 		t.Error("NewStorageCache(): ", err)
 		return
@@ -303,7 +310,7 @@ func TestCacheGetHashHeight(t *testing.T) {
 // Test Contains
 func TestCacheContains(t *testing.T) {
 	storage, _ := NewSQLiteStorage(":memory:")
-	cache, _ := NewStorageCache(storage, true)
+	cache, _ := NewStorageCache(storage, true, false)
 	cache.SetHeight(100)
 
 	checkContains := func (sto *StorageCache, out primitives.TxOut) {
@@ -337,7 +344,7 @@ func TestCacheContains(t *testing.T) {
 	outsId := TxOutToId(outs)
 
 	for _, out := range outs {
-		cache.addTxOut(out)
+		cache.addTxOut(out, 0)
 	}
 	for _, out := range outs {
 		checkContains(cache, out)
@@ -385,7 +392,7 @@ func TestCacheGetTxOut(t *testing.T) {
 
 
 	// Start cache
-	cache, err := NewStorageCache(storage, true)
+	cache, err := NewStorageCache(storage, true, false)
 	if err != nil {
 		t.Error("NewStorageCache(): ", err)
 		return
@@ -400,7 +407,7 @@ func TestCacheGetTxOut(t *testing.T) {
 	moreOuts := mockTxOuts(200000, 201000, 2, 0)
 
 	for _, out := range moreOuts {
-		cache.addTxOut(out)
+		cache.addTxOut(out, 0)
 	}
 
 	for _, out := range moreOuts {
@@ -435,7 +442,7 @@ func TestCacheBulkGetTxOut(t *testing.T) {
 
 
 	// Start cache
-	cache, err := NewStorageCache(storage, true)
+	cache, err := NewStorageCache(storage, true, false)
 	if err != nil {
 		t.Error("NewStorageCache(): ", err)
 		return
@@ -465,7 +472,7 @@ func TestCacheBulkGetTxOut(t *testing.T) {
 	mixedIds  := TxOutToId(mixed)
 	mixedData := TxOutToData(mixed)
 	for _, out := range mixed[:500] {
-		cache.addTxOut(out)
+		cache.addTxOut(out, 0)
 	}
 	
 	if err := cache.Commit(); err != nil {
@@ -474,7 +481,7 @@ func TestCacheBulkGetTxOut(t *testing.T) {
 	}
 	
 	for _, out := range mixed[500:] {
-		cache.addTxOut(out)
+		cache.addTxOut(out, 0)
 	}
 
 	returned , err := cache.BulkGetTxOut(mixedIds)
@@ -504,7 +511,7 @@ func TestCacheBulkGetTxOutDuplicates(t *testing.T) {
 	}
 	initStorage(t, storage, txout1)
 
-	cache, err := NewStorageCache(storage, true)
+	cache, err := NewStorageCache(storage, true, false)
 	if err != nil {
 		t.Error("NewStorageCache(): ", err)
 		return
@@ -533,14 +540,14 @@ func TestCacheBulkGetTxOutDuplicates(t *testing.T) {
 // Test addTxOut
 func TestCacheaddTxOut(t *testing.T) {
 	storage, _ := NewSQLiteStorage(":memory:")
-	cache, _   := NewStorageCache(storage, true)
+	cache, _   := NewStorageCache(storage, true, false)
 	cache.SetHeight(1000)
 	cache.SetHash(primitives.MainNetGenesisHash)
 
 	// Add TxOut and check they are available before commit
 	outs   := mockTxOuts(1000, 2000, 2, 0)
 	for _, out := range outs {
-		cache.addTxOut(out)
+		cache.addTxOut(out, 0)
 	}
 
 	for _, out := range outs {
@@ -591,13 +598,13 @@ func TestCacheaddTxOut(t *testing.T) {
 // Test delTxOut
 func TestCachedelTxOut(t *testing.T) {
 	storage, _ := NewSQLiteStorage(":memory:")
-	cache, _   := NewStorageCache(storage, true)
+	cache, _   := NewStorageCache(storage, true, false)
 
 	outs   := mockTxOuts(0, 400, 1, 1)
 	outsId := TxOutToId(outs)
 
 	for _, out := range outs {
-		cache.addTxOut(out)
+		cache.addTxOut(out, 0)
 	}
 
 	// Remove half of the inserted txouts
@@ -666,10 +673,10 @@ func TestCacheCommitErrors(t *testing.T) {
 
 	// Check negative value TxOut
 	storage, _ := NewSQLiteStorage(":memory:")
-	cache, _   := NewStorageCache(storage, true)
+	cache, _   := NewStorageCache(storage, true, false)
 	cache.SetHeight(1000)
 
-	cache.addTxOut(*negativeTxOut)
+	cache.addTxOut(*negativeTxOut, 0)
 	if err := cache.Commit(); err != ErrNegativeUtxo {
 		t.Error(err)
 		return
@@ -677,10 +684,10 @@ func TestCacheCommitErrors(t *testing.T) {
 
 	// Check zero value TxOut
 	storage, _ = NewSQLiteStorage(":memory:")
-	cache, _   = NewStorageCache(storage, true)
+	cache, _   = NewStorageCache(storage, true, false)
 	cache.SetHeight(1000)
 
-	cache.addTxOut(*zeroTxOut)
+	cache.addTxOut(*zeroTxOut, 0)
 	if err := cache.Commit(); err != ErrUnexpendableUtxo {
 		t.Error(err)
 		return
@@ -688,10 +695,10 @@ func TestCacheCommitErrors(t *testing.T) {
 
 	// Check address-less TxOut
 	storage, _ = NewSQLiteStorage(":memory:")
-	cache, _   = NewStorageCache(storage, true)
+	cache, _   = NewStorageCache(storage, true, false)
 	cache.SetHeight(1000)
 
-	cache.addTxOut(*noAddressTxOut)
+	cache.addTxOut(*noAddressTxOut, 0)
 	if err := cache.Commit(); err != ErrUnexpendableUtxo {
 		t.Error(err)
 		return
@@ -699,10 +706,10 @@ func TestCacheCommitErrors(t *testing.T) {
 
 	// Check negative height
 	storage, _ = NewSQLiteStorage(":memory:")
-	cache, _   = NewStorageCache(storage, true)
+	cache, _   = NewStorageCache(storage, true, false)
 	cache.SetHeight(-1000)
 
-	cache.addTxOut(*validTxOut)
+	cache.addTxOut(*validTxOut, 0)
 	if err := cache.Commit(); err != ErrNegativeHeight {
 		t.Error(err)
 		return
@@ -710,14 +717,14 @@ func TestCacheCommitErrors(t *testing.T) {
 
 	// Check there's a rollback on error
 	storage, _ = NewSQLiteStorage(":memory:")
-	cache, _   = NewStorageCache(storage, true)
+	cache, _   = NewStorageCache(storage, true, false)
 	cache.SetHeight(1000)
 
 	outs   := mockTxOuts(0, 400, 1, 1)
 	for _, out := range outs {
-		cache.addTxOut(out)
+		cache.addTxOut(out, 0)
 	}
-	cache.addTxOut(*negativeTxOut)
+	cache.addTxOut(*negativeTxOut, 0)
 
 	if err := cache.Commit(); err != ErrNegativeUtxo {
 		t.Error(err)
@@ -752,7 +759,7 @@ func TestCacheAddressIndex(t *testing.T) {
 
 	// Cache with balance indexing enabled
 	storage, _ := NewSQLiteStorage(":memory:")
-	cache, _   := NewStorageCache(storage, true)
+	cache, _   := NewStorageCache(storage, true, false)
 	cache.SetHeight(1000)
 
 	cache.AddBlock(balanceBlock)
@@ -773,7 +780,7 @@ func TestCacheAddressIndex(t *testing.T) {
 
 	// Cache with balance indexing disabled	
 	storage, _ = NewSQLiteStorage(":memory:")
-	cache, _   = NewStorageCache(storage, false)
+	cache, _   = NewStorageCache(storage, false, false)
 	cache.SetHeight(1000)
 
 	cache.AddBlock(balanceBlock)
@@ -791,3 +798,177 @@ func TestCacheAddressIndex(t *testing.T) {
 		t.Error("Balance index was not enabled")
 	}
 }
+
+// Test storage errors are returned by GetBalance
+func TestCacheBalanceError(t *testing.T) {
+	storage, _ := NewSQLiteStorage(":memory:")
+	cache, _   := NewStorageCache(storage, true, false)
+	
+	storage.MarkDirty("Testing")
+	if balance, err := cache.GetBalance("an_address"); balance != 0 || err != ErrDirtyStorage {
+		t.Errorf("GetBalance(): Expecting Dirty Storage Error returned %v, %v", balance, err)
+	}
+}
+
+// Test GetBalances combines storage and uncommitted balances
+func TestCacheGetBalances(t *testing.T) {
+	hash := mockHash(23123)
+	balanceTx := primitives.NewTx(&hash)
+	balanceTx.AddOut(primitives.NewTxOut(&hash, 0, "an_address", 1000))
+	balanceTx.AddOut(primitives.NewTxOut(&hash, 1, "other_address", 10))
+
+	balanceBlock := primitives.NewBlock(hash, primitives.MainNetGenesisHash, 1001)
+	balanceBlock.AddTx(balanceTx)
+
+	storage, _ := NewSQLiteStorage(":memory:")
+	cache, _   := NewStorageCache(storage, true, false)
+	cache.SetHeight(1000)
+	cache.AddBlock(balanceBlock)
+	if err := cache.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	moreHash := mockHash(23124)
+	moreTx := primitives.NewTx(&moreHash)
+	moreTx.AddOut(primitives.NewTxOut(&moreHash, 0, "an_address", 5))
+	moreBlock := primitives.NewBlock(moreHash, hash, 1002)
+	moreBlock.AddTx(moreTx)
+	cache.AddBlock(moreBlock)
+
+	balances, err := cache.GetBalances([]string{"an_address", "other_address", "unknown"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if balances["an_address"] != 1005 || balances["other_address"] != 10 || 
+		balances["unknown"] != 0 || len(balances) != 3 {
+		t.Errorf("GetBalances(): Unexpected balances %v", balances)
+	}
+}
+
+// Test GetUtxos merges storage and uncommitted outputs
+func TestCacheGetUtxos(t *testing.T) {
+	storage, _ := NewSQLiteStorage(":memory:")
+	cache, _   := NewStorageCache(storage, true, false)
+	cache.SetHeight(1000)
+
+	hash := mockHash(1)
+	tx := primitives.NewTx(&hash)
+	tx.AddOut(primitives.NewTxOut(&hash, 0, "an_address", 1000))
+	tx.AddOut(primitives.NewTxOut(&hash, 1, "an_address", 10))
+	block := primitives.NewBlock(hash, primitives.MainNetGenesisHash, 1001)
+	block.AddTx(tx)
+	cache.AddBlock(block)
+	if err := cache.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Spend a stored output and create a new one
+	spendHash := mockHash(2)
+	spendTx := primitives.NewTx(&spendHash)
+	spendTx.AddIn(primitives.NewTxOut(&hash, 1, "an_address", 10))
+	spendTx.AddOut(primitives.NewTxOut(&spendHash, 0, "an_address", 5))
+	spendBlock := primitives.NewBlock(spendHash, hash, 1002)
+	spendBlock.AddTx(spendTx)
+	cache.AddBlock(spendBlock)
+
+	utxos, err := cache.GetUtxos("an_address")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[TxOutId]TxOutData {
+		TxOutId{TxHash: hash, Nout: 0}:      TxOutData{Addr: "an_address", Value: 1000, Height: 1001},
+		TxOutId{TxHash: spendHash, Nout: 0}: TxOutData{Addr: "an_address", Value: 5, Height: 1002},
+	}
+	if len(utxos) != len(expected) {
+		t.Errorf("GetUtxos(): Expecting %v returned %v", expected, utxos)
+	}
+	for id, data := range expected {
+		if utxos[id] != data {
+			t.Errorf("GetUtxos(): Expecting %v returned %v", data, utxos[id])
+		}
+	}
+
+	// Spending an uncommitted insert removes it from the address index
+	lastHash := mockHash(3)
+	lastTx := primitives.NewTx(&lastHash)
+	lastTx.AddIn(primitives.NewTxOut(&spendHash, 0, "an_address", 5))
+	lastTx.AddOut(primitives.NewTxOut(&lastHash, 0, "other_address", 5))
+	lastBlock := primitives.NewBlock(lastHash, spendHash, 1003)
+	lastBlock.AddTx(lastTx)
+	cache.AddBlock(lastBlock)
+
+	utxos, _ = cache.GetUtxos("an_address")
+	if _, ok := utxos[TxOutId{TxHash: spendHash, Nout: 0}]; ok || len(utxos) != 1 {
+		t.Errorf("GetUtxos(): Expecting spent insert removed, returned %v", utxos)
+	}
+	if len(cache.addrInserts) != 1 || len(cache.addrInserts["other_address"]) != 1 {
+		t.Errorf("GetUtxos(): Unexpected address index %v", cache.addrInserts)
+	}
+
+	if err := cache.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.addrInserts) != 0 {
+		t.Errorf("Commit(): Expecting empty address index %v", cache.addrInserts)
+	}
+	if utxos, _ = cache.GetUtxos("other_address"); len(utxos) != 1 {
+		t.Errorf("GetUtxos(): Expecting committed output returned %v", utxos)
+	}
+}
+
+func TestCacheSpentArchive(t *testing.T) {
+	storage, _ := NewSQLiteStorage(":memory:")
+	cache, _   := NewStorageCache(storage, true, true)
+	cache.SetHeight(1000)
+
+	hash := mockHash(1)
+	tx := primitives.NewTx(&hash)
+	tx.AddOut(primitives.NewTxOut(&hash, 0, "an_address", 1000))
+	tx.AddOut(primitives.NewTxOut(&hash, 1, "an_address", 10))
+	block := primitives.NewBlock(hash, primitives.MainNetGenesisHash, 1001)
+	block.AddTx(tx)
+	cache.AddBlock(block)
+
+	// Spend an output created by an uncommitted block
+	spendHash := mockHash(2)
+	spendTx := primitives.NewTx(&spendHash)
+	spendTx.AddIn(primitives.NewTxOut(&hash, 0, "an_address", 1000))
+	spendTx.AddIn(primitives.NewTxOut(&hash, 1, "an_address", 10))
+	spendBlock := primitives.NewBlock(spendHash, hash, 1002)
+	spendBlock.AddTx(spendTx)
+	cache.AddBlock(spendBlock)
+
+	spentIs := func(id TxOutId, expected SpentData) {
+		spent, ok, err := cache.GetSpent(id)
+		if err != nil {
+			t.Error("GetSpent(): ", err)
+			return
+		}
+		if !ok || spent != expected {
+			t.Errorf("GetSpent(%v): Expecting %v returned %v %v", id, expected, spent, ok)
+		}
+	}
+
+	id0 := TxOutId{TxHash: hash, Nout: 0}
+	id1 := TxOutId{TxHash: hash, Nout: 1}
+	spentIs(id0, SpentData{TxHash: spendHash, Nin: 0, Height: 1002})
+	spentIs(id1, SpentData{TxHash: spendHash, Nin: 1, Height: 1002})
+
+	// Archived on commit
+	if err := cache.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+	cacheUncommittedLen(t, cache, 0)
+	spentIs(id0, SpentData{TxHash: spendHash, Nin: 0, Height: 1002})
+	spentIs(id1, SpentData{TxHash: spendHash, Nin: 1, Height: 1002})
+
+	if _, ok, _ := cache.GetSpent(TxOutId{TxHash: spendHash, Nout: 0}); ok {
+		t.Error("GetSpent(): Unspent output returned as spent")
+	}
+}
@@ -12,6 +12,9 @@ const (
 	// True and false values for sqlite
 	True  = 1
 	False = 0
+
+	// Max number of addresses in a single balance query
+	MaxBalanceQueryAddresses = 500
 )
 
 type utxo struct {
@@ -19,6 +22,7 @@ type utxo struct {
 	Value  int64           `db:"value"`// Output ammount
 	Addr   string          `db:"addr"` // Bitcoin address from pkScript
 	Nout   uint32          `db:"nout"` // Output number
+	Height int64           `db:"height"`// Block height
 }
 
 var SCHEMAS = [...]string {
@@ -26,6 +30,7 @@ var SCHEMAS = [...]string {
 			 nout integer NOT NULL,
 			 addr text NOT NULL,
 			 value integer NOT NULL,
+			 height integer NOT NULL DEFAULT 0,
 			 PRIMARY KEY(tx, nout))`,
 	`last_block (pk integer NOT NULL,
 			 height integer NOT NULL,
@@ -35,6 +40,12 @@ var SCHEMAS = [...]string {
 			marked integer NOT NULL,
 			message text NOT NULL,
 			PRIMARY KEY(pk))`,
+	`spent (tx BLOB NOT NULL,
+			nout integer NOT NULL,
+			spent_tx BLOB NOT NULL,
+			nin integer NOT NULL,
+			height integer NOT NULL,
+			PRIMARY KEY(tx, nout))`,
 }
 
 var PRAGMAS = [...]string {	
@@ -85,6 +96,11 @@ func initDB(driverName string, dataSource string) (db *sqlx.DB, err error){
 		}
 	}
 
+	// Add columns missing in dbs created by previous versions
+	if err = migrateDB(db); err != nil {
+		return nil, err
+	}
+
 	// Load pragmas
 	for _, pragma := range PRAGMAS {
 		_, err := db.Exec(pragma)
@@ -113,6 +129,30 @@ func initDB(driverName string, dataSource string) (db *sqlx.DB, err error){
 	return db, nil
 }
 
+// migrateDB adds utxo height column to dbs created before it was introduced,
+// existing utxo are left with height 0 (unknown) as their block isn't stored.
+func migrateDB(db *sqlx.DB) error {
+	var columns []struct {
+		Cid     int            `db:"cid"`
+		Name    string         `db:"name"`
+		Type    string         `db:"type"`
+		NotNull int            `db:"notnull"`
+		Default sql.NullString `db:"dflt_value"`
+		Pk      int            `db:"pk"`
+	}
+	if err := db.Select(&columns, "PRAGMA table_info(utxo);"); err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if column.Name == "height" {
+			return nil
+		}
+	}
+
+	_, err := db.Exec("ALTER TABLE utxo ADD COLUMN height integer NOT NULL DEFAULT 0;")
+	return err
+}
+
 type SQLiteStorage struct {	
 	//
 	db *sqlx.DB
@@ -134,6 +174,9 @@ type SQLiteStorage struct {
 	// Get all the txout for a given address
 	getByAddressStmt *sqlx.Stmt
 
+	// Get all the txout for a given address with their height
+	getUtxosStmt *sqlx.Stmt
+
 	// Get accumulated address balance
 	getBalanceStmt *sqlx.Stmt
 
@@ -144,6 +187,10 @@ type SQLiteStorage struct {
 	// Dirty mark statements
 	getDirtyStmt *sqlx.Stmt
 	setDirtyStmt *sqlx.Stmt
+
+	// Spent outputs archive statements
+	getSpentStmt *sqlx.Stmt
+	setSpentStmt *sqlx.Stmt
 }
 
 
@@ -171,7 +218,7 @@ func NewSQLiteStorage(DBPath string) (*SQLiteStorage, error) {
 		return nil, err
 	}
 
-	store.getStmt, err = db.Preparex("SELECT addr, value FROM utxo WHERE tx=? AND nout=?;")
+	store.getStmt, err = db.Preparex("SELECT addr, value, height FROM utxo WHERE tx=? AND nout=?;")
 	if err != nil {
 		return nil, err
 	}
@@ -181,7 +228,7 @@ func NewSQLiteStorage(DBPath string) (*SQLiteStorage, error) {
 		return nil, err
 	}
 	
-	store.setStmt, err = db.Preparex("INSERT INTO utxo(tx, nout, addr, value) VALUES(?, ?, ?, ?);")
+	store.setStmt, err = db.Preparex("INSERT INTO utxo(tx, nout, addr, value, height) VALUES(?, ?, ?, ?, ?);")
 	if err != nil {
 		return nil, err
 	}
@@ -206,6 +253,11 @@ func NewSQLiteStorage(DBPath string) (*SQLiteStorage, error) {
 		return nil, err
 	}
 
+	store.getUtxosStmt, err = db.Preparex("SELECT tx, nout, addr, value, height FROM utxo WHERE addr=?;")
+	if err != nil {
+		return nil, err
+	}
+
 	store.getBalanceStmt, err = db.Preparex("SELECT coalesce(SUM(value), 0) FROM utxo WHERE addr=?;")
 	if err != nil {
 		return nil, err
@@ -221,6 +273,16 @@ func NewSQLiteStorage(DBPath string) (*SQLiteStorage, error) {
 		return nil, err
 	}
 
+	store.getSpentStmt, err = db.Preparex("SELECT spent_tx, nin, height FROM spent WHERE tx=? AND nout=?;")
+	if err != nil {
+		return nil, err
+	}
+
+	store.setSpentStmt, err = db.Preparex("INSERT OR REPLACE INTO spent(tx, nout, spent_tx, nin, height) VALUES(?, ?, ?, ?, ?);")
+	if err != nil {
+		return nil, err
+	}
+
 	// Before returning check database isn't dirty
 	if dirty, _, err := store.getDirty(); err != nil || dirty {
 		if dirty {
@@ -282,7 +344,7 @@ func (s *SQLiteStorage) Set(out primitives.TxOut) (err error) {
 	if s.dirty {
 		return ErrDirtyStorage
 	}
-	_, err = s.setStmt.Exec(out.TxHash[:], out.Nout, out.Addr, out.Value)
+	_, err = s.setStmt.Exec(out.TxHash[:], out.Nout, out.Addr, out.Value, 0)
 	if err == nil {
 		return
 	}
@@ -340,6 +402,29 @@ func (s *SQLiteStorage) GetByAddress(address string) (outs []primitives.TxOut, e
 	return txouts[:], nil
 }
 
+// GetUtxos returns address unspent txouts with the height of their block
+func (s *SQLiteStorage) GetUtxos(address string) (utxos map[TxOutId]TxOutData, err error) {
+	var outs []utxo
+	
+	if s.dirty {
+		return nil, ErrDirtyStorage
+	}
+	
+	err = s.getUtxosStmt.Select(&outs, address)
+	if err != nil {
+		return nil, err
+	}
+
+	utxos = make(map[TxOutId]TxOutData, len(outs))
+	for _, out := range outs {
+		var id TxOutId
+		copy(id.TxHash[:], out.TxHash)
+		id.Nout   = out.Nout
+		utxos[id] = TxOutData{Addr: out.Addr, Value: out.Value, Height: out.Height}
+	}
+	return utxos, nil
+}
+
 // GetBalance returns address balance
 func (s *SQLiteStorage) GetBalance(address string) (balance int64, err error) {
 	
@@ -359,6 +444,59 @@ func (s *SQLiteStorage) GetBalance(address string) (balance int64, err error) {
 	return balance, err
 }
 
+// GetBalances returns the balance of several addresses, the addresses are
+// queried in chunks of MaxBalanceQueryAddresses to stay within sqlite
+// variables limit.
+func (s *SQLiteStorage) GetBalances(addresses []string) (balances map[string]int64, err error) {
+
+	if s.dirty {
+		return nil, ErrDirtyStorage
+	}
+
+	balances = make(map[string]int64, len(addresses))
+	unique   := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		if _, ok := balances[addr]; !ok {
+			balances[addr] = 0
+			unique = append(unique, addr)
+		}
+	}
+
+	for start := 0; start < len(unique); start += MaxBalanceQueryAddresses {
+		end := start + MaxBalanceQueryAddresses
+		if end > len(unique) {
+			end = len(unique)
+		}
+
+		query, args, err := sqlx.In("SELECT addr, SUM(value) FROM utxo WHERE addr IN (?) GROUP BY addr;", unique[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := s.db.Queryx(s.db.Rebind(query), args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var addr string
+			var balance int64
+			if err := rows.Scan(&addr, &balance); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			balances[addr] = balance
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return balances, nil
+}
+
 // getDirty returns the state of the db dirty flag
 func (s *SQLiteStorage) getDirty() (isDirty bool, message string, err error) {
 	var marked int
@@ -504,7 +642,7 @@ func (s *SQLiteStorage) BulkUpdate(insert []primitives.TxOut, remove []TxOutId,
 
 		// Insert new utxo
 		for _, ins := range insert {
-			_, err = setStmt.Exec(ins.TxHash[:], ins.Nout, ins.Addr, ins.Value)
+			_, err = setStmt.Exec(ins.TxHash[:], ins.Nout, ins.Addr, ins.Value, 0)
 			if err != nil {	
 				if err.Error() == "Negative utxo" {
 					err = ErrNegativeUtxo
@@ -524,7 +662,7 @@ func (s *SQLiteStorage) BulkUpdate(insert []primitives.TxOut, remove []TxOutId,
 }
 
 // BulkUpdate Atomic bulk storage update, but directly from the maps used by cache
-func (s *SQLiteStorage) BulkUpdateFromMap(insert map[TxOutId]TxOutData, remove map[TxOutId]bool, height int64, hash chainhash.Hash) error {
+func (s *SQLiteStorage) BulkUpdateFromMap(insert map[TxOutId]TxOutData, remove map[TxOutId]bool, height int64, hash chainhash.Hash, hooks ...CommitHook) error {
 	if s.dirty {
 		return ErrDirtyStorage
 	}
@@ -547,7 +685,7 @@ func (s *SQLiteStorage) BulkUpdateFromMap(insert map[TxOutId]TxOutData, remove m
 
 		// Insert new utxo
 		for id, data := range insert {
-			if _, err := setStmt.Exec(id.TxHash[:], id.Nout, data.Addr, data.Value); err != nil {
+			if _, err := setStmt.Exec(id.TxHash[:], id.Nout, data.Addr, data.Value, data.Height); err != nil {
 				if err.Error() == "Negative utxo" {
 					err = ErrNegativeUtxo
 				} else if err.Error() == "Unexpendable utxo" {
@@ -557,12 +695,71 @@ func (s *SQLiteStorage) BulkUpdateFromMap(insert map[TxOutId]TxOutData, remove m
 			}
 		}
 
+		// Data derived from the same blocks
+		for _, hook := range hooks {
+			if err := hook(tx); err != nil {
+				return err
+			}
+		}
+
 		// Set lastblock
 		_, err := lastBlockStmt.Exec(height, hash[:])
 		return err
 	})
 }
 
+// Transact runs fn within a transaction
+func (s *SQLiteStorage) Transact(fn func(tx Tx) error) error {
+	if s.dirty {
+		return ErrDirtyStorage
+	}
+
+	return Transact(s.db, func(tx *sqlx.Tx) error {
+		return fn(tx)
+	})
+}
+
+// GetSpent returns the input spending an archived output
+func (s *SQLiteStorage) GetSpent(out TxOutId) (spent SpentData, ok bool, err error) {
+	if s.dirty {
+		return spent, false, ErrDirtyStorage
+	}
+
+	var spentTx []byte
+	err = s.getSpentStmt.QueryRowx(out.TxHash[:], out.Nout).Scan(&spentTx, &spent.Nin, &spent.Height)
+	switch {
+	case err == sql.ErrNoRows:
+		return spent, false, nil
+	case err != nil:
+		return spent, false, err
+	default:
+		copy(spent.TxHash[:], spentTx)
+		return spent, true, nil
+	}
+}
+
+// ArchiveSpent stores spent outputs, tx must be a transaction from this storage
+// (i.e. the one passed to a commit hook)
+func (s *SQLiteStorage) ArchiveSpent(tx Tx, spent map[TxOutId]SpentData) error {
+	if s.dirty {
+		return ErrDirtyStorage
+	}
+
+	sqlTx, ok := tx.(*sqlx.Tx)
+	if !ok {
+		return ErrForeignTx
+	}
+
+	setSpentStmt := sqlTx.Stmtx(s.setSpentStmt)
+	for id, data := range spent {
+		_, err := setSpentStmt.Exec(id.TxHash[:], id.Nout, data.TxHash[:], data.Nin, data.Height)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close DB
 func (s *SQLiteStorage) Close() error {
 	if s.db != nil {
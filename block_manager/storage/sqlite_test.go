@@ -5,6 +5,7 @@ import(
 	"fmt"
 	"io/ioutil"
 	"os"
+	"github.com/jmoiron/sqlx"
 	"github.com/secnot/gobalance/primitives"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
@@ -362,6 +363,46 @@ func TestSQLiteGetBalance(t *testing.T) {
 	}
 }
 
+// Test GetBalances method
+func TestSQLiteGetBalances(t *testing.T) {
+	
+	storage, err := NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Error("NewSQLiteStorage(): ", err)
+		return
+	}
+	
+	// More addresses than the max per query
+	storedOuts := mockTxOuts(0, 2*MaxBalanceQueryAddresses+10, 1, 0)
+	addresses  := make([]string, 0, len(storedOuts)+1)
+	for n := range storedOuts {
+		storedOuts[n].Addr  = fmt.Sprintf("address_%v", n/2)
+		storedOuts[n].Value = 1
+		addresses = append(addresses, storedOuts[n].Addr)
+	}
+	initStorage(t, storage, storedOuts)
+
+	addresses = append(addresses, "unknown_address")
+	balances, err := storage.GetBalances(addresses)
+	if err != nil {
+		t.Error("GetBalances(): ", err)
+		return
+	}
+
+	if len(balances) != len(storedOuts)/2+1 {
+		t.Errorf("GetBalances(): Expecting %v balances returned %v", len(storedOuts)/2+1, len(balances))
+	}
+	for addr, balance := range balances {
+		expected := int64(2)
+		if addr == "unknown_address" {
+			expected = 0
+		}
+		if balance != expected {
+			t.Errorf("GetBalances(): Expecting %v for %v returned %v", expected, addr, balance)
+		}
+	}
+}
+
 // Test GetByAddress method
 func TestSQLiteGetByAddress(t *testing.T) {	
 	
@@ -529,6 +570,143 @@ func TestSQLiteMarkDirtyPersist(t *testing.T) {
 	defer os.Remove(fmt.Sprintf("%s-journal", filename))
 }
 
+// Test utxo height column is added to databases created without it, with
+// existing utxo height unknown
+func TestSQLiteMigrateHeight(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "sqlite3_temp_db")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	filename := tmpfile.Name()
+	defer os.Remove(filename)
+	defer os.Remove(fmt.Sprintf("%s-journal", filename))
+
+	// Create a db with the previous schema
+	db, err := sqlx.Open("sqlite3", filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	db.MustExec(`CREATE TABLE utxo (tx BLOB NOT NULL, nout integer NOT NULL, 
+		addr text NOT NULL, value integer NOT NULL, PRIMARY KEY(tx, nout));`)
+	db.MustExec(`CREATE TABLE last_block (pk integer NOT NULL, height integer NOT NULL,
+		hash BLOB NOT NULL, PRIMARY KEY(pk));`)
+	db.MustExec("INSERT INTO utxo(tx, nout, addr, value) VALUES(?, 0, 'some_address', 10);", 
+		primitives.ZeroHash[:])
+	db.MustExec("INSERT INTO last_block(pk, height, hash) VALUES(1, 1234, ?);", primitives.ZeroHash[:])
+	db.Close()
+
+	storage, err := NewSQLiteStorage(filename)
+	if err != nil {
+		t.Error("NewSQLiteStorage(): ", err)
+		return
+	}
+	defer storage.Close()
+
+	data, err := storage.Get(TxOutId{TxHash: primitives.ZeroHash, Nout: 0})
+	if err != nil || data.Height != 0 || data.Value != 10 {
+		t.Errorf("Get(): Unexpected migrated utxo %v, %v", data, err)
+	}
+}
+
+// Test GetUtxos method
+func TestSQLiteGetUtxos(t *testing.T) {
+	storage, err := NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Error("NewSQLiteStorage(): ", err)
+		return
+	}
+
+	inserts := make(map[TxOutId]TxOutData)
+	for n, out := range mockTxOuts(0, 10, 1, 1) {
+		id := TxOutId{TxHash: *out.TxHash, Nout: out.Nout}
+		inserts[id] = TxOutData{Addr: "some_address", Value: out.Value, Height: int64(100+n)}
+	}
+	other := TxOutId{TxHash: mockHash(50), Nout: 0}
+	inserts[other] = TxOutData{Addr: "other_address", Value: 1, Height: 10}
+	if err := storage.BulkUpdateFromMap(inserts, nil, 200, primitives.ZeroHash); err != nil {
+		t.Error("BulkUpdateFromMap(): ", err)
+		return
+	}
+
+	utxos, err := storage.GetUtxos("some_address")
+	if err != nil {
+		t.Error("GetUtxos(): ", err)
+		return
+	}
+	if len(utxos) != 10 {
+		t.Errorf("GetUtxos(): Expecting 10 utxos returned %v", len(utxos))
+	}
+	for id, data := range utxos {
+		if inserts[id] != data {
+			t.Errorf("GetUtxos(): Expecting %v returned %v", inserts[id], data)
+		}
+	}
+
+	if utxos, _ := storage.GetUtxos("unknown_address"); len(utxos) != 0 {
+		t.Errorf("GetUtxos(): Unexpected utxos %v", utxos)
+	}
+}
+
+// Test commit hooks are committed or rolled back together with the utxo set
+func TestSQLiteCommitHooks(t *testing.T) {
+	storage, err := NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Error("NewSQLiteStorage(): ", err)
+		return
+	}
+
+	err = storage.Transact(func(tx Tx) error {
+		_, err := tx.Exec("CREATE TABLE IF NOT EXISTS hook_test (height integer NOT NULL);")
+		return err
+	})
+	if err != nil {
+		t.Error("Transact(): ", err)
+		return
+	}
+
+	hook := func(tx Tx) error {
+		_, err := tx.Exec("INSERT INTO hook_test(height) VALUES(10);")
+		return err
+	}
+	failingHook := func(tx Tx) error {
+		return ErrNegativeHeight
+	}
+
+	outs := mockTxOuts(0, 10, 1, 1)
+	inserts := make(map[TxOutId]TxOutData)
+	for _, out := range outs {
+		inserts[TxOutId{TxHash: *out.TxHash, Nout: out.Nout}] = TxOutData{Addr: out.Addr, Value: out.Value}
+	}
+
+	// Failed hook rolls back the whole update
+	if err := storage.BulkUpdateFromMap(inserts, nil, 10, primitives.ZeroHash, hook, failingHook); err != ErrNegativeHeight {
+		t.Errorf("BulkUpdateFromMap(): Expecting hook error returned %v", err)
+	}
+	if length, _ := storage.Len(); length != 0 {
+		t.Errorf("BulkUpdateFromMap(): Utxo inserted after failed hook")
+	}
+	if height, _, _ := storage.GetLastBlock(); height != -1 {
+		t.Errorf("BulkUpdateFromMap(): Last block updated after failed hook")
+	}
+
+	if err := storage.BulkUpdateFromMap(inserts, nil, 10, primitives.ZeroHash, hook); err != nil {
+		t.Error("BulkUpdateFromMap(): ", err)
+	}
+
+	var count int
+	err = storage.Transact(func(tx Tx) error {
+		return tx.Get(&count, "SELECT count(*) FROM hook_test;")
+	})
+	if err != nil || count != 1 {
+		t.Errorf("BulkUpdateFromMap(): Expecting 1 hook row returned %v, %v", count, err)
+	}
+	if length, _ := storage.Len(); length != len(outs) {
+		t.Errorf("BulkUpdateFromMap(): Expecting %v utxo returned %v", len(outs), length)
+	}
+}
+
 // Test BulkGet method
 func TestSQLiteBulkGet(t *testing.T) {
 	storage, err := NewSQLiteStorage(":memory:")
@@ -746,3 +924,44 @@ func TestSQLiteCleanUp(t *testing.T) {
 		return
 	}
 }
+
+// Test ArchiveSpent and GetSpent methods
+func TestSQLiteSpentArchive(t *testing.T) {
+	storage, err := NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Error("NewSQLiteStorage(): ", err)
+		return
+	}
+
+	spent := map[TxOutId]SpentData {
+		TxOutId{TxHash: mockHash(1), Nout: 0}: SpentData{TxHash: mockHash(2), Nin: 3, Height: 100},
+		TxOutId{TxHash: mockHash(1), Nout: 1}: SpentData{TxHash: mockHash(3), Nin: 0, Height: 101},
+	}
+	err = storage.Transact(func(tx Tx) error {
+		return storage.ArchiveSpent(tx, spent)
+	})
+	if err != nil {
+		t.Error("ArchiveSpent(): ", err)
+		return
+	}
+
+	for id, expected := range spent {
+		data, ok, err := storage.GetSpent(id)
+		if err != nil {
+			t.Error("GetSpent(): ", err)
+			continue
+		}
+		if !ok || data != expected {
+			t.Errorf("GetSpent(%v): returned %v %v expecting %v", id, data, ok, expected)
+		}
+	}
+
+	if _, ok, err := storage.GetSpent(TxOutId{TxHash: mockHash(1), Nout: 2}); ok || err != nil {
+		t.Errorf("GetSpent(): Unknown output returned %v %v", ok, err)
+	}
+
+	// Only transactions from the storage are accepted
+	if err := storage.ArchiveSpent(nil, spent); err != ErrForeignTx {
+		t.Errorf("ArchiveSpent(): Expecting ErrForeignTx returned %v", err)
+	}
+}
@@ -2,6 +2,9 @@ package storage
 
 import (
 	"errors"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
 	"github.com/secnot/gobalance/primitives"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
@@ -28,6 +31,9 @@ type TxOutData struct {
 
 	// Redeem address for the transaction output
 	Addr string
+
+	// Height of the block containing the output (0 when unknown)
+	Height int64
 }
 
 func NewTxOutData(address string, value int64) *TxOutData {
@@ -37,6 +43,31 @@ func NewTxOutData(address string, value int64) *TxOutData {
 	}
 }
 
+// SpentData identifies the transaction input spending an output
+type SpentData struct {
+	// Spending transaction hash
+	TxHash chainhash.Hash
+
+	// Input number within the spending transaction
+	Nin uint32
+
+	// Height of the block containing the spending transaction
+	Height int64
+}
+
+
+// Tx is the storage transaction passed to commit hooks and Transact
+type Tx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
+	QueryRowx(query string, args ...interface{}) *sqlx.Row
+	Select(dest interface{}, query string, args ...interface{}) error
+	Get(dest interface{}, query string, args ...interface{}) error
+}
+
+// CommitHook is called within the bulk update transaction, so the data
+// written by the hook is committed atomically with the utxo set.
+type CommitHook func(tx Tx) error
 
 // Memory and SQL storage interface:
 type Storage interface {
@@ -59,8 +90,15 @@ type Storage interface {
 	// Get all address utxout
 	GetByAddress(address string) (outs []primitives.TxOut, err error)
 
+	// Get all address utxout including the height of the block containing them
+	GetUtxos(address string) (utxos map[TxOutId]TxOutData, err error)
+
 	// Get address accumulated balance 
 	GetBalance(address string) (balance int64, err error)
+
+	// Get accumulated balance for several addresses in a single query, the
+	// map contains all the addresses (0 if not stored)
+	GetBalances(addresses []string) (balances map[string]int64, err error)
 	
 	// Remove utxo from storage, if it doesn't exist no error is returned.
 	Delete(out TxOutId) (err error)
@@ -74,8 +112,18 @@ type Storage interface {
 	// Atomic bulk utxo insertion and deletion
 	BulkUpdate(insert []primitives.TxOut, remove []TxOutId, height int64, hash chainhash.Hash) (err error)
 
-	// Same as Bulk update but using same maps as cache
-	BulkUpdateFromMap(insert map[TxOutId]TxOutData, remove map[TxOutId]bool, height int64, hash chainhash.Hash) error
+	// Same as Bulk update but using same maps as cache, hooks are called
+	// within the same transaction before last block is updated.
+	BulkUpdateFromMap(insert map[TxOutId]TxOutData, remove map[TxOutId]bool, height int64, hash chainhash.Hash, hooks ...CommitHook) error
+
+	// Run fn within a transaction, it's rolled back if fn returns an error
+	Transact(fn func(tx Tx) error) error
+
+	// Get the input spending an archived output, ok is false if not archived
+	GetSpent(out TxOutId) (spent SpentData, ok bool, err error)
+
+	// Archive spent outputs within a bulk update transaction (commit hook)
+	ArchiveSpent(tx Tx, spent map[TxOutId]SpentData) error
 
 	// Close storage
 	Close() error
@@ -94,4 +142,5 @@ var (
 	ErrUnexpendableUtxo = errors.New("Storage: unexpendable utxo")
 	ErrNegativeHeight   = errors.New("Storage: Negative height")
 	ErrDirtyStorage     = errors.New("Storage: Dirty storage")
+	ErrForeignTx        = errors.New("Storage: Transaction from another storage")
 )
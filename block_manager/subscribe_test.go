@@ -0,0 +1,62 @@
+package block_manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/secnot/gobalance/crawler"
+	"github.com/secnot/gobalance/events"
+)
+
+// Test the configured policy only applies to external subscribers
+func TestSubscribePolicy(t *testing.T) {
+	manager := &BlockManager{Confirmations: 6, SubscriberPolicy: events.DropPolicy}
+	updates := make(crawler.UpdateChan)
+	if err := manager.Start(context.Background(), newStorage(t, 1), updates); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Stop()
+	defer close(updates)
+
+	manager.Subscribe("internal", 1)
+	manager.SubscribeExternal("external", 1)
+
+	policies := make(map[string]events.Policy)
+	for _, stats := range manager.SubscriberStats() {
+		policies[stats.Name] = stats.Policy
+	}
+	if policies["internal"] != events.BlockPolicy {
+		t.Errorf("Subscribe(): Expecting block policy returned %v", policies["internal"])
+	}
+	if policies["external"] != events.DropPolicy {
+		t.Errorf("SubscribeExternal(): Expecting drop policy returned %v", policies["external"])
+	}
+}
+
+// Test cancelling the context stops the manager and closes subscriptions
+func TestStopContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	manager := &BlockManager{Confirmations: 6}
+	updates := make(crawler.UpdateChan)
+	if err := manager.Start(ctx, newStorage(t, 1), updates); err != nil {
+		t.Fatal(err)
+	}
+	defer close(updates)
+
+	ch := manager.Subscribe("internal", 1)
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("Subscribe(): Expecting channel closed")
+		}
+	case <-time.After(5*time.Second):
+		t.Fatal("Subscribe(): Timeout waiting for the manager to stop")
+	}
+
+	if _, err := manager.GetHeight(context.Background()); err != ErrStopped {
+		t.Errorf("GetHeight(): Expecting ErrStopped returned %v", err)
+	}
+	manager.Stop() // Already stopped
+}
@@ -36,6 +36,14 @@ func TestValidConfigFile(t *testing.T) {
 	if data["balance_cache_size"].(int64) != 111111 {
 		t.Errorf("balance_cache_size: Unexpected value")
 	}
+
+	if data["balance_cache_persist"].(bool) != false {
+		t.Errorf("balance_cache_persist: Unexpected value")
+	}
+
+	if data["balance_cache_save_period"].(int64) != 33 {
+		t.Errorf("balance_cache_save_period: Unexpected value")
+	}
 	
 	if data["recent_blocks"].(int64) != 22 {
 		t.Errorf("recent_blocks: Unexpected value")
@@ -48,6 +56,14 @@ func TestValidConfigFile(t *testing.T) {
 	if data["sync"].(bool) != true {
 		t.Errorf("sync: Unexpected value")
 	}	
+
+	if data["commit_on_stop"].(bool) != false {
+		t.Errorf("commit_on_stop: Unexpected value")
+	}
+
+	if data["sync_max_lag"].(int64) != 4 {
+		t.Errorf("sync_max_lag: Unexpected value")
+	}
 	
 	if data["mode"].(string) != "seed" {
 		t.Errorf("peers.mode: Unexpected value")
@@ -97,6 +113,55 @@ func TestValidConfigFile(t *testing.T) {
 		t.Errorf("peers.seeds: missing seed seed2.unknown.com")
 	}
 
+	// Test mempool option values
+	if data["mempool.enabled"].(bool) != true {
+		t.Errorf("mempool.enabled: Unexpected value")
+	}
+	if data["mempool.poll_period"].(int64) != 15 {
+		t.Errorf("mempool.poll_period: Unexpected value")
+	}
+
+	// Test commit option values
+	if data["commit.policy"].(string) != "time" {
+		t.Errorf("commit.policy: Unexpected value")
+	}
+	if data["commit.period"].(int64) != 300 {
+		t.Errorf("commit.period: Unexpected value")
+	}
+	if data["commit.max_memory"].(int64) != 512 {
+		t.Errorf("commit.max_memory: Unexpected value")
+	}
+
+	// Test events option values
+	if data["events.buffer_size"].(int64) != 500 {
+		t.Errorf("events.buffer_size: Unexpected value")
+	}
+	if data["events.slow_consumer_policy"].(string) != "drop" {
+		t.Errorf("events.slow_consumer_policy: Unexpected value")
+	}
+
+	// Test websocket option values
+	if data["websocket.enabled"].(bool) != false {
+		t.Errorf("websocket.enabled: Unexpected value")
+	}
+	if data["websocket.max_connections"].(int64) != 20 {
+		t.Errorf("websocket.max_connections: Unexpected value")
+	}
+	if data["websocket.max_addresses"].(int64) != 50 {
+		t.Errorf("websocket.max_addresses: Unexpected value")
+	}
+
+	// Test history option values
+	if data["history.enabled"].(bool) != true {
+		t.Errorf("history.enabled: Unexpected value")
+	}
+	if data["history.address_tx"].(bool) != true {
+		t.Errorf("history.address_tx: Unexpected value")
+	}
+	if data["history.spent_archive"].(bool) != true {
+		t.Errorf("history.spent_archive: Unexpected value")
+	}
+
 	// Test bitcoind option values
 	if data["bitcoind.host"].(string) != "localhost:8000" {
 		t.Errorf("bitcoind.host: Unexpected value")
@@ -110,6 +175,15 @@ func TestValidConfigFile(t *testing.T) {
 	if data["bitcoind.chain"].(string) != "testnet3" {
 		t.Errorf("bitcoind.chain: Unexpected value")
 	}
+	if len(data["bitcoind.hosts"].([]interface{})) != 2 {
+		t.Errorf("bitcoind.hosts: Unexpected value")
+	}
+	if data["bitcoind.balancing"].(string) != "roundrobin" {
+		t.Errorf("bitcoind.balancing: Unexpected value")
+	}
+	if data["bitcoind.health_check_period"].(int64) != 30 {
+		t.Errorf("bitcoind.health_check_period: Unexpected value")
+	}
 }
 
 
@@ -130,6 +204,14 @@ func TestDefaultValuesConfigFile(t *testing.T) {
 	if data["balance_cache_size"].(int64) != DefaultBalanceCacheSize {
 		t.Errorf("balance_cache_size: Unexpected default value")
 	}
+
+	if data["balance_cache_persist"].(bool) != DefaultBalanceCachePersist {
+		t.Errorf("balance_cache_persist: Unexpected default value")
+	}
+
+	if data["balance_cache_save_period"].(int64) != DefaultBalanceCacheSavePeriod {
+		t.Errorf("balance_cache_save_period: Unexpected default value")
+	}
 	
 	if data["recent_blocks"].(int64) != DefaultRecentBlocks {
 		t.Errorf("recent_blocks: Unexpected default value")
@@ -142,6 +224,14 @@ func TestDefaultValuesConfigFile(t *testing.T) {
 	if data["sync"].(bool) != false {
 		t.Errorf("sync: Unexpected default value")
 	}	
+
+	if data["commit_on_stop"].(bool) != DefaultCommitOnStop {
+		t.Errorf("commit_on_stop: Unexpected default value")
+	}
+
+	if data["sync_max_lag"].(int64) != DefaultSyncMaxLag {
+		t.Errorf("sync_max_lag: Unexpected default value")
+	}
 	
 	if data["mode"].(string) != DefaultMode {
 		t.Errorf("peers.mode: Unexpected default value")
@@ -175,6 +265,55 @@ func TestDefaultValuesConfigFile(t *testing.T) {
 		t.Errorf("peers.seeds: Unexpected default value")
 	}
 
+	// Test mempool option values
+	if data["mempool.enabled"].(bool) != DefaultMempoolEnabled {
+		t.Errorf("mempool.enabled: Unexpected default value")
+	}
+	if data["mempool.poll_period"].(int64) != DefaultMempoolPollPeriod {
+		t.Errorf("mempool.poll_period: Unexpected default value")
+	}
+
+	// Test commit option values
+	if data["commit.policy"].(string) != DefaultCommitPolicy {
+		t.Errorf("commit.policy: Unexpected default value")
+	}
+	if data["commit.period"].(int64) != DefaultCommitPeriod {
+		t.Errorf("commit.period: Unexpected default value")
+	}
+	if data["commit.max_memory"].(int64) != DefaultCommitMaxMemory {
+		t.Errorf("commit.max_memory: Unexpected default value")
+	}
+
+	// Test events option values
+	if data["events.buffer_size"].(int64) != DefaultEventsBufferSize {
+		t.Errorf("events.buffer_size: Unexpected default value")
+	}
+	if data["events.slow_consumer_policy"].(string) != DefaultEventsSlowConsumerPolicy {
+		t.Errorf("events.slow_consumer_policy: Unexpected default value")
+	}
+
+	// Test websocket option values
+	if data["websocket.enabled"].(bool) != DefaultWebsocketEnabled {
+		t.Errorf("websocket.enabled: Unexpected default value")
+	}
+	if data["websocket.max_connections"].(int64) != DefaultWebsocketMaxConnections {
+		t.Errorf("websocket.max_connections: Unexpected default value")
+	}
+	if data["websocket.max_addresses"].(int64) != DefaultWebsocketMaxAddresses {
+		t.Errorf("websocket.max_addresses: Unexpected default value")
+	}
+
+	// Test history option values
+	if data["history.enabled"].(bool) != DefaultHistoryEnabled {
+		t.Errorf("history.enabled: Unexpected default value")
+	}
+	if data["history.address_tx"].(bool) != DefaultHistoryAddressTx {
+		t.Errorf("history.address_tx: Unexpected default value")
+	}
+	if data["history.spent_archive"].(bool) != DefaultHistorySpentArchive {
+		t.Errorf("history.spent_archive: Unexpected default value")
+	}
+
 	// Test bitcoind option values
 	if data["bitcoind.host"].(string) != DefaultBitcoindHost {
 		t.Errorf("bitcoind.host: Unexpected default value")
@@ -188,6 +327,15 @@ func TestDefaultValuesConfigFile(t *testing.T) {
 	if data["bitcoind.chain"] != DefaultBitcoindMainnet {
 		t.Errorf("bitcoind.chain: Unexpected default value")
 	}
+	if len(data["bitcoind.hosts"].([]interface{})) != 0 {
+		t.Errorf("bitcoind.hosts: Unexpected default value")
+	}
+	if data["bitcoind.balancing"] != DefaultBitcoindBalancing {
+		t.Errorf("bitcoind.balancing: Unexpected default value")
+	}
+	if data["bitcoind.health_check_period"].(int64) != DefaultBitcoindHealthCheckPeriod {
+		t.Errorf("bitcoind.health_check_period: Unexpected default value")
+	}
 }
 
 // Test ReadConfig returns an error when reading config file containing unsupported options
@@ -5,6 +5,8 @@ const (
 	DefaultBitcoindHost     = "localhost:8332"
 	DefaultBitcoindMainnet  = "mainnet"
 	DefaultBitcoindTestnet3 = "testnet3"
+	DefaultBitcoindBalancing         = "failover"
+	DefaultBitcoindHealthCheckPeriod = int64(10)
 
 	// API
 	DefaultApiUrlPrefix = "/"
@@ -18,16 +20,47 @@ const (
 	DefaultPeersUnreachablePeriod = int64(5)
 
 
+	// Mempool
+	DefaultMempoolEnabled    = false
+	DefaultMempoolPollPeriod = int64(5)
+
+	// Commit
+	DefaultCommitPolicy    = "default"
+	DefaultCommitPeriod    = int64(600)
+	DefaultCommitMaxMemory = int64(2048)
+
+	// Events
+	DefaultEventsBufferSize         = int64(1000)
+	DefaultEventsSlowConsumerPolicy = "block"
+
+	// WebSocket
+	DefaultWebsocketEnabled        = true
+	DefaultWebsocketMaxConnections = int64(1000)
+	DefaultWebsocketMaxAddresses   = int64(1000)
+
+	// History
+	DefaultHistoryEnabled   = false
+	DefaultHistoryAddressTx = false
+	DefaultHistorySpentArchive = false
+
 	//
 	DefaultRecentBlocks     = int64(20)
 	DefaultBalanceCacheSize = int64(100000)
+	DefaultBalanceCachePersist    = true
+	DefaultBalanceCacheSavePeriod = int64(600)
 	DefaultUtxoCacheSize    = int64(200000)
 	DefaultSync				= false
+	DefaultCommitOnStop     = true
+	DefaultSyncMaxLag       = int64(1)
 	DefaultMode             = "full"
 )
 
 var DefaultPeersSeeds  = [...]interface{} {}
+var DefaultBitcoindHosts = [...]interface{} {}
 var AllowedPeerModes = [...]string {"full", "seed", "loadbalance"}
+var AllowedBitcoindBalancing = [...]string {"failover", "roundrobin"}
+var AllowedEventsPolicies    = [...]string {"block", "drop", "disconnect"}
+var AllowedCommitPolicies    = [...]string {"default", "time", "memory", "peer"}
 
 
 type Option struct {
@@ -50,6 +83,21 @@ var Options = [] Option {
 		def:  DefaultBitcoindHost,
 	},
 
+	{	name: "bitcoind.hosts",
+		val:  SliceElemValidator(StringValidator()),
+		def:  DefaultBitcoindHosts[:],
+	},
+
+	{	name: "bitcoind.balancing",
+		val:  StringChoiceValidator(AllowedBitcoindBalancing[:]...),
+		def:  DefaultBitcoindBalancing,
+	},
+
+	{	name: "bitcoind.health_check_period",
+		val:  IntegerMinMaxValidator(1, 3600),
+		def:  DefaultBitcoindHealthCheckPeriod,
+	},
+
 	{	name: "bitcoind.user",
 		val: StringValidator(),
 		def:  "",
@@ -107,6 +155,76 @@ var Options = [] Option {
 		def:  DefaultPeersSeeds[:],
 	},
 
+	// Mempool
+	{	name: "mempool.enabled",
+		val:  BoolValidator(),
+		def:  DefaultMempoolEnabled,
+	},
+
+	{	name: "mempool.poll_period",
+		val:  IntegerMinMaxValidator(1, 3600),
+		def:  DefaultMempoolPollPeriod,
+	},
+
+	// Commit
+	{	name: "commit.policy",
+		val:  StringChoiceValidator(AllowedCommitPolicies[:]...),
+		def:  DefaultCommitPolicy,
+	},
+
+	{	name: "commit.period",
+		val:  IntegerMinValidator(1),
+		def:  DefaultCommitPeriod,
+	},
+
+	{	name: "commit.max_memory",
+		val:  IntegerMinValidator(1),
+		def:  DefaultCommitMaxMemory,
+	},
+
+	// Events
+	{	name: "events.buffer_size",
+		val:  IntegerMinValidator(1),
+		def:  DefaultEventsBufferSize,
+	},
+
+	{	name: "events.slow_consumer_policy",
+		val:  StringChoiceValidator(AllowedEventsPolicies[:]...),
+		def:  DefaultEventsSlowConsumerPolicy,
+	},
+
+	// WebSocket
+	{	name: "websocket.enabled",
+		val:  BoolValidator(),
+		def:  DefaultWebsocketEnabled,
+	},
+
+	{	name: "websocket.max_connections",
+		val:  IntegerMinMaxValidator(1, 100000),
+		def:  DefaultWebsocketMaxConnections,
+	},
+
+	{	name: "websocket.max_addresses",
+		val:  IntegerMinMaxValidator(1, 100000),
+		def:  DefaultWebsocketMaxAddresses,
+	},
+
+	// History
+	{	name: "history.enabled",
+		val:  BoolValidator(),
+		def:  DefaultHistoryEnabled,
+	},
+
+	{	name: "history.address_tx",
+		val:  BoolValidator(),
+		def:  DefaultHistoryAddressTx,
+	},
+
+	{	name: "history.spent_archive",
+		val:  BoolValidator(),
+		def:  DefaultHistorySpentArchive,
+	},
+
 	// Base
 	{	name: "workdir",
 		val:  StringValidator(),
@@ -127,6 +245,16 @@ var Options = [] Option {
 		val:  IntegerMinValidator(1),
 		def:  DefaultBalanceCacheSize,
 	},
+
+	{	name: "balance_cache_persist",
+		val:  BoolValidator(),
+		def:  DefaultBalanceCachePersist,
+	},
+
+	{	name: "balance_cache_save_period",
+		val:  IntegerMinValidator(0),
+		def:  DefaultBalanceCacheSavePeriod,
+	},
 	
 	{   name: "mode",
 		val:  StringChoiceValidator(AllowedPeerModes[:]...),
@@ -137,5 +265,15 @@ var Options = [] Option {
 		val:  BoolValidator(),
 		def:  DefaultSync,
 	},
+
+	{	name: "commit_on_stop",
+		val:  BoolValidator(),
+		def:  DefaultCommitOnStop,
+	},
+
+	{	name: "sync_max_lag",
+		val:  IntegerMinValidator(0),
+		def:  DefaultSyncMaxLag,
+	},
 }
 
@@ -3,11 +3,13 @@ package crawler
 
 import (
 	"log"
+	"context"
 
 	"github.com/btcsuite/btcd/wire"
-	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	
+	"github.com/secnot/gobalance/bitcoind"
+	"github.com/secnot/gobalance/events"
 	"github.com/secnot/gobalance/primitives/queue"
 )
 
@@ -26,6 +28,9 @@ type UpdateClass int
 const (
 	OP_NEWBLOCK  UpdateClass = iota
 	OP_BACKTRACK
+
+	// Signal a new best chain tip reported by bitcoind (no block included)
+	OP_TIP
 )
 
 // Struct used to send chain updates to subscribers
@@ -34,6 +39,9 @@ type BlockUpdate struct {
 	Block  *wire.MsgBlock
 	Hash   *chainhash.Hash
 	Height uint64
+
+	// Height of the best chain tip reported by bitcoind
+	TipHeight uint64
 }
 
 //
@@ -58,55 +66,55 @@ type Crawler struct {
 	fetcherBlocks chan blockRecord
 
 	// Block updates subscribers 
-	subscribers map[UpdateChan]bool
+	bus *events.Bus
 
 	// Height for the next block to retrieve
 	height uint64
 
-	// Configuration for bitcoind RPC server
-	rpcConfig rpcclient.ConnConfig
+	// Last chain tip height reported by fetcher
+	tipHeight uint64
+
+	// bitcoind RPC servers
+	pool *bitcoind.Pool
 
 	// Hashes for the last n unconfirmed blocks
 	blockQueue *queue.Queue
 	
 	// Crawler interface channels
 	//////////////////////////////
-	// New subscription channel
-	subscribeChan   chan UpdateChan
-
-	// Unsubscribe existing subscription channel
-	unsubscribeChan chan UpdateChan
-
 	// Signal crawler to start fetching.
 	startChan       chan chan bool
 
-	// Signal crawler to stop fetching and exit.
-	stopChan        chan chan bool
+	// Cancels the crawler context to stop fetching and exit.
+	cancel          context.CancelFunc
+
+	// Closed once crawler routine has exited
+	done            chan bool
 }
 
-// NewCrawler creates a new crawler
-func NewCrawler(config rpcclient.ConnConfig, startHeight uint64, prevBlockHash chainhash.Hash) (*Crawler, error) {
+// NewCrawler creates a new crawler fetching blocks from the pool backends, it
+// exits when ctx is cancelled or Stop is called.
+func NewCrawler(ctx context.Context, pool *bitcoind.Pool, startHeight uint64, prevBlockHash chainhash.Hash) (*Crawler, error) {
 
 	blockQueue := queue.New()
 	blockQueue.PushBack(prevBlockHash)
-
+	ctx, cancel := context.WithCancel(ctx)
 
 	craw := &Crawler{
 		fetcherStop:   nil,
 		fetcherBlocks: nil,
-		rpcConfig:     config,
+		pool:          pool,
 		height:        startHeight,
-		subscribers:   make(map [UpdateChan]bool),
+		bus:           events.NewBus(),
 		blockQueue:    blockQueue,
 
 		//
-		subscribeChan:   make(chan UpdateChan),
-		unsubscribeChan: make(chan UpdateChan),
 		startChan:       make(chan chan bool),
-		stopChan:        make(chan chan bool),
+		cancel:          cancel,
+		done:            make(chan bool),
 	}
 
-	go craw.crawlerRoutine()
+	go craw.crawlerRoutine(ctx)
 
 	return craw, nil
 }
@@ -161,50 +169,53 @@ func (c *Crawler) processBlock(block *wire.MsgBlock, blockHash *chainhash.Hash)
 func (c *Crawler) newFetcher(height uint64) {
 	
 	// Stop previous fetcher
-	if c.fetcherStop != nil {
-		c.fetcherStop <- true
-	}
+	c.stopFetcher()
 	
 	// Both channels to be closed by fetcher task
 	c.fetcherStop   = make(chan bool)
 	c.fetcherBlocks = make(chan blockRecord, FetcherBlockBufferSize)
 
 	//
-	go fetcher(c.rpcConfig, height, c.fetcherBlocks, c.fetcherStop)
+	go fetcher(c.pool, height, c.fetcherBlocks, c.fetcherStop)
 }
 
-// notifySubscribers sends a block update to all the subscribers
-func (c *Crawler) notifySubscribers(update BlockUpdate) {
-	for subscriber, _ := range c.subscribers {
-		subscriber <- update
+// stopFetcher stops current fetcher routine and discards the blocks it
+// already buffered, they will be fetched again on restart.
+func (c *Crawler) stopFetcher() {
+	if c.fetcherStop == nil {
+		return
 	}
-}
 
-// subscribe adds a subscriber to crawler block updates
-func (c *Crawler) addSubscriber(subscriber UpdateChan) {
-	c.subscribers[subscriber] = true
+	// The fetcher can be blocked waiting for buffer space, drain the buffer
+	// until it acknowledges the signal by closing it.
+	stop := c.fetcherStop
+	for stop != nil {
+		select {
+		case stop <- true:
+			stop = nil
+		case <-c.fetcherBlocks:
+		}
+	}
+	for range c.fetcherBlocks {
+	}
+
+	c.fetcherStop   = nil
+	c.fetcherBlocks = nil
 }
 
-// unsubscribe removes a subscriber from crawler block updates
-func (c *Crawler) delSubscriber(subscriber UpdateChan) {
-	delete(c.subscribers, subscriber)
+// notifySubscribers sends a block update to all the subscribers
+func (c *Crawler) notifySubscribers(update BlockUpdate) {
+	update.TipHeight = c.tipHeight
+	c.bus.Publish(update)
 }
 
 // Crawler routine
-func (c *Crawler) crawlerRoutine() {
+func (c *Crawler) crawlerRoutine(ctx context.Context) {
 
 	// Accept subscriptions and wait until the start signal is received
-	// Fetch blocks until the stop signal is received
+	// Fetch blocks until the context is cancelled
 	for {
 		select {		
-			// Subscription request
-			case subscriber := <-c.subscribeChan:
-				c.addSubscriber(subscriber)
-
-			// Unsusbription request
-			case subscriber := <-c.unsubscribeChan:
-				c.delSubscriber(subscriber)
-
 			// Start crawler
 			case ch := <-c.startChan:
 				if c.fetcherBlocks == nil {
@@ -213,44 +224,68 @@ func (c *Crawler) crawlerRoutine() {
 				ch <- true // Signal started
 
 			// Stop crawler and exit
-			case ch := <-c.stopChan:
-				// TODO: Force commit to db, close fetcher, close channels, etc...
-				ch <- true	// signal stopped
-				break
+			case <-ctx.Done():
+				c.stopFetcher()
+
+				// Signal subscribers there won't be more updates
+				c.bus.Close()
+				close(c.done)
+				return
 
-			// New block available
+			// New block or chain tip available
 			case record := <-c.fetcherBlocks:
-				c.processBlock(record.Block, record.BlockHash)
+				c.tipHeight = record.TipHeight
+				if record.Block == nil {
+					c.notifySubscribers(NewBlockUpdate(OP_TIP, nil, nil, record.TipHeight))
+				} else {
+					c.processBlock(record.Block, record.BlockHash)
+				}
 		}
 	}
 }
 
-// Subscribe to crawler helper that returns channel where updates are sent
+// Subscribe to crawler helper that returns channel where updates are sent,
+// blocks aren't dropped so the crawler waits when the subscriber falls more 
+// than chanSize updates behind.
 func (c *Crawler) Subscribe(chanSize uint) UpdateChan {
 	ch := make(UpdateChan, int(chanSize))
-	c.subscribeChan <- ch
+	
+	deliver := func(event interface{}, quit chan bool) bool {
+		select {
+		case ch <- event.(BlockUpdate):
+			return true
+		case <-quit:
+			return false
+		}
+	}
+	c.bus.Subscribe(ch, "crawler", int(chanSize), events.BlockPolicy, 
+		deliver, func() { close(ch) })
 	return ch
 }
 
-// Unsubscribe from crawler
+// Unsubscribe from crawler, pending updates are discarded.
 func (c *Crawler) Unsubscribe(ch UpdateChan) {
-	c.unsubscribeChan <- ch
+	c.bus.Unsubscribe(ch)
 }
 
 // Start starts crawler crawling :), 
 func (c *Crawler) Start() {
 	ch := make(chan bool)
-	c.startChan <- ch
+	select {
+	case c.startChan <- ch:
+	case <-c.done:
+		return // Already stopped
+	}
 
 	// Wait until it has started
 	<- ch
 }
 
-// Stop crawler blocks until successfull exit
+// Stop crawler and fetcher routines, subscriber channels are closed once all
+// the updates have been sent. Blocks until successfull exit
 func (c *Crawler) Stop() {	
-	ch := make(chan bool)
-	c.stopChan <- ch
+	c.cancel()
 
 	// Wait until it has stopped
-	<- ch
+	<- c.done
 }
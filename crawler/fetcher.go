@@ -5,15 +5,13 @@ import (
 	"time"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
-	"github.com/btcsuite/btcd/rpcclient"
+
+	"github.com/secnot/gobalance/bitcoind"
 )
 
 const (
 	// Delay between failed requests retries (in milliseconds)
 	RPCRetryDelay = 4000
-
-	// RPC reconnect delay (in milliseconds)
-	RPCReconnectDelay = 30000
 )
 
 type blockRecord struct {
@@ -26,54 +24,63 @@ type blockRecord struct {
 	
 	// Block height for the block at retrieval time.
 	Height uint64
+
+	// Height of the best chain tip at retrieval time, records with a nil
+	// Block only report a new tip.
+	TipHeight uint64
 }
 
-//
-func fetcher(config rpcclient.ConnConfig, height uint64, buffer chan blockRecord, stop chan bool) {
+// fetcher retrieves blocks from the backend pool starting at height, only
+// blocks all the healthy backends agree on are sent to the buffer.
+func fetcher(pool *bitcoind.Pool, height uint64, buffer chan blockRecord, stop chan bool) {
 
-	var client *rpcclient.Client
-	var err error
 	var topHeight uint64 = 0 // Height for the last block in the chain
 	
-	// Connect to rpc service
-	for {
-		// The notification parameter is nil since notifications are
-		// not supported in HTTP POST mode.
-		client, err = rpcclient.New(&config, nil)
-		if err == nil {
-			break
-		}
-		
-		time.Sleep(RPCReconnectDelay*time.Millisecond)
-	}
-
 	// Main fetching loop
 	retries := 0	// failed requests retries
 	for {
 		if retries > 0 {
-			time.Sleep(RPCRetryDelay*time.Millisecond)
+			select {
+			case <- stop:
+				close(buffer)
+				close(stop)
+				return
+			case <- time.After(RPCRetryDelay*time.Millisecond):
+			}
 		}
 
 		// If the top of the blockchain has been reached wait until there
 		// is a new block available.
 		if topHeight < height {
-			blockCount, err := client.GetBlockCount()
+			blockCount, err := pool.BlockCount()
 			if err != nil || uint64(blockCount) <= topHeight {
 				retries++
 				continue // Wait and retry
 			}
 			topHeight = uint64(blockCount)
 			retries = 0
+
+			// Report new tip
+			select {
+			case <- stop:
+				close(buffer)
+				close(stop)
+				return
+			case buffer <- blockRecord{TipHeight: topHeight}:
+			}
 		}
 
 		// Read next block	
-		blockHash, err := client.GetBlockHash(int64(height))
+		blockHash, err := pool.BlockHash(int64(height))
 		if err != nil {
+			if err == bitcoind.ErrBackendsDisagree {
+				log.Print("Fetcher: BlockHash ", err)
+			}
 			retries++
 			continue // Wait and retry
 		}
 
-		block, err := client.GetBlock(blockHash)
+		block, err := pool.Block(blockHash)
 		if err != nil {
 			log.Print("Fetcher: GetBlock ", err)
 			retries++
@@ -85,6 +92,7 @@ func fetcher(config rpcclient.ConnConfig, height uint64, buffer chan blockRecord
 			BlockHash: blockHash, 
 			Height: height, 
 			Block: block,
+			TipHeight: topHeight,
 		}
 
 		select {
@@ -92,7 +100,6 @@ func fetcher(config rpcclient.ConnConfig, height uint64, buffer chan blockRecord
 			// Close and exit
 			close(buffer)
 			close(stop)
-			client.Shutdown()
 			return
 
 		case buffer <- record:
@@ -102,5 +109,3 @@ func fetcher(config rpcclient.ConnConfig, height uint64, buffer chan blockRecord
 		}
 	}
 }
-
-
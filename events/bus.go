@@ -0,0 +1,333 @@
+/*
+events implements a publish/subscribe bus where each subscriber has its own
+buffer and delivery routine, so a slow consumer only affects the publisher
+when its policy says so.
+*/
+package events
+
+import (
+	"log"
+	"sync"
+	"errors"
+
+	"github.com/secnot/gobalance/primitives/queue"
+)
+
+// Policy applied when a subscriber buffer is full
+type Policy string
+
+const (
+	// Publisher waits until there is room in the subscriber buffer
+	BlockPolicy      Policy = "block"
+
+	// The oldest buffered event is discarded to make room for the new one
+	DropPolicy       Policy = "drop"
+
+	// Subscriber is removed and its channel closed
+	DisconnectPolicy Policy = "disconnect"
+)
+
+const (
+	// Default number of events buffered for each subscriber
+	DefaultBufferSize = 1000
+)
+
+var ErrUnknownPolicy = errors.New("events: Unknown slow consumer policy")
+
+// DeliverFunc sends an event to the subscriber typed channel, it must return
+// false without sending if quit is closed first.
+type DeliverFunc func(event interface{}, quit chan bool) bool
+
+// Stats for a single subscriber
+type Stats struct {
+	Name   string
+	Policy Policy
+
+	// Events waiting in the buffer (subscriber lag)
+	Pending int
+
+	// Max pending events since subscription
+	MaxPending int
+
+	// Events delivered and dropped
+	Delivered uint64
+	Dropped   uint64
+}
+
+type subscriber struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	name       string
+	policy     Policy
+	bufferSize int
+
+	// Events not yet delivered
+	buffer *queue.Queue
+
+	deliver DeliverFunc
+	closeCh func()
+
+	// Closed to interrupt a blocked delivery
+	quit chan bool
+
+	// Deliver remaining events and close the channel
+	closing bool
+
+	// Stop delivering, the channel is closed if disconnected is set
+	removed      bool
+	disconnected bool
+
+	// Metrics
+	maxPending int
+	delivered  uint64
+	dropped    uint64
+}
+
+// deliveryRoutine sends buffered events to the subscriber in order
+func (s *subscriber) deliveryRoutine() {
+	for {
+		s.Lock()
+		for s.buffer.Len() == 0 && !s.closing && !s.removed {
+			s.cond.Wait()
+		}
+
+		if s.removed || s.buffer.Len() == 0 {
+			closeCh := !s.removed || s.disconnected
+			s.Unlock()
+			if closeCh {
+				s.closeCh()
+			}
+			return
+		}
+
+		event := s.buffer.PopFront()
+		s.cond.Broadcast() // There is room for blocked publishers
+		s.Unlock()
+
+		if !s.deliver(event, s.quit) {
+			continue // Interrupted, exit on next iteration
+		}
+
+		s.Lock()
+		s.delivered += 1
+		s.Unlock()
+	}
+}
+
+// push adds an event to the buffer applying the subscriber policy when full,
+// returns false if the subscriber must be disconnected.
+func (s *subscriber) push(event interface{}) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	for !s.removed && s.buffer.Len() >= s.bufferSize {
+		switch s.policy {
+		case DropPolicy:
+			s.buffer.PopFront()
+			s.dropped += 1
+		case DisconnectPolicy:
+			return false
+		default:
+			s.cond.Wait()
+		}
+	}
+
+	if s.removed {
+		return true
+	}
+
+	s.buffer.PushBack(event)
+	if s.buffer.Len() > s.maxPending {
+		s.maxPending = s.buffer.Len()
+	}
+	s.cond.Broadcast()
+	return true
+}
+
+// remove stops delivery discarding pending events, the channel is closed
+// when disconnect is true.
+func (s *subscriber) remove(disconnect bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.removed {
+		return
+	}
+	s.removed      = true
+	s.disconnected = disconnect
+	close(s.quit)
+	s.cond.Broadcast()
+}
+
+// finish closes the channel once all pending events have been delivered
+func (s *subscriber) finish() {
+	s.Lock()
+	s.closing = true
+	s.cond.Broadcast()
+	s.Unlock()
+}
+
+func (s *subscriber) stats() Stats {
+	s.Lock()
+	defer s.Unlock()
+	return Stats {
+		Name:       s.name,
+		Policy:     s.policy,
+		Pending:    s.buffer.Len(),
+		MaxPending: s.maxPending,
+		Delivered:  s.delivered,
+		Dropped:    s.dropped,
+	}
+}
+
+
+// Bus delivers published events to all its subscribers
+type Bus struct {
+	sync.Mutex
+
+	// Subscribers by key (usually their channel)
+	subscribers map[interface{}]*subscriber
+
+	// Subscription order
+	order []interface{}
+
+	closed bool
+}
+
+// NewBus initializes an empty bus
+func NewBus() *Bus {
+	return &Bus {
+		subscribers: make(map[interface{}]*subscriber),
+		order:       make([]interface{}, 0),
+	}
+}
+
+// ValidPolicy returns true for known policies
+func ValidPolicy(policy Policy) bool {
+	return policy == BlockPolicy || policy == DropPolicy || policy == DisconnectPolicy
+}
+
+// Subscribe adds a subscriber identified by key, deliver is used to send
+// events to its channel and closeCh to close it.
+func (b *Bus) Subscribe(key interface{}, name string, bufferSize int, policy Policy,
+						deliver DeliverFunc, closeCh func()) error {
+
+	if !ValidPolicy(policy) {
+		return ErrUnknownPolicy
+	}
+	if bufferSize < 1 {
+		bufferSize = DefaultBufferSize
+	}
+
+	s := &subscriber {
+		name:       name,
+		policy:     policy,
+		bufferSize: bufferSize,
+		buffer:     queue.New(),
+		deliver:    deliver,
+		closeCh:    closeCh,
+		quit:       make(chan bool),
+	}
+	s.cond = sync.NewCond(s)
+
+	b.Lock()
+	defer b.Unlock()
+	if _, ok := b.subscribers[key]; ok {
+		return nil
+	}
+	if b.closed {
+		s.closing = true
+	}
+	b.subscribers[key] = s
+	b.order = append(b.order, key)
+
+	go s.deliveryRoutine()
+	return nil
+}
+
+// detach removes subscriber from the bus, must be called with the lock held
+func (b *Bus) detach(key interface{}) *subscriber {
+	s, ok := b.subscribers[key]
+	if !ok {
+		return nil
+	}
+	delete(b.subscribers, key)
+	for n, k := range b.order {
+		if k == key {
+			b.order = append(b.order[:n], b.order[n+1:]...)
+			break
+		}
+	}
+	return s
+}
+
+// Unsubscribe removes a subscriber, pending events are discarded and its
+// channel is left open.
+func (b *Bus) Unsubscribe(key interface{}) {
+	b.Lock()
+	s := b.detach(key)
+	b.Unlock()
+
+	if s != nil {
+		s.remove(false)
+	}
+}
+
+// Publish sends an event to all the subscribers
+func (b *Bus) Publish(event interface{}) {
+	b.Lock()
+	if b.closed {
+		b.Unlock()
+		return
+	}
+	keys := make([]interface{}, len(b.order))
+	subs := make([]*subscriber, len(b.order))
+	for n, key := range b.order {
+		keys[n] = key
+		subs[n] = b.subscribers[key]
+	}
+	b.Unlock()
+
+	// The lock isn't held while pushing so blocked publishers don't prevent
+	// unsubscriptions.
+	for n, s := range subs {
+		if !s.push(event) {
+			log.Printf("events: Disconnected slow subscriber %v (%v pending)",
+				s.name, s.bufferSize)
+			b.Lock()
+			b.detach(keys[n])
+			b.Unlock()
+			s.remove(true)
+		}
+	}
+}
+
+// Close stops accepting events, subscriber channels are closed once their
+// pending events are delivered.
+func (b *Bus) Close() {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, s := range b.subscribers {
+		s.finish()
+	}
+}
+
+// Stats returns metrics for all the subscribers
+func (b *Bus) Stats() []Stats {
+	b.Lock()
+	subs := make([]*subscriber, len(b.order))
+	for n, key := range b.order {
+		subs[n] = b.subscribers[key]
+	}
+	b.Unlock()
+
+	stats := make([]Stats, len(subs))
+	for n, s := range subs {
+		stats[n] = s.stats()
+	}
+	return stats
+}
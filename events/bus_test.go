@@ -0,0 +1,133 @@
+package events
+
+import (
+	"time"
+	"testing"
+)
+
+// subscribe helper returning the subscriber channel
+func subscribe(t *testing.T, bus *Bus, name string, bufferSize int, policy Policy) chan int {
+	ch := make(chan int)
+	deliver := func(event interface{}, quit chan bool) bool {
+		select {
+		case ch <- event.(int):
+			return true
+		case <-quit:
+			return false
+		}
+	}
+	if err := bus.Subscribe(ch, name, bufferSize, policy, deliver, func() { close(ch) }); err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+// receive expects an event in less than a second
+func receive(t *testing.T, ch chan int) (int, bool) {
+	select {
+	case event, ok := <-ch:
+		return event, ok
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for event")
+	}
+	return 0, false
+}
+
+// Test all subscribers receive events in order, and a stalled subscriber
+// doesn't block the publisher until its buffer is full.
+func TestBusBlockPolicy(t *testing.T) {
+	bus := NewBus()
+	fast  := subscribe(t, bus, "fast", 10, BlockPolicy)
+	stall := subscribe(t, bus, "stall", 10, BlockPolicy)
+
+	// The stalled subscriber buffer holds 10 events plus the one being delivered
+	for n := 0; n < 11; n++ {
+		bus.Publish(n)
+		if event, _ := receive(t, fast); event != n {
+			t.Errorf("Publish(): Expecting event %v received %v", n, event)
+		}
+	}
+
+	// Publisher blocks until the stalled subscriber receives an event
+	published := make(chan bool)
+	go func() {
+		bus.Publish(11)
+		published <- true
+	}()
+
+	select {
+	case <-published:
+		t.Errorf("Publish(): Should block when the buffer is full")
+	case <-time.After(50*time.Millisecond):
+	}
+
+	for n := 0; n < 12; n++ {
+		if event, _ := receive(t, stall); event != n {
+			t.Errorf("Publish(): Expecting event %v received %v", n, event)
+		}
+	}
+	<-published
+	receive(t, fast)
+
+	// Unsubscribed channels aren't closed, the rest are closed on Close
+	bus.Unsubscribe(stall)
+	bus.Close()
+	if _, ok := receive(t, fast); ok {
+		t.Errorf("Close(): Subscriber channel should have been closed")
+	}
+	select {
+	case <-stall:
+		t.Errorf("Unsubscribe(): Unexpected event")
+	case <-time.After(50*time.Millisecond):
+	}
+}
+
+// Test the oldest events are dropped when the buffer is full
+func TestBusDropPolicy(t *testing.T) {
+	bus := NewBus()
+	ch := subscribe(t, bus, "drop", 5, DropPolicy)
+
+	bus.Publish(0)
+	time.Sleep(20*time.Millisecond) // Wait until delivery routine is blocked sending 0
+
+	for n := 1; n < 20; n++ {
+		bus.Publish(n)
+	}
+
+	stats := bus.Stats()
+	if len(stats) != 1 || stats[0].Dropped != 14 || stats[0].Pending != 5 {
+		t.Errorf("Stats(): Unexpected stats %v", stats)
+	}
+
+	expected := []int{0, 15, 16, 17, 18, 19}
+	for _, n := range expected {
+		if event, _ := receive(t, ch); event != n {
+			t.Errorf("Expecting event %v received %v", n, event)
+		}
+	}
+}
+
+// Test slow subscribers are disconnected without affecting the rest
+func TestBusDisconnectPolicy(t *testing.T) {
+	bus := NewBus()
+	slow := subscribe(t, bus, "slow", 2, DisconnectPolicy)
+	fast := subscribe(t, bus, "fast", 2, DisconnectPolicy)
+
+	for n := 0; n < 10; n++ {
+		bus.Publish(n)
+		if event, _ := receive(t, fast); event != n {
+			t.Errorf("Expecting event %v received %v", n, event)
+		}
+	}
+
+	if len(bus.Stats()) != 1 {
+		t.Errorf("Stats(): Slow subscriber should have been disconnected")
+	}
+
+	// Slow channel is closed without delivering pending events
+	for {
+		if _, ok := receive(t, slow); !ok {
+			break
+		}
+	}
+}
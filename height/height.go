@@ -1,73 +1,266 @@
 package height
 
 import (
+	"log"
+	"sort"
 	"sync"
+	"time"
+	"context"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/bitcoind"
 	"github.com/secnot/gobalance/block_manager"
 )
 
+const (
+	// Number of blocks used to compute the median time past
+	MedianTimeBlocks = 11
+
+	// Max number of block headers kept, enough to follow most reorgs
+	// without fetching them again.
+	MaxHeaders = 100
+)
+
+// header is the block information needed to describe the tip
+type header struct {
+	Height   int64
+	Hash     chainhash.Hash
+	PrevHash chainhash.Hash
+	Time     time.Time
+}
+
+// Tip describes the last block processed by the block manager
+type Tip struct {
+	Height int64
+	Hash   chainhash.Hash
+
+	// Block header timestamp (zero when unknown)
+	Time time.Time
+
+	// Median of the last MedianTimeBlocks timestamps (zero when unknown)
+	MedianTime time.Time
+}
 
 
 type HeightCache struct {
 	sync.RWMutex
 	manager block_manager.BlockManagerInterface
-	height uint64
 
-	//
-	stopChan chan chan bool
+	// bitcoind used to fetch the headers missing at startup or after a
+	// deep reorg (nil to disable)
+	pool *bitcoind.Pool
+
+	tip Tip
+
+	// Last blocks headers oldest first, only accessed from heightRoutine
+	headers []header
+
+	// Cancels the routine context, done is closed once it has exited
+	cancel context.CancelFunc
+	done   chan bool
 }
 
-// NewHeightCache initializes and starts cache
-func NewHeightCache(manager block_manager.BlockManagerInterface) *HeightCache {
-	
+// NewHeightCache initializes and starts cache, it runs until ctx is cancelled
+// or Stop is called. pool may be nil.
+func NewHeightCache(ctx context.Context, manager block_manager.BlockManagerInterface, pool *bitcoind.Pool) *HeightCache {
+
+	ctx, cancel := context.WithCancel(ctx)
 	cache := &HeightCache {
-		stopChan: make(chan chan bool),
+		cancel:   cancel,
+		done:     make(chan bool),
 		manager:  manager,
-		height:   0,
+		pool:     pool,
+		tip:      Tip{Height: -1},
+		headers:  make([]header, 0, MaxHeaders),
 	}
 
-	go cache.heightRoutine()
+	go cache.heightRoutine(ctx)
 	return cache
 }
 
-// heightRoutine handles block updades and stop signal
-func (h *HeightCache) heightRoutine() {	
+// heightRoutine handles block updades until the context is cancelled
+func (h *HeightCache) heightRoutine(ctx context.Context) {
+
+	updateChan := h.manager.Subscribe("height", 10)
+	h.init(ctx)
 
-	updateChan := h.manager.Subscribe(10)
-	
 	for {
 
 		select {
-		case update := <- updateChan:
-			h.Lock()
+		case update, ok := <- updateChan:
+			if !ok { // Manager stopped
+				updateChan = nil
+				continue
+			}
 			switch update.Class {
 			case block_manager.OP_NEWBLOCK:
-				h.height = update.Block.Height
+				h.newBlock(update)
 			case block_manager.OP_BACKTRACK:
-				h.height = update.Block.Height - 1
+				h.backtrack(update)
 			}
-			h.Unlock()
 
-		case ch := <- h.stopChan:
+		case <- ctx.Done():
 			h.manager.Unsubscribe(updateChan)
-			ch <- true
-			
+			close(h.done)
 			return
 		}
 	}
 }
 
-// Stop sends signal and waits for confirmation
+// init sets the tip to the manager current block, updates for blocks
+// processed before are ignored.
+func (h *HeightCache) init(ctx context.Context) {
+	progress, err := h.manager.SyncProgress(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Print("HeightCache: ", err)
+		}
+		return
+	}
+	h.setTip(progress.Height, progress.Hash)
+}
+
+// newBlock appends a block following the tip
+func (h *HeightCache) newBlock(update block_manager.BlockUpdate) {
+	block := update.Block
+	if int64(block.Height) <= h.tip.Height {
+		return // Already included
+	}
+
+	if len(h.headers) > 0 && h.headers[len(h.headers)-1].Hash != block.PrevHash {
+		h.headers = h.headers[:0]
+	}
+	h.headers = append(h.headers, header {
+		Height:   int64(block.Height),
+		Hash:     block.Hash,
+		PrevHash: block.PrevHash,
+		Time:     block.Time,
+	})
+	if len(h.headers) > MaxHeaders {
+		h.headers = append(h.headers[:0], h.headers[len(h.headers)-MaxHeaders:]...)
+	}
+	h.setTip(int64(block.Height), block.Hash)
+}
+
+// backtrack removes the tip block, the previous one becomes the tip
+func (h *HeightCache) backtrack(update block_manager.BlockUpdate) {
+	block := update.Block
+	if block.Hash != h.tip.Hash {
+		return // Not included
+	}
+
+	if len(h.headers) > 0 {
+		h.headers = h.headers[:len(h.headers)-1]
+	}
+	h.setTip(int64(block.Height)-1, block.PrevHash)
+}
+
+// setTip updates the tip fetching any missing header
+func (h *HeightCache) setTip(height int64, hash chainhash.Hash) {
+	if len(h.headers) > 0 && h.headers[len(h.headers)-1].Hash != hash {
+		h.headers = h.headers[:0]
+	}
+	if height >= 0 {
+		h.fillHeaders(height, hash)
+	}
+
+	tip := Tip{Height: height, Hash: hash}
+	if len(h.headers) > 0 {
+		tip.Time = h.headers[len(h.headers)-1].Time
+		tip.MedianTime = h.medianTime()
+	}
+
+	h.Lock()
+	h.tip = tip
+	h.Unlock()
+}
+
+// fillHeaders fetches from bitcoind the headers before the oldest one until
+// there are enough to compute the median time past.
+func (h *HeightCache) fillHeaders(height int64, hash chainhash.Hash) {
+	if h.pool == nil {
+		return
+	}
+
+	for len(h.headers) < MedianTimeBlocks {
+		next := hash
+		if len(h.headers) > 0 {
+			if h.headers[0].Height == 0 {
+				return
+			}
+			next = h.headers[0].PrevHash
+		}
+
+		result, err := h.pool.BlockHeader(&next)
+		if err != nil {
+			log.Print("HeightCache: ", err)
+			return
+		}
+		prevHash := chainhash.Hash{}
+		if result.PreviousHash != "" {
+			parsed, err := chainhash.NewHashFromStr(result.PreviousHash)
+			if err != nil {
+				log.Print("HeightCache: ", err)
+				return
+			}
+			prevHash = *parsed
+		}
+
+		fetched := header {
+			Height:   int64(result.Height),
+			Hash:     next,
+			PrevHash: prevHash,
+			Time:     time.Unix(result.Time, 0),
+		}
+		h.headers = append([]header{fetched}, h.headers...)
+	}
+}
+
+// medianTime returns the median time past for the last header, or zero time
+// if there are not enough headers.
+func (h *HeightCache) medianTime() time.Time {
+	count := len(h.headers)
+	if count > MedianTimeBlocks {
+		count = MedianTimeBlocks
+	}
+	last := h.headers[len(h.headers)-count:]
+	if count < MedianTimeBlocks && last[0].Height != 0 {
+		return time.Time{}
+	}
+
+	times := make([]time.Time, count)
+	for n, hdr := range last {
+		times[n] = hdr.Time
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times[count/2]
+}
+
+// Stop cancels the height routine and waits until it has exited
 func (h *HeightCache) Stop() {
-	doneCh := make(chan bool)
-	h.stopChan <- doneCh
-	<-doneCh
-	close(doneCh)
+	h.cancel()
+	<-h.done
 }
 
 // GetHeight for the current top of the chain
 func (h *HeightCache) GetHeight() (height uint64) {
 	h.RLock()
-	height = h.height
+	if h.tip.Height > 0 {
+		height = uint64(h.tip.Height)
+	}
+	h.RUnlock()
+	return
+}
+
+// GetTip returns the current top of the chain
+func (h *HeightCache) GetTip() (tip Tip) {
+	h.RLock()
+	tip = h.tip
 	h.RUnlock()
 	return
 }
+
+// SyncProgress returns the block manager sync progress
+func (h *HeightCache) SyncProgress(ctx context.Context) (block_manager.SyncProgress, error) {
+	return h.manager.SyncProgress(ctx)
+}
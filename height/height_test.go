@@ -0,0 +1,104 @@
+package height
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/primitives"
+)
+
+// mockManager sends the updates written to updates, other methods aren't used
+type mockManager struct {
+	block_manager.BlockManagerInterface
+	updates block_manager.UpdateChan
+
+	// SyncProgress blocks until the context is cancelled
+	blocked bool
+}
+
+func (m *mockManager) Subscribe(name string, chanSize uint) block_manager.UpdateChan {
+	return m.updates
+}
+
+func (m *mockManager) Unsubscribe(ch block_manager.UpdateChan) {}
+
+func (m *mockManager) SyncProgress(ctx context.Context) (block_manager.SyncProgress, error) {
+	if m.blocked {
+		<-ctx.Done()
+		return block_manager.SyncProgress{}, ctx.Err()
+	}
+	return block_manager.SyncProgress{Height: -1, TargetHeight: -1}, nil
+}
+
+// mockBlock returns a block with timestamp height*10 seconds
+func mockBlock(height uint64, fork byte, prev chainhash.Hash) *primitives.Block {
+	block := primitives.NewBlock(chainhash.Hash{byte(height), fork}, prev, height)
+	block.Time = time.Unix(int64(height)*10, 0)
+	return block
+}
+
+// waitTip waits until the cache tip is the expected block
+func waitTip(t *testing.T, h *HeightCache, expected *primitives.Block) Tip {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		if tip := h.GetTip(); tip.Hash == expected.Hash {
+			return tip
+		}
+	}
+	t.Fatalf("GetTip(): Expecting tip %v returned %v", expected.Hash, h.GetTip().Hash)
+	return Tip{}
+}
+
+func TestHeightReorg(t *testing.T) {
+	manager := &mockManager{updates: make(block_manager.UpdateChan, 20)}
+	h := NewHeightCache(context.Background(), manager, nil)
+	defer h.Stop()
+
+	blocks := []*primitives.Block{mockBlock(0, 0, primitives.ZeroHash)}
+	for height := uint64(1); height < 5; height++ {
+		blocks = append(blocks, mockBlock(height, 0, blocks[height-1].Hash))
+	}
+	for _, block := range blocks {
+		manager.updates <- block_manager.NewBlockUpdate(block_manager.OP_NEWBLOCK, block)
+	}
+	tip := waitTip(t, h, blocks[4])
+	if tip.Height != 4 || tip.Time.Unix() != 40 || tip.MedianTime.Unix() != 20 {
+		t.Errorf("GetTip(): Unexpected tip %+v", tip)
+	}
+
+	// Two blocks reorg
+	manager.updates <- block_manager.NewBlockUpdate(block_manager.OP_BACKTRACK, blocks[4])
+	manager.updates <- block_manager.NewBlockUpdate(block_manager.OP_BACKTRACK, blocks[3])
+	tip = waitTip(t, h, blocks[2])
+	if tip.Height != 2 || tip.Time.Unix() != 20 || h.GetHeight() != 2 {
+		t.Errorf("GetTip(): Unexpected tip after backtrack %+v", tip)
+	}
+
+	fork3 := mockBlock(3, 1, blocks[2].Hash)
+	fork4 := mockBlock(4, 1, fork3.Hash)
+	fork5 := mockBlock(5, 1, fork4.Hash)
+	for _, block := range []*primitives.Block{fork3, fork4, fork5} {
+		manager.updates <- block_manager.NewBlockUpdate(block_manager.OP_NEWBLOCK, block)
+	}
+	tip = waitTip(t, h, fork5)
+	if tip.Height != 5 || tip.Time.Unix() != 50 || tip.MedianTime.Unix() != 30 {
+		t.Errorf("GetTip(): Unexpected tip after reorg %+v", tip)
+	}
+}
+
+// Test cancelling the context stops the cache while it is initializing
+func TestHeightStopContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	manager := &mockManager{updates: make(block_manager.UpdateChan), blocked: true}
+	h := NewHeightCache(ctx, manager, nil)
+
+	cancel()
+	select {
+	case <-h.done:
+	case <-time.After(time.Second):
+		t.Fatal("NewHeightCache(): Timeout waiting for the routine to exit")
+	}
+	h.Stop() // Already stopped
+}
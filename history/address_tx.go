@@ -0,0 +1,246 @@
+package history
+
+import (
+	"fmt"
+	"context"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/block_manager/storage"
+	"github.com/secnot/gobalance/primitives"
+)
+
+var TX_SCHEMAS = [...]string {
+	`CREATE TABLE IF NOT EXISTS address_tx (
+			addr text NOT NULL,
+			height integer NOT NULL,
+			position integer NOT NULL,
+			txid text NOT NULL,
+			delta integer NOT NULL,
+			PRIMARY KEY(addr, height, position));`,
+	fmt.Sprintf(infoSchema, "address_tx_info"),
+}
+
+// blockTxs contains the transactions of a single block by address, in block
+// order.
+type blockTxs struct {
+	height int64
+	txs    map[string][]block_manager.AddressTx
+}
+
+// TxIndexer is the address transactions block_manager.Indexer, it stores
+// every transaction involving each address.
+type TxIndexer struct {
+	sto storage.Storage
+
+	// First height in the index, the index is only complete when 0
+	startHeight int64
+
+	// Blocks connected but not yet committed, in order
+	pending []blockTxs
+}
+
+// NewTxIndexer returns an address transactions indexer
+func NewTxIndexer() *TxIndexer {
+	return &TxIndexer {
+		pending: make([]blockTxs, 0),
+	}
+}
+
+func (h *TxIndexer) Name() string {
+	return block_manager.AddressTxIndexerName
+}
+
+// Init creates address tx tables, the index is reset when its last height
+// doesn't match storage.
+func (h *TxIndexer) Init(sto storage.Storage, height int64) error {
+	h.sto = sto
+	return sto.Transact(func(tx storage.Tx) error {
+		for _, schema := range TX_SCHEMAS {
+			if _, err := tx.Exec(schema); err != nil {
+				return err
+			}
+		}
+
+		start, err := initInfo(tx, "address_tx_info", "address_tx", height)
+		h.startHeight = start
+		return err
+	})
+}
+
+// ConnectBlock queues block transactions until it is committed
+func (h *TxIndexer) ConnectBlock(block *primitives.Block) error {
+	txs := make(map[string][]block_manager.AddressTx)
+	for position, tx := range block.Transactions {
+		tx.ForEachAddress(func(addr string, balance int64, tx *primitives.Tx) {
+			txs[addr] = append(txs[addr], block_manager.AddressTx {
+				TxHash:   *tx.Hash,
+				Height:   int64(block.Height),
+				Position: position,
+				Delta:    balance,
+			})
+		})
+	}
+	h.pending = append(h.pending, blockTxs{height: int64(block.Height), txs: txs})
+	return nil
+}
+
+// DisconnectBlock discards the last block transactions
+func (h *TxIndexer) DisconnectBlock(block *primitives.Block) error {
+	if n := len(h.pending); n > 0 && h.pending[n-1].height == int64(block.Height) {
+		h.pending = h.pending[:n-1]
+	}
+	return nil
+}
+
+// Commit stores the transactions for blocks up to height
+func (h *TxIndexer) Commit(tx storage.Tx, height int64) error {
+	for _, block := range h.pending {
+		if block.height > height {
+			break
+		}
+		for addr, txs := range block.txs {
+			for _, atx := range txs {
+				_, err := tx.Exec("INSERT INTO address_tx(addr, height, position, txid, delta) VALUES(?, ?, ?, ?, ?);",
+					addr, atx.Height, atx.Position, atx.TxHash.String(), atx.Delta)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return saveInfo(tx, "address_tx_info", height)
+}
+
+// Committed discards the transactions stored by the last commit
+func (h *TxIndexer) Committed(height int64) {
+	n := 0
+	for n < len(h.pending) && h.pending[n].height <= height {
+		n++
+	}
+	h.pending = h.pending[n:]
+}
+
+// Query returns an AddressTxPage for a block_manager.AddressTxQuery, pending
+// blocks are always newer than the stored ones so they are returned first.
+func (h *TxIndexer) Query(ctx context.Context, query interface{}) (interface{}, error) {
+	read, err := h.PrepareQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return read(ctx)
+}
+
+// PrepareQuery copies the pending transactions for a Query, the returned
+// function completes the page with the stored ones.
+func (h *TxIndexer) PrepareQuery(query interface{}) (block_manager.StoredQuery, error) {
+	q, ok := query.(block_manager.AddressTxQuery)
+	if !ok || q.Limit < 1 {
+		return nil, ErrInvalidQuery
+	}
+
+	if h.startHeight > 0 {
+		return nil, block_manager.ErrAddressTxUnavailable
+	}
+
+	// Transactions before the cursor (or all when there is none)
+	maxHeight, maxPosition := q.MaxHeight, -1
+	if q.Cursor != "" {
+		height, position, err := block_manager.DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if maxHeight < 0 || height <= maxHeight {
+			maxHeight, maxPosition = height, position
+		}
+	}
+	before := func(atx block_manager.AddressTx) bool {
+		if atx.Height < q.MinHeight {
+			return false
+		}
+		if maxHeight < 0 || atx.Height < maxHeight {
+			return true
+		}
+		return atx.Height == maxHeight && (maxPosition < 0 || atx.Position < maxPosition)
+	}
+
+	// One more transaction than the limit is retrieved to know if there
+	// is a next page.
+	txs := make([]block_manager.AddressTx, 0, q.Limit+1)
+	for n := len(h.pending)-1; n >= 0 && len(txs) <= q.Limit; n-- {
+		addrTxs := h.pending[n].txs[q.Address]
+		for i := len(addrTxs)-1; i >= 0 && len(txs) <= q.Limit; i-- {
+			if before(addrTxs[i]) {
+				txs = append(txs, addrTxs[i])
+			}
+		}
+	}
+
+	read := func(ctx context.Context) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		all := txs
+		if len(all) <= q.Limit {
+			stored, err := h.storedTxs(q.Address, q.MinHeight, maxHeight, maxPosition, q.Limit+1-len(all))
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, stored...)
+		}
+
+		page := block_manager.AddressTxPage{Txs: all}
+		if len(all) > q.Limit {
+			page.Txs = all[:q.Limit]
+			last := page.Txs[q.Limit-1]
+			page.Cursor = block_manager.EncodeCursor(last.Height, last.Position)
+		}
+		return page, nil
+	}
+	return read, nil
+}
+
+// storedTxs returns up to limit stored address transactions newest first,
+// maxHeight < 0 means no limit and maxPosition < 0 includes all the
+// transactions at maxHeight.
+func (h *TxIndexer) storedTxs(address string, minHeight, maxHeight int64, maxPosition int, limit int) ([]block_manager.AddressTx, error) {
+	var rows []struct {
+		Height   int64  `db:"height"`
+		Position int    `db:"position"`
+		TxId     string `db:"txid"`
+		Delta    int64  `db:"delta"`
+	}
+
+	if maxHeight < 0 {
+		maxHeight, maxPosition = int64(^uint64(0)>>1), -1
+	}
+	if maxPosition < 0 {
+		maxPosition = int(^uint(0)>>1)
+	}
+
+	err := h.sto.Transact(func(tx storage.Tx) error {
+		return tx.Select(&rows, `SELECT height, position, txid, delta FROM address_tx
+			WHERE addr=? AND height>=? AND (height<? OR (height=? AND position<?))
+			ORDER BY height DESC, position DESC LIMIT ?;`,
+			address, minHeight, maxHeight, maxHeight, maxPosition, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	txs := make([]block_manager.AddressTx, len(rows))
+	for n, row := range rows {
+		hash, err := chainhash.NewHashFromStr(row.TxId)
+		if err != nil {
+			return nil, err
+		}
+		txs[n] = block_manager.AddressTx {
+			TxHash:   *hash,
+			Height:   row.Height,
+			Position: row.Position,
+			Delta:    row.Delta,
+		}
+	}
+	return txs, nil
+}
@@ -0,0 +1,112 @@
+package history
+
+import (
+	"context"
+	"testing"
+
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/block_manager/storage"
+	"github.com/secnot/gobalance/primitives"
+)
+
+// queryTxs returns all the pages for the query
+func queryTxs(t *testing.T, h *TxIndexer, query block_manager.AddressTxQuery) [][]block_manager.AddressTx {
+	pages := make([][]block_manager.AddressTx, 0)
+	for {
+		result, err := h.Query(context.Background(), query)
+		if err != nil {
+			t.Fatalf("Query(%v): %v", query, err)
+		}
+		page := result.(block_manager.AddressTxPage)
+		pages = append(pages, page.Txs)
+		if page.Cursor == "" {
+			return pages
+		}
+		query.Cursor = page.Cursor
+	}
+}
+
+// pagesHeightsAre checks the heights of the transactions in each page
+func pagesHeightsAre(t *testing.T, pages [][]block_manager.AddressTx, expected [][]int64) {
+	if len(pages) != len(expected) {
+		t.Errorf("Returned %v pages expecting %v", len(pages), len(expected))
+		return
+	}
+	for n, page := range pages {
+		if len(page) != len(expected[n]) {
+			t.Errorf("Page %v: returned %v txs expecting %v", n, len(page), len(expected[n]))
+			continue
+		}
+		for i, atx := range page {
+			if atx.Height != expected[n][i] {
+				t.Errorf("Page %v: tx %v height %v expecting %v", n, i, atx.Height, expected[n][i])
+			}
+		}
+	}
+}
+
+func TestAddressTxQuery(t *testing.T) {
+	sto, err := storage.NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Fatal("NewSQLiteStorage(): ", err)
+	}
+
+	h := NewTxIndexer()
+	if err := h.Init(sto, -1); err != nil {
+		t.Fatal("Init(): ", err)
+	}
+
+	blocks := make([]*primitives.Block, 0)
+	for height := uint64(0); height < 5; height++ {
+		block := mockBlock(height, "addr1", int64(height+1), nil)
+		blocks = append(blocks, block)
+		if err := h.ConnectBlock(block); err != nil {
+			t.Fatal("ConnectBlock(): ", err)
+		}
+	}
+
+	// Blocks 0-2 stored, 3-4 pending
+	commit(t, sto, h, 2)
+
+	query := block_manager.AddressTxQuery{Address: "addr1", MaxHeight: -1, Limit: 2}
+	pagesHeightsAre(t, queryTxs(t, h, query), [][]int64{{4, 3}, {2, 1}, {0}})
+
+	query.Limit = 3
+	pagesHeightsAre(t, queryTxs(t, h, query), [][]int64{{4, 3, 2}, {1, 0}})
+
+	query = block_manager.AddressTxQuery{Address: "addr1", MinHeight: 1, MaxHeight: 3, Limit: 10}
+	pages := queryTxs(t, h, query)
+	pagesHeightsAre(t, pages, [][]int64{{3, 2, 1}})
+	if atx := pages[0][0]; atx.TxHash != *blocks[3].Transactions[0].Hash || atx.Delta != 4 {
+		t.Errorf("Query(): Unexpected tx %v", atx)
+	}
+
+	// Disconnected blocks are discarded
+	h.DisconnectBlock(blocks[4])
+	query = block_manager.AddressTxQuery{Address: "addr1", MaxHeight: -1, Limit: 10}
+	pagesHeightsAre(t, queryTxs(t, h, query), [][]int64{{3, 2, 1, 0}})
+
+	query.Cursor = "bad"
+	if _, err := h.Query(context.Background(), query); err != block_manager.ErrInvalidCursor {
+		t.Errorf("Query(): Expecting ErrInvalidCursor returned %v", err)
+	}
+
+	query = block_manager.AddressTxQuery{Address: "unknown", MaxHeight: -1, Limit: 10}
+	pagesHeightsAre(t, queryTxs(t, h, query), [][]int64{{}})
+}
+
+func TestAddressTxUnavailable(t *testing.T) {
+	sto, err := storage.NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Fatal("NewSQLiteStorage(): ", err)
+	}
+
+	h := NewTxIndexer()
+	if err := h.Init(sto, 10); err != nil {
+		t.Fatal("Init(): ", err)
+	}
+	query := block_manager.AddressTxQuery{Address: "addr1", MaxHeight: -1, Limit: 10}
+	if _, err := h.Query(context.Background(), query); err != block_manager.ErrAddressTxUnavailable {
+		t.Errorf("Query(): Expecting ErrAddressTxUnavailable returned %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+history implements the address history indexer, it stores the balance delta
+of each address for every block so the balance at any past height can be
+calculated.
+
+The history is only complete when it has been built since the genesis block,
+if it is enabled on an existing db (or blocks were processed without it) the
+index is reset and restarted from the next block. Balances at heights since
+then are computed subtracting the later deltas from the current balance, and
+queries for older heights fail with ErrHistoryUnavailable.
+*/
+package history
+
+import (
+	"fmt"
+	"errors"
+	"context"
+
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/block_manager/storage"
+	"github.com/secnot/gobalance/primitives"
+)
+
+var ErrInvalidQuery = errors.New("history: Invalid query")
+
+var SCHEMAS = [...]string {
+	`CREATE TABLE IF NOT EXISTS address_history (
+			addr text NOT NULL,
+			height integer NOT NULL,
+			delta integer NOT NULL,
+			PRIMARY KEY(addr, height));`,
+	fmt.Sprintf(infoSchema, "history_info"),
+}
+
+// blockDeltas contains the address balance deltas for a single block
+type blockDeltas struct {
+	height int64
+	deltas map[string]int64
+}
+
+// Indexer is the address history block_manager.Indexer
+type Indexer struct {
+	sto storage.Storage
+
+	// First height in the index, the history is only complete when 0
+	startHeight int64
+
+	// Blocks connected but not yet committed, in order
+	pending []blockDeltas
+}
+
+// NewIndexer returns an address history indexer
+func NewIndexer() *Indexer {
+	return &Indexer {
+		pending: make([]blockDeltas, 0),
+	}
+}
+
+func (h *Indexer) Name() string {
+	return block_manager.HistoryIndexerName
+}
+
+// Init creates history tables, the index is reset when its last height
+// doesn't match storage because some blocks were processed without it.
+func (h *Indexer) Init(sto storage.Storage, height int64) error {
+	h.sto = sto
+	return sto.Transact(func(tx storage.Tx) error {
+		for _, schema := range SCHEMAS {
+			if _, err := tx.Exec(schema); err != nil {
+				return err
+			}
+		}
+
+		start, err := initInfo(tx, "history_info", "address_history", height)
+		h.startHeight = start
+		return err
+	})
+}
+
+// ConnectBlock queues block deltas until it is committed
+func (h *Indexer) ConnectBlock(block *primitives.Block) error {
+	deltas := make(map[string]int64)
+	for _, tx := range block.Transactions {
+		tx.ForEachAddress(func(addr string, balance int64, tx *primitives.Tx) {
+			deltas[addr] += balance
+		})
+	}
+	h.pending = append(h.pending, blockDeltas{height: int64(block.Height), deltas: deltas})
+	return nil
+}
+
+// DisconnectBlock discards the last block deltas
+func (h *Indexer) DisconnectBlock(block *primitives.Block) error {
+	if n := len(h.pending); n > 0 && h.pending[n-1].height == int64(block.Height) {
+		h.pending = h.pending[:n-1]
+	}
+	return nil
+}
+
+// Commit stores the deltas for blocks up to height
+func (h *Indexer) Commit(tx storage.Tx, height int64) error {
+	for _, block := range h.pending {
+		if block.height > height {
+			break
+		}
+		for addr, delta := range block.deltas {
+			if delta == 0 {
+				continue
+			}
+			_, err := tx.Exec("INSERT INTO address_history(addr, height, delta) VALUES(?, ?, ?);",
+				addr, block.height, delta)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return saveInfo(tx, "history_info", height)
+}
+
+// Committed discards the deltas stored by the last commit
+func (h *Indexer) Committed(height int64) {
+	n := 0
+	for n < len(h.pending) && h.pending[n].height <= height {
+		n++
+	}
+	h.pending = h.pending[n:]
+}
+
+// Query returns the address balance (int64) at the height requested with
+// a block_manager.BalanceAtQuery, the height can't be before the block
+// preceding the index start.
+func (h *Indexer) Query(ctx context.Context, query interface{}) (interface{}, error) {
+	read, err := h.PrepareQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return read(ctx)
+}
+
+// PrepareQuery adds the pending deltas for a Query, the returned function
+// adds the stored ones.
+func (h *Indexer) PrepareQuery(query interface{}) (block_manager.StoredQuery, error) {
+	q, ok := query.(block_manager.BalanceAtQuery)
+	if !ok {
+		return nil, ErrInvalidQuery
+	}
+
+	if q.Height < h.startHeight-1 {
+		return nil, block_manager.ErrHistoryUnavailable
+	}
+
+	var pending int64
+	for _, block := range h.pending {
+		if block.height > q.Height {
+			break
+		}
+		pending += block.deltas[q.Address]
+	}
+
+	read := func(ctx context.Context) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		balance, err := h.storedBalanceAt(q.Address, q.Height)
+		if err != nil {
+			return nil, err
+		}
+		return balance + pending, nil
+	}
+	return read, nil
+}
+
+// storedBalanceAt returns the address balance at height only including the
+// committed blocks. When the history is incomplete the earlier deltas are
+// unknown, so the stored deltas after height are subtracted from the
+// committed balance instead.
+func (h *Indexer) storedBalanceAt(address string, height int64) (balance int64, err error) {
+	if h.startHeight == 0 {
+		err = h.sto.Transact(func(tx storage.Tx) error {
+			return tx.Get(&balance, "SELECT coalesce(SUM(delta), 0) FROM address_history WHERE addr=? AND height<=?;",
+				address, height)
+		})
+		return balance, err
+	}
+
+	var after int64
+	err = h.sto.Transact(func(tx storage.Tx) error {
+		return tx.Get(&after, "SELECT coalesce(SUM(delta), 0) FROM address_history WHERE addr=? AND height>?;",
+			address, height)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if balance, err = h.sto.GetBalance(address); err != nil {
+		return 0, err
+	}
+	return balance - after, nil
+}
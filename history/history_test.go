@@ -0,0 +1,195 @@
+package history
+
+import (
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/block_manager/storage"
+	"github.com/secnot/gobalance/primitives"
+)
+
+// mockBlock returns a block with a single transaction paying value to
+// address, and spending the input (if not nil)
+func mockBlock(height uint64, address string, value int64, in *primitives.TxOut) *primitives.Block {
+	hash := chainhash.Hash{byte(height), 1}
+	tx := primitives.NewTx(&hash)
+	tx.AddOut(primitives.NewTxOut(&hash, 0, address, value))
+	if in != nil {
+		tx.AddIn(in)
+	}
+	return &primitives.Block {
+		Hash:         chainhash.Hash{byte(height)},
+		Height:       height,
+		Transactions: []*primitives.Tx{tx},
+	}
+}
+
+// balanceAtIs checks the balance returned by the indexer
+func balanceAtIs(t *testing.T, h *Indexer, address string, height int64, expected int64) {
+	result, err := h.Query(context.Background(), block_manager.BalanceAtQuery{Address: address, Height: height})
+	if err != nil {
+		t.Errorf("Query(%v, %v): %v", address, height, err)
+		return
+	}
+	if result.(int64) != expected {
+		t.Errorf("Query(%v, %v): returned %v expecting %v", address, height, result, expected)
+	}
+}
+
+// committedIndexer is a history indexer notified of commits
+type committedIndexer interface {
+	block_manager.Indexer
+	block_manager.IndexerCommitObserver
+}
+
+// commit stores the index up to height as the block manager does
+func commit(t *testing.T, sto storage.Storage, h committedIndexer, height int64) {
+	err := sto.Transact(func(tx storage.Tx) error {
+		return h.Commit(tx, height)
+	})
+	if err != nil {
+		t.Fatal("Commit(): ", err)
+	}
+	h.Committed(height)
+}
+
+func TestHistoryQuery(t *testing.T) {
+	sto, err := storage.NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Fatal("NewSQLiteStorage(): ", err)
+	}
+
+	h := NewIndexer()
+	if err := h.Init(sto, -1); err != nil {
+		t.Fatal("Init(): ", err)
+	}
+
+	block0 := mockBlock(0, "addr1", 100, nil)
+	block1 := mockBlock(1, "addr2", 30, nil)
+	spent  := primitives.NewTxOut(block0.Transactions[0].Hash, 0, "addr1", 100)
+	block2 := mockBlock(2, "addr2", 70, spent)
+	for _, block := range []*primitives.Block{block0, block1, block2} {
+		if err := h.ConnectBlock(block); err != nil {
+			t.Fatal("ConnectBlock(): ", err)
+		}
+	}
+
+	// Only pending deltas
+	balanceAtIs(t, h, "addr1", 0, 100)
+	balanceAtIs(t, h, "addr1", 2, 0)
+	balanceAtIs(t, h, "addr2", 1, 30)
+
+	// Stored and pending deltas
+	commit(t, sto, h, 1)
+	if len(h.pending) != 1 {
+		t.Errorf("Committed(): %v pending blocks expecting 1", len(h.pending))
+	}
+	balanceAtIs(t, h, "addr1", 0, 100)
+	balanceAtIs(t, h, "addr1", 1, 100)
+	balanceAtIs(t, h, "addr1", 2, 0)
+	balanceAtIs(t, h, "addr2", 0, 0)
+	balanceAtIs(t, h, "addr2", 2, 100)
+	balanceAtIs(t, h, "unknown", 2, 0)
+
+	// Disconnected blocks are discarded
+	if err := h.DisconnectBlock(block2); err != nil {
+		t.Fatal("DisconnectBlock(): ", err)
+	}
+	balanceAtIs(t, h, "addr1", 2, 100)
+	balanceAtIs(t, h, "addr2", 2, 30)
+
+	if _, err := h.Query(context.Background(), "addr1"); err != ErrInvalidQuery {
+		t.Errorf("Query(): Expecting ErrInvalidQuery returned %v", err)
+	}
+}
+
+// Test balances since the block before the index start are available when
+// the history was enabled on an existing db
+func TestHistoryPartial(t *testing.T) {
+	sto, err := storage.NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Fatal("NewSQLiteStorage(): ", err)
+	}
+
+	// Utxo stored before the index was enabled
+	existing := map[storage.TxOutId]storage.TxOutData {
+		storage.TxOutId{TxHash: chainhash.Hash{0xaa}}: {Addr: "addr1", Value: 500, Height: 3},
+	}
+	if err := sto.BulkUpdateFromMap(existing, nil, 10, chainhash.Hash{10}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewIndexer()
+	if err := h.Init(sto, 10); err != nil {
+		t.Fatal("Init(): ", err)
+	}
+
+	// Commit the utxo and index for block 11 in the same transaction
+	block11 := mockBlock(11, "addr1", 100, nil)
+	h.ConnectBlock(block11)
+	inserts := map[storage.TxOutId]storage.TxOutData {
+		storage.TxOutId{TxHash: *block11.Transactions[0].Hash}: {Addr: "addr1", Value: 100, Height: 11},
+	}
+	hook := func(tx storage.Tx) error { return h.Commit(tx, 11) }
+	if err := sto.BulkUpdateFromMap(inserts, nil, 11, block11.Hash, hook); err != nil {
+		t.Fatal(err)
+	}
+	h.Committed(11)
+	h.ConnectBlock(mockBlock(12, "addr1", 50, nil))
+
+	_, err = h.Query(context.Background(), block_manager.BalanceAtQuery{Address: "addr1", Height: 9})
+	if err != block_manager.ErrHistoryUnavailable {
+		t.Errorf("Query(): Expecting ErrHistoryUnavailable returned %v", err)
+	}
+	balanceAtIs(t, h, "addr1", 10, 500)
+	balanceAtIs(t, h, "addr1", 11, 600)
+	balanceAtIs(t, h, "addr1", 12, 650)
+	balanceAtIs(t, h, "addr2", 10, 0)
+}
+
+func TestHistoryInit(t *testing.T) {
+	sto, err := storage.NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Fatal("NewSQLiteStorage(): ", err)
+	}
+
+	// Enabled with blocks already in storage
+	h := NewIndexer()
+	if err := h.Init(sto, 10); err != nil {
+		t.Fatal("Init(): ", err)
+	}
+	_, err = h.Query(context.Background(), block_manager.BalanceAtQuery{Address: "addr1", Height: 5})
+	if err != block_manager.ErrHistoryUnavailable {
+		t.Errorf("Query(): Expecting ErrHistoryUnavailable returned %v", err)
+	}
+
+	// Complete history survives a restart at the committed height
+	sto, err = storage.NewSQLiteStorage(":memory:")
+	if err != nil {
+		t.Fatal("NewSQLiteStorage(): ", err)
+	}
+	h = NewIndexer()
+	if err := h.Init(sto, -1); err != nil {
+		t.Fatal("Init(): ", err)
+	}
+	h.ConnectBlock(mockBlock(0, "addr1", 100, nil))
+	commit(t, sto, h, 0)
+
+	h = NewIndexer()
+	if err := h.Init(sto, 0); err != nil {
+		t.Fatal("Init(): ", err)
+	}
+	balanceAtIs(t, h, "addr1", 0, 100)
+
+	// Reset when storage moved ahead without the index
+	h = NewIndexer()
+	if err := h.Init(sto, 5); err != nil {
+		t.Fatal("Init(): ", err)
+	}
+	_, err = h.Query(context.Background(), block_manager.BalanceAtQuery{Address: "addr1", Height: 0})
+	if err != block_manager.ErrHistoryUnavailable {
+		t.Errorf("Query(): Expecting ErrHistoryUnavailable returned %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package history
+
+import (
+	"log"
+	"fmt"
+	"database/sql"
+
+	"github.com/secnot/gobalance/block_manager/storage"
+)
+
+// info table layout shared by all the history indexers, it tracks the first
+// and last heights stored in the index.
+const infoSchema = `CREATE TABLE IF NOT EXISTS %v (
+			pk integer NOT NULL,
+			start_height integer NOT NULL,
+			last_height integer NOT NULL,
+			PRIMARY KEY(pk));`
+
+// initInfo returns the index start height, when the index last height doesn't
+// match storage height the data table is cleared and the index restarted
+// from the next block.
+func initInfo(tx storage.Tx, infoTable string, dataTable string, height int64) (int64, error) {
+	var info struct {
+		StartHeight int64 `db:"start_height"`
+		LastHeight  int64 `db:"last_height"`
+	}
+	query := fmt.Sprintf("SELECT start_height, last_height FROM %v WHERE pk=1;", infoTable)
+	err := tx.Get(&info, query)
+	switch {
+	case err == nil && info.LastHeight == height:
+		return info.StartHeight, nil
+	case err == nil:
+		log.Printf("History: %v at height %v storage at %v, index reset", dataTable, info.LastHeight, height)
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %v;", dataTable)); err != nil {
+			return 0, err
+		}
+	case err != sql.ErrNoRows:
+		return 0, err
+	}
+
+	start := height + 1
+	query = fmt.Sprintf("INSERT OR REPLACE INTO %v(pk, start_height, last_height) VALUES(1, ?, ?);", infoTable)
+	_, err = tx.Exec(query, start, height)
+	return start, err
+}
+
+// saveInfo updates the index last height
+func saveInfo(tx storage.Tx, infoTable string, height int64) error {
+	_, err := tx.Exec(fmt.Sprintf("UPDATE %v SET last_height=? WHERE pk=1;", infoTable), height)
+	return err
+}
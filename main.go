@@ -6,6 +6,7 @@ package main
 
 import (
 	"log"
+	"context"
 	"fmt"
 	"os"
 	"time"
@@ -16,11 +17,16 @@ import (
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/chaincfg"
 
+	"github.com/secnot/gobalance/bitcoind"
 	"github.com/secnot/gobalance/crawler"
+	"github.com/secnot/gobalance/events"
 	"github.com/secnot/gobalance/peers"
 	"github.com/secnot/gobalance/block_manager"
 	"github.com/secnot/gobalance/block_manager/storage"
 	"github.com/secnot/gobalance/recent_tx"
+	"github.com/secnot/gobalance/history"
+	"github.com/secnot/gobalance/mempool"
+	"github.com/secnot/gobalance/notify"
 	"github.com/secnot/gobalance/balance"
 	"github.com/secnot/gobalance/height"
 	"github.com/secnot/gobalance/primitives"
@@ -30,45 +36,156 @@ import (
 
 const (
 	DbFilename = "utxo.db"
+
+	// Stored balances cached by the block manager, saved between runs
+	BalanceCacheFilename = "balance_cache.json"
 )
 
 
-// CleanUp gracefully stop all routines
-func CleanUp(blockM *block_manager.BlockManager, utxoStorage storage.Storage, vacuum bool) {		
-	//crawler.Stop()
-	blockM.Stop()
+// Services holds all the running routines, nil ones are ignored on CleanUp
+type Services struct {
+	RpcPool       *bitcoind.Pool
+	Storage       storage.Storage
+	Crawler       *crawler.Crawler
+	BlockM        *block_manager.BlockManager
+	PeerM         *peers.PeerManager
+	Mempool       mempool.MempoolInterface
+	BalanceCache  *balance.BalanceCache
+	HeightCache   *height.HeightCache
+	Hub           *notify.Hub
+}
+
+// CleanUp gracefully stop all routines, block updates consumers are stopped 
+// first, then crawler and block manager (committing confirmed blocks) and 
+// last storage is closed. On interrupt the root context already cancelled
+// the routines, Stop only waits until each one has exited.
+func CleanUp(s *Services, vacuum bool) {		
+	log.Print("Stopping")
+
+	if s.Mempool != nil {
+		s.Mempool.Stop()
+	}
+	if s.BalanceCache != nil {
+		s.BalanceCache.Stop()
+	}
+	if s.HeightCache != nil {
+		s.HeightCache.Stop()
+	}
+	if s.Hub != nil {
+		s.Hub.Stop()
+	}
+	if s.PeerM != nil {
+		s.PeerM.Stop()
+	}
+
+	// Stop fetching blocks before the manager so no update is lost
+	if s.Crawler != nil {
+		s.Crawler.Stop()
+	}
+	if s.BlockM != nil {
+		s.BlockM.Stop()
+	}
+	if s.RpcPool != nil {
+		s.RpcPool.Stop()
+	}
 
-	return
-	if utxoStorage != nil {
+	if s.Storage != nil {
 		if vacuum {
 			log.Print("Cleaning Up")
-			if err := utxoStorage.CleanUp(); err != nil {
+			if err := s.Storage.CleanUp(); err != nil {
 				log.Print(err)
 			}
 		}
-		utxoStorage.Close()
+		if err := s.Storage.Close(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+
+// NewCommitPolicy builds the block manager commit policy selected by config
+func NewCommitPolicy(conf map[string]interface{}, peerM *peers.PeerManager) block_manager.CommitPolicy {
+
+	commitSize := int(conf["utxo_cache_size"].(int64))
+
+	// from 0 to 119 seconds delay from the moment a commit is required and when
+	// it starts, so not many nodes start a commit at the same time
+	commitDelay := block_manager.DefaultCommitDelay + time.Duration(rand.Intn(120))*time.Second
+
+	defaultPolicy := &block_manager.DefaultCommitPolicy {
+		MaxEntries: commitSize,
+
+		// Number of "confirmed" blocks before a commit starts (when not in sync mode)
+		MinBlocks:  int(rand.Int31n(10)+1),
+		Delay:      commitDelay,
+	}
+
+	switch conf["commit.policy"].(string) {
+	case "time":
+		return &block_manager.TimeCommitPolicy {
+			Period:     time.Duration(conf["commit.period"].(int64))*time.Second,
+			MaxEntries: commitSize,
+			Delay:      commitDelay,
+		}
+	case "memory":
+		return &block_manager.MemoryCommitPolicy {
+			MaxHeap: uint64(conf["commit.max_memory"].(int64))*1024*1024,
+			Delay:   commitDelay,
+		}
+	case "peer":
+		return &block_manager.PeerCommitPolicy {
+			Policy:       defaultPolicy,
+			Peers:        peerM,
+			ForceEntries: 2*commitSize,
+		}
+	default:
+		return defaultPolicy
 	}
 }
 
 
 func main() {
 
+	// Cancelled on interrupt to exit gracefully
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	services := &Services{}
+
 	// Load default config
 	conf, err := config.LoadConfig()
 	if err != nil {
 		log.Panic(err)
 	}
 
-	// Connect to local bitcoin core RPC server using HTTP POST mode.
-	rpcConf := rpcclient.ConnConfig{
-		Host:         conf["bitcoind.host"].(string),
-		User:         conf["bitcoind.user"].(string),
-		Pass:         conf["bitcoind.pass"].(string),
-		DisableAutoReconnect: false,
-		HTTPPostMode: true, // Bitcoin core only supports HTTP POST mode
-		DisableTLS:   true, // Bitcoin core does not provide TLS by default
+	// Connect to bitcoin core RPC servers using HTTP POST mode.
+	rpcHosts := []string{conf["bitcoind.host"].(string)}
+	if hosts := conf["bitcoind.hosts"].([]interface{}); len(hosts) > 0 {
+		rpcHosts = make([]string, len(hosts))
+		for i, host := range hosts {
+			rpcHosts[i] = host.(string)
+		}
 	}
 
+	rpcConfs := make([]rpcclient.ConnConfig, len(rpcHosts))
+	for i, host := range rpcHosts {
+		rpcConfs[i] = rpcclient.ConnConfig{
+			Host:         host,
+			User:         conf["bitcoind.user"].(string),
+			Pass:         conf["bitcoind.pass"].(string),
+			DisableAutoReconnect: false,
+			HTTPPostMode: true, // Bitcoin core only supports HTTP POST mode
+			DisableTLS:   true, // Bitcoin core does not provide TLS by default
+		}
+	}
+
+	rpcPool, err := bitcoind.NewPool(rpcConfs, bitcoind.Policy(conf["bitcoind.balancing"].(string)))
+	if err != nil {
+		log.Panic(err)
+	}
+	rpcPool.HealthCheckPeriod = time.Duration(conf["bitcoind.health_check_period"].(int64))*time.Second
+	rpcPool.Start()
+	services.RpcPool = rpcPool
+
 	// Configure bitcoind server parameters
 	chain := conf["bitcoind.chain"]
 	switch chain {
@@ -91,6 +208,7 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
+	services.Storage = utxoStorage
 
 
 	// Launch Crawler
@@ -101,26 +219,8 @@ func main() {
 	}
 
 	// Start crawler but don't start fetching blocks until Start is called
-	crawlerM, _ := crawler.NewCrawler(rpcConf, uint64(lastHeight+1), lastBlockHash)
-
-	// Launch Block Manager
-	/////////////////////////
-	updateChan := crawlerM.Subscribe(10)
-
-	rand.Seed(time.Now().UnixNano())
-	blockM :=  &block_manager.BlockManager {
-		Sync:           conf["sync"].(bool),
-		Confirmations:  uint16(conf["recent_blocks"].(int64)), 
-		CommitSize:     int(conf["utxo_cache_size"].(int64)), 
-		
-		// Number of "confirmed" blocks before a commit starts (when not in sync mode)
-		CommitMinBlocks: int(rand.Int31n(10)+1),
-		
-		// from 0 to 119 seconds delay from the moment a commit is required and when it starts
-		CommitDelay:     time.Duration(rand.Intn(120))*time.Second,
-	}
-	blockM.Start(utxoStorage, updateChan)
-	
+	crawlerM, _ := crawler.NewCrawler(ctx, rpcPool, uint64(lastHeight+1), lastBlockHash)
+	services.Crawler = crawlerM
 
 	// Configure Peermanager
 	////////////////////////
@@ -142,43 +242,89 @@ func main() {
 	}
 
 
+	// Launch Block Manager
+	/////////////////////////
+	updateChan := crawlerM.Subscribe(10)
+
+	// Built-in indexers, only used by the balance API
+	indexers := []block_manager.Indexer{}
+	if !conf["sync"].(bool) {
+		indexers = append(indexers, recent_tx.NewIndexer(uint16(conf["recent_blocks"].(int64))))
+		if conf["history.enabled"].(bool) {
+			indexers = append(indexers, history.NewIndexer())
+		}
+		if conf["history.address_tx"].(bool) {
+			indexers = append(indexers, history.NewTxIndexer())
+		}
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	blockM :=  &block_manager.BlockManager {
+		Sync:           conf["sync"].(bool),
+		Confirmations:  uint16(conf["recent_blocks"].(int64)), 
+		CommitPolicy:   NewCommitPolicy(conf, peerM),
+
+		CommitOnStop:    conf["commit_on_stop"].(bool),
+		SyncMaxLag:      conf["sync_max_lag"].(int64),
+
+		SubscriberBufferSize: int(conf["events.buffer_size"].(int64)),
+		SubscriberPolicy:     events.Policy(conf["events.slow_consumer_policy"].(string)),
+
+		Indexers:     indexers,
+		SpentArchive: conf["history.spent_archive"].(bool),
+
+		StoredBalancesSize: int(conf["balance_cache_size"].(int64)),
+	}
+	if !conf["sync"].(bool) && conf["balance_cache_persist"].(bool) {
+		blockM.BalanceSnapshotPath   = filepath.Join(conf["workdir"].(string), BalanceCacheFilename)
+		blockM.BalanceSnapshotPeriod = time.Duration(conf["balance_cache_save_period"].(int64))*time.Second
+	}
+	if err := blockM.Start(ctx, utxoStorage, updateChan); err != nil {
+		log.Panic(err)
+	}
+	services.BlockM = blockM
+	
+
 	// Initialize balance API services
 	////////////////////////////////////
+	var memPool       mempool.MempoolInterface
 	var balanceCache  *balance.BalanceCache
 	var recentTxCache *recent_tx.RecentTxCache
 	var heightCache   *height.HeightCache
+	var hub           *notify.Hub
 
 	if !conf["sync"].(bool) {
 
 		// Launch peer service 
 		peerM.Start()
+		services.PeerM = peerM
+
+		// Launch mempool routines
+		if conf["mempool.enabled"].(bool) {
+			pollPeriod := time.Duration(conf["mempool.poll_period"].(int64))*time.Second
+			memPool = mempool.NewMempool(ctx, rpcPool, blockM, pollPeriod)
+		}
 
 		// Launch balance cache routine
-		balanceCache = balance.NewBalanceCache (blockM, peerM, int(conf["balance_cache_size"].(int64)))
+		balanceCache = balance.NewBalanceCache(ctx, blockM, peerM, memPool, int(conf["balance_cache_size"].(int64)))
 
-		// Launch recent transactions routine
-		recentTxCache = recent_tx.NewRecentTxCache(blockM, uint16(conf["recent_blocks"].(int64)))
+		// Recent transactions are queried from its indexer
+		recentTxCache = recent_tx.NewRecentTxCache(blockM, rpcPool)
 
 		// Launch height routine
-		heightCache = height.NewHeightCache(blockM)
-	}
-
-	// Build function used to gracefully stop all routines
-	//////////////////////////////////////////////////////
+		heightCache = height.NewHeightCache(ctx, blockM, rpcPool)
 
-	// Catch ctrl-c and exit gracefully
-	ExitHandler := func(c chan os.Signal) {
-		for sig := range c {		
-			CleanUp(blockM, utxoStorage, false)
-			log.Print("Exit: ", sig)
-			os.Exit(1)
+		// Launch address activity notifications
+		if conf["websocket.enabled"].(bool) {
+			hub = notify.NewHub(ctx, blockM, int(conf["websocket.max_connections"].(int64)),
+				int(conf["websocket.max_addresses"].(int64)))
 		}
-	}
 
-	// Catch interrupts to exit gracefully
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go ExitHandler(c)
+		services.Mempool       = memPool
+		services.BalanceCache  = balanceCache
+		services.HeightCache   = heightCache
+		services.Hub           = hub
+	}
 
 	log.Print("Started")
 
@@ -190,25 +336,54 @@ func main() {
 
 	// Initial sync
 	///////////////
-	for {
-		time.Sleep(time.Second*10)
-		if blockM.Synced() {
-			break
+	syncedCh := make(chan error, 1)
+	go func() {
+		syncedCh <- blockM.WaitSynced(ctx)
+	}()
+
+	progressTicker := time.NewTicker(time.Minute)
+	for synced := false; !synced; {
+		select {
+		case <-progressTicker.C:
+			progress, err := blockM.SyncProgress(ctx)
+			if err != nil {
+				continue
+			}
+			log.Printf("Syncing: %v/%v (%.1f blocks/s, ETA %v)", progress.Height, 
+				progress.TargetHeight, progress.BlocksPerSecond, progress.ETA.Round(time.Second))
+		case err := <-syncedCh:
+			if err != nil {
+				progressTicker.Stop()
+				CleanUp(services, false)
+				log.Print("Exit: ", err)
+				return
+			}
+			synced = true
 		}
 	}
-	log.Printf("Synced block: %v\n", blockM.GetHeight())
+	progressTicker.Stop()
+	if height, err := blockM.GetHeight(ctx); err == nil {
+		log.Printf("Synced block: %v\n", height)
+	}
 
 	// When in sync mode vacuum DB and exit
 	///////////////////////////////////////
 	if conf["sync"].(bool) {
-		CleanUp(blockM, utxoStorage, true)
+		CleanUp(services, true)
 		log.Print("Done")
-		os.Exit(1)
+		return
 	}
 
 	// Launch JSON API
 	//////////////////
 	bind := fmt.Sprint("%v:%v", conf["api.bind"].(string), conf["api.port"].(int64))
-	api.StartApi(bind, conf["api.url_prefix"].(string), balanceCache, recentTxCache, heightCache)
+	err = api.StartApi(ctx, bind, conf["api.url_prefix"].(string), balanceCache, recentTxCache, heightCache, hub)
+	if err != nil {
+		log.Print(err)
+	}
+
+	// Api stopped either by an interruption or an error
+	CleanUp(services, false)
+	log.Print("Exit")
 }
 
@@ -0,0 +1,350 @@
+package mempool
+
+import (
+	"log"
+	"time"
+	"context"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+
+	"github.com/secnot/gobalance/bitcoind"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/primitives"
+	"github.com/secnot/gobalance/primitives/queue"
+)
+
+const (
+	// Period between mempool polls
+	DefaultPollPeriod = 5*time.Second
+
+	// Max number of new transactions retrieved in a single poll, the
+	// remaining ones are retrieved in the following polls.
+	MaxTxPerPoll = 5000
+
+	// Number of blocks whose transactions are ignored if the backend still
+	// reports them in its mempool
+	ConfirmedBlocks = 3
+
+	// Max time waiting for the block manager to resolve transaction inputs
+	ResolveTimeout = 30*time.Second
+)
+
+// Mempool interface only purpose is to allow mock testing
+type MempoolInterface interface {
+	// Return the unconfirmed balance delta for an address
+	GetBalance(address string) (int64, error)
+
+	// Number of unconfirmed transactions
+	Len() int
+
+	// Safely stop mempool
+	Stop()
+}
+
+// BalanceRequest is used to request the unconfirmed balance of an address
+type BalanceRequest struct {
+	Address string
+	Resp    chan int64
+}
+
+// pollUpdate is sent by the poller with all the transactions in the backend
+// mempool, the missing ones must be returned through the missing channel.
+type pollUpdate struct {
+	hashes  []*chainhash.Hash
+	missing chan []*chainhash.Hash
+}
+
+
+type Mempool struct {
+	// bitcoind backends
+	Pool *bitcoind.Pool
+
+	// Used to resolve transaction inputs and receive block updates
+	BlockM block_manager.BlockManagerInterface
+
+	// Period between mempool polls
+	PollPeriod time.Duration
+
+	// Unconfirmed transactions
+	txs *txSet
+
+	// Transactions included in the last blocks
+	confirmed       map[chainhash.Hash]bool
+	confirmedBlocks *queue.Queue
+
+	// Poller channels
+	pollChan  chan pollUpdate
+	newTxChan chan []*wire.MsgTx
+
+	// Control channels
+	requestChan chan BalanceRequest
+	lenChan     chan chan int
+
+	// Cancels the routines context, done is closed once they have exited
+	cancel context.CancelFunc
+	done   chan bool
+}
+
+// NewMempool initializes and starts mempool routines, they run until ctx is
+// cancelled or Stop is called.
+func NewMempool(ctx context.Context, pool *bitcoind.Pool, blockM block_manager.BlockManagerInterface,
+				pollPeriod time.Duration) *Mempool {
+
+	if pollPeriod <= 0 {
+		pollPeriod = DefaultPollPeriod
+	}
+
+	m := &Mempool {
+		Pool:            pool,
+		BlockM:          blockM,
+		PollPeriod:      pollPeriod,
+		txs:             newTxSet(),
+		confirmed:       make(map[chainhash.Hash]bool),
+		confirmedBlocks: queue.New(),
+		pollChan:        make(chan pollUpdate),
+		newTxChan:       make(chan []*wire.MsgTx),
+		requestChan:     make(chan BalanceRequest, 100),
+		lenChan:         make(chan chan int),
+		done:            make(chan bool),
+	}
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	go m.poller(ctx)
+	go m.mempoolRoutine(ctx)
+	return m
+}
+
+// poller periodically requests the backend mempool, and retrieves the
+// transactions the mempool routine is missing.
+func (m *Mempool) poller(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.PollPeriod):
+		}
+
+		hashes, err := m.Pool.RawMempool()
+		if err != nil {
+			continue
+		}
+
+		update := pollUpdate{hashes: hashes, missing: make(chan []*chainhash.Hash)}
+		select {
+		case <-ctx.Done():
+			return
+		case m.pollChan <- update:
+		}
+		missing := <-update.missing
+		if len(missing) > MaxTxPerPoll {
+			missing = missing[:MaxTxPerPoll]
+		}
+
+		txs := make([]*wire.MsgTx, 0, len(missing))
+		for _, hash := range missing {
+			tx, err := m.Pool.RawTransaction(hash)
+			if err != nil {
+				continue // Probably evicted or mined since the poll
+			}
+			txs = append(txs, tx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case m.newTxChan <- txs:
+		}
+	}
+}
+
+// processPoll returns the hashes not yet in the mempool or confirmed, and
+// removes evicted transactions (the ones not in hashes)
+func (m *Mempool) processPoll(hashes []*chainhash.Hash) []*chainhash.Hash {
+
+	current := make(map[chainhash.Hash]bool, len(hashes))
+	missing := make([]*chainhash.Hash, 0)
+	for _, hash := range hashes {
+		current[*hash] = true
+		if !m.txs.Contains(*hash) && !m.confirmed[*hash] {
+			missing = append(missing, hash)
+		}
+	}
+
+	// Remove evicted transactions
+	evicted := make([]chainhash.Hash, 0)
+	for hash, _ := range m.txs.txs {
+		if !current[hash] {
+			evicted = append(evicted, hash)
+		}
+	}
+	for _, hash := range evicted {
+		m.txs.Remove(hash, false)
+	}
+
+	return missing
+}
+
+// addTxs resolves the inputs of new transactions and adds them to the mempool
+func (m *Mempool) addTxs(ctx context.Context, wireTxs []*wire.MsgTx) {
+
+	newTxs := make(map[chainhash.Hash]*primitives.Tx, len(wireTxs))
+	for _, wireTx := range wireTxs {
+		tx := primitives.NewTxFromMsgTx(wireTx)
+		if m.txs.Contains(*tx.Hash) || m.confirmed[*tx.Hash] {
+			continue
+		}
+		newTxs[*tx.Hash] = tx
+	}
+
+	// Inputs from unconfirmed transactions are resolved locally, and the
+	// remaining through block manager pending blocks and storage.
+	unresolved := make([]*primitives.TxOut, 0)
+	for _, tx := range newTxs {
+		for _, in := range tx.In {
+			parent := m.txs.Tx(*in.TxHash)
+			if parent == nil {
+				parent = newTxs[*in.TxHash]
+			}
+
+			if parent != nil && int(in.Nout) < len(parent.Out) {
+				in.Addr  = parent.Out[in.Nout].Addr
+				in.Value = parent.Out[in.Nout].Value
+			} else {
+				unresolved = append(unresolved, in)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ResolveTimeout)
+	defer cancel()
+	if err := m.BlockM.ResolveTxOuts(ctx, unresolved); err != nil {
+		log.Print("Mempool: ", err)
+		return
+	}
+
+	missing := 0
+	for _, in := range unresolved {
+		if in.Addr == "" {
+			missing++
+		}
+	}
+
+	for _, tx := range newTxs {
+		m.txs.Add(tx)
+	}
+
+	// They are excluded from balances until their parent arrives
+	if missing > 0 {
+		log.Printf("Mempool: %v new inputs with unknown parent, %v waiting", missing, m.txs.Unresolved())
+	}
+}
+
+// newBlock removes block transactions and conflicts from mempool
+func (m *Mempool) newBlock(block *primitives.Block) {
+	m.txs.RemoveBlock(block)
+
+	// Remember confirmed transactions in case they are still reported
+	for _, tx := range block.Transactions {
+		m.confirmed[*tx.Hash] = true
+	}
+	m.confirmedBlocks.PushBack(block)
+
+	if m.confirmedBlocks.Len() > ConfirmedBlocks {
+		m.forgetBlock(m.confirmedBlocks.PopFront().(*primitives.Block))
+	}
+}
+
+// backtrackBlock the transactions in the block will return to the backend mempool
+func (m *Mempool) backtrackBlock(block *primitives.Block) {
+	if m.confirmedBlocks.Len() > 0 {
+		m.confirmedBlocks.PopBack()
+	}
+	m.forgetBlock(block)
+}
+
+// forgetBlock discards a block transactions from confirmed
+func (m *Mempool) forgetBlock(block *primitives.Block) {
+	for _, tx := range block.Transactions {
+		delete(m.confirmed, *tx.Hash)
+	}
+}
+
+// mempoolRoutine handles poll results, block updates and balance requests
+func (m *Mempool) mempoolRoutine(ctx context.Context) {
+
+	updateChan := m.BlockM.Subscribe("mempool", 100)
+
+	for {
+		select {
+		case update, ok := <-updateChan:
+			if !ok { // Manager stopped
+				updateChan = nil
+				continue
+			}
+			switch update.Class {
+			case block_manager.OP_NEWBLOCK:
+				m.newBlock(update.Block)
+			case block_manager.OP_BACKTRACK:
+				m.backtrackBlock(update.Block)
+			}
+
+		case poll := <-m.pollChan:
+			poll.missing <- m.processPoll(poll.hashes)
+
+		case txs := <-m.newTxChan:
+			m.addTxs(ctx, txs)
+
+		case request := <-m.requestChan:
+			request.Resp <- m.txs.GetBalance(request.Address)
+
+		case ch := <-m.lenChan:
+			ch <- m.txs.Len()
+
+		case <-ctx.Done():
+			m.BlockM.Unsubscribe(updateChan)
+			close(m.done)
+			return
+		}
+	}
+}
+
+// GetBalance returns the unconfirmed balance delta for an address
+func (m *Mempool) GetBalance(address string) (int64, error) {
+	responseCh := make(chan int64, 1)
+	select {
+	case m.requestChan <- BalanceRequest{Address: address, Resp: responseCh}:
+	case <-m.done:
+		return 0, block_manager.ErrStopped
+	}
+
+	select {
+	case balance := <-responseCh:
+		return balance, nil
+	case <-m.done:
+		return 0, block_manager.ErrStopped
+	}
+}
+
+// Len returns the number of unconfirmed transactions (0 once stopped)
+func (m *Mempool) Len() int {
+	responseCh := make(chan int, 1)
+	select {
+	case m.lenChan <- responseCh:
+	case <-m.done:
+		return 0
+	}
+
+	select {
+	case length := <-responseCh:
+		return length
+	case <-m.done:
+		return 0
+	}
+}
+
+// Stop cancels mempool routines and waits until they have exited
+func (m *Mempool) Stop() {
+	m.cancel()
+	<-m.done
+}
@@ -0,0 +1,205 @@
+package mempool
+
+import (
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/primitives"
+	"github.com/secnot/gobalance/block_manager/storage"
+)
+
+// txSet keeps the unconfirmed transactions together with the outputs they
+// spend and the balance delta they generate for each address.
+type txSet struct {
+
+	// Unconfirmed transactions with their inputs populated
+	txs map[chainhash.Hash]*primitives.Tx
+
+	// Outputs spent by unconfirmed transactions -> spending transaction
+	spent map[storage.TxOutId]chainhash.Hash
+
+	// Accumulated unconfirmed balance delta by address
+	balance map[string]int64
+
+	// Parent transactions not found when their outputs were resolved ->
+	// children with inputs waiting for them.
+	waiting map[chainhash.Hash]map[chainhash.Hash]bool
+
+	// Number of inputs waiting for their parent
+	unresolved int
+}
+
+func newTxSet() *txSet {
+	return &txSet{
+		txs:     make(map[chainhash.Hash]*primitives.Tx),
+		spent:   make(map[storage.TxOutId]chainhash.Hash),
+		balance: make(map[string]int64),
+		waiting: make(map[chainhash.Hash]map[chainhash.Hash]bool),
+	}
+}
+
+// Len returns the number of unconfirmed transactions
+func (s *txSet) Len() int {
+	return len(s.txs)
+}
+
+// Contains returns true if the transaction is in the set
+func (s *txSet) Contains(hash chainhash.Hash) bool {
+	_, ok := s.txs[hash]
+	return ok
+}
+
+// Tx returns an unconfirmed transaction or nil
+func (s *txSet) Tx(hash chainhash.Hash) *primitives.Tx {
+	return s.txs[hash]
+}
+
+// Unresolved returns the number of inputs whose parent transaction is unknown,
+// they are excluded from the balance until it arrives.
+func (s *txSet) Unresolved() int {
+	return s.unresolved
+}
+
+// updateBalance
+func (s *txSet) updateBalance(address string, balance int64) {
+	newBalance := s.balance[address] + balance
+	if newBalance == 0 {
+		delete(s.balance, address)
+	} else {
+		s.balance[address] = newBalance
+	}
+}
+
+func (s *txSet) addBalance(address string, balance int64, tx *primitives.Tx) {
+	s.updateBalance(address, balance)
+}
+
+func (s *txSet) remBalance(address string, balance int64, tx *primitives.Tx) {
+	s.updateBalance(address, -balance)
+}
+
+// Add inserts a transaction with its inputs already populated, the inputs
+// without address whose parent is unknown are populated once it is added.
+func (s *txSet) Add(tx *primitives.Tx) {
+	if s.Contains(*tx.Hash) {
+		return
+	}
+
+	s.txs[*tx.Hash] = tx
+	for _, in := range tx.In {
+		s.spent[storage.TxOutId{TxHash: *in.TxHash, Nout: in.Nout}] = *tx.Hash
+		if in.Addr == "" && !s.Contains(*in.TxHash) {
+			if s.waiting[*in.TxHash] == nil {
+				s.waiting[*in.TxHash] = make(map[chainhash.Hash]bool)
+			}
+			s.waiting[*in.TxHash][*tx.Hash] = true
+			s.unresolved++
+		}
+	}
+	tx.ForEachAddress(s.addBalance)
+
+	s.resolveWaiting(tx)
+}
+
+// resolveWaiting populates the inputs of the transactions waiting for parent,
+// and subtracts them from the balance.
+func (s *txSet) resolveWaiting(parent *primitives.Tx) {
+	children, ok := s.waiting[*parent.Hash]
+	if !ok {
+		return
+	}
+	delete(s.waiting, *parent.Hash)
+
+	for hash := range children {
+		child, ok := s.txs[hash]
+		if !ok {
+			continue
+		}
+		for _, in := range child.In {
+			if *in.TxHash != *parent.Hash || in.Addr != "" {
+				continue
+			}
+			s.unresolved--
+			if int(in.Nout) >= len(parent.Out) || parent.Out[in.Nout].Addr == "" {
+				continue
+			}
+			in.Addr  = parent.Out[in.Nout].Addr
+			in.Value = parent.Out[in.Nout].Value
+			s.updateBalance(in.Addr, -in.Value)
+		}
+	}
+}
+
+// forgetWaiting removes the inputs of a transaction still waiting for
+// their parent.
+func (s *txSet) forgetWaiting(tx *primitives.Tx) {
+	for _, in := range tx.In {
+		if in.Addr == "" && s.waiting[*in.TxHash][*tx.Hash] {
+			s.unresolved--
+		}
+	}
+	for _, in := range tx.In {
+		if children, ok := s.waiting[*in.TxHash]; ok && in.Addr == "" {
+			delete(children, *tx.Hash)
+			if len(children) == 0 {
+				delete(s.waiting, *in.TxHash)
+			}
+		}
+	}
+}
+
+// Remove deletes a transaction, if descendants is true the transactions
+// spending its outputs are also removed (they are no longer valid).
+func (s *txSet) Remove(hash chainhash.Hash, descendants bool) {
+	tx, ok := s.txs[hash]
+	if !ok {
+		return
+	}
+
+	delete(s.txs, hash)
+	for _, in := range tx.In {
+		id := storage.TxOutId{TxHash: *in.TxHash, Nout: in.Nout}
+		if s.spent[id] == hash {
+			delete(s.spent, id)
+		}
+	}
+	s.forgetWaiting(tx)
+	tx.ForEachAddress(s.remBalance)
+
+	if !descendants {
+		return
+	}
+
+	for _, out := range tx.Out {
+		id := storage.TxOutId{TxHash: hash, Nout: out.Nout}
+		if child, ok := s.spent[id]; ok {
+			s.Remove(child, true)
+		}
+	}
+}
+
+// RemoveBlock removes the transactions included in a block, and the ones
+// conflicting with it (spending the same outputs) with their descendants.
+func (s *txSet) RemoveBlock(block *primitives.Block) {
+	for _, tx := range block.Transactions {
+
+		// Included in the block, its descendants are still valid and may
+		// be waiting for its outputs.
+		s.Remove(*tx.Hash, false)
+		s.resolveWaiting(tx)
+
+		// Double spends
+		if tx.IsCoinBase() {
+			continue
+		}
+		for _, in := range tx.In {
+			id := storage.TxOutId{TxHash: *in.TxHash, Nout: in.Nout}
+			if conflict, ok := s.spent[id]; ok && conflict != *tx.Hash {
+				s.Remove(conflict, true)
+			}
+		}
+	}
+}
+
+// GetBalance returns the unconfirmed balance delta for an address
+func (s *txSet) GetBalance(address string) int64 {
+	return s.balance[address]
+}
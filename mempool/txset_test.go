@@ -0,0 +1,126 @@
+package mempool
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/primitives"
+)
+
+// mockTx creates a transaction spending a single output
+func mockTx(id byte, spends *chainhash.Hash, nout uint32, from string, to string, value int64) *primitives.Tx {
+	hash := chainhash.Hash{id}
+	tx := primitives.NewTx(&hash)
+	tx.AddIn(primitives.NewTxOut(spends, nout, from, value))
+	tx.AddOut(primitives.NewTxOut(&hash, 0, to, value))
+	return tx
+}
+
+// Test balance is updated when transactions are added and removed
+func TestTxSetBalance(t *testing.T) {
+	set := newTxSet()
+	confirmed := chainhash.Hash{100}
+
+	parent := mockTx(1, &confirmed, 0, "addr1", "addr2", 1000)
+	child  := mockTx(2, parent.Hash, 0, "addr2", "addr3", 1000)
+	set.Add(parent)
+	set.Add(child)
+
+	if set.Len() != 2 {
+		t.Errorf("Len(): Expecting 2 returned %v", set.Len())
+	}
+	if set.GetBalance("addr1") != -1000 || set.GetBalance("addr2") != 0 ||
+		set.GetBalance("addr3") != 1000 {
+		t.Errorf("GetBalance(): Unexpected balance")
+	}
+
+	// Removing without descendants keeps the child
+	set.Remove(*parent.Hash, false)
+	if set.Len() != 1 || !set.Contains(*child.Hash) {
+		t.Errorf("Remove(): Child transaction should have been kept")
+	}
+	if set.GetBalance("addr1") != 0 || set.GetBalance("addr2") != -1000 {
+		t.Errorf("Remove(): Unexpected balance")
+	}
+
+	set.Remove(*child.Hash, false)
+	if set.Len() != 0 || len(set.balance) != 0 || len(set.spent) != 0 {
+		t.Errorf("Remove(): Set should be empty")
+	}
+}
+
+// Test block inclusion removes double spends and their descendants
+func TestTxSetRemoveBlock(t *testing.T) {
+	set := newTxSet()
+	confirmed := chainhash.Hash{100}
+
+	tx1   := mockTx(1, &confirmed, 0, "addr1", "addr2", 1000)
+	child := mockTx(2, tx1.Hash, 0, "addr2", "addr3", 1000)
+	other := mockTx(3, &confirmed, 1, "addr1", "addr4", 500)
+	set.Add(tx1)
+	set.Add(child)
+	set.Add(other)
+
+	// Block includes a transaction spending the same output as tx1
+	double := mockTx(4, &confirmed, 0, "addr1", "addr5", 1000)
+	block := primitives.NewBlock(chainhash.Hash{200}, chainhash.Hash{199}, 10)
+	block.AddTx(double)
+	block.AddTx(other)
+	set.RemoveBlock(block)
+
+	if set.Len() != 0 {
+		t.Errorf("RemoveBlock(): Expecting empty set, %v remaining", set.Len())
+	}
+	for _, addr := range []string{"addr1", "addr2", "addr3", "addr4"} {
+		if set.GetBalance(addr) != 0 {
+			t.Errorf("RemoveBlock(): Unexpected %v balance %v", addr, set.GetBalance(addr))
+		}
+	}
+}
+
+// unresolvedTx creates a transaction spending an output with unknown address
+func unresolvedTx(id byte, spends *chainhash.Hash, to string, value int64) *primitives.Tx {
+	tx := mockTx(id, spends, 0, "", to, value)
+	tx.In[0].Value = 0
+	return tx
+}
+
+// Test inputs with unknown parent are resolved once it is added or mined
+func TestTxSetUnresolved(t *testing.T) {
+	set := newTxSet()
+	confirmed := chainhash.Hash{100}
+
+	parent := mockTx(1, &confirmed, 0, "addr1", "addr2", 1000)
+	child  := unresolvedTx(2, parent.Hash, "addr3", 1000)
+	set.Add(child)
+	if set.Unresolved() != 1 || set.GetBalance("addr2") != 0 || set.GetBalance("addr3") != 1000 {
+		t.Errorf("Add(): Expecting child input unresolved")
+	}
+
+	set.Add(parent)
+	if set.Unresolved() != 0 || child.In[0].Addr != "addr2" || child.In[0].Value != 1000 {
+		t.Errorf("Add(): Expecting child input resolved %+v", child.In[0])
+	}
+	if set.GetBalance("addr1") != -1000 || set.GetBalance("addr2") != 0 || set.GetBalance("addr3") != 1000 {
+		t.Errorf("Add(): Unexpected balance after parent added")
+	}
+
+	// Parent mined while the child waits
+	other := mockTx(3, &confirmed, 1, "addr1", "addr4", 500)
+	grandchild := unresolvedTx(4, other.Hash, "addr5", 500)
+	set.Add(grandchild)
+	block := primitives.NewBlock(chainhash.Hash{200}, chainhash.Hash{199}, 10)
+	block.AddTx(other)
+	set.RemoveBlock(block)
+	if set.Unresolved() != 0 || set.GetBalance("addr4") != -500 || set.GetBalance("addr5") != 500 {
+		t.Errorf("RemoveBlock(): Expecting grandchild input resolved")
+	}
+
+	// Removing a waiting transaction forgets its inputs
+	orphan := unresolvedTx(5, &chainhash.Hash{101}, "addr6", 10)
+	set.Add(orphan)
+	set.Remove(*orphan.Hash, false)
+	if set.Unresolved() != 0 || len(set.waiting) != 0 {
+		t.Errorf("Remove(): Expecting no waiting inputs")
+	}
+}
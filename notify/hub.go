@@ -0,0 +1,316 @@
+/*
+notify pushes the activity of subscribed addresses to its clients, the events
+are built from the block manager update stream.
+*/
+package notify
+
+import (
+	"log"
+	"errors"
+	"context"
+
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/primitives"
+)
+
+const (
+	// Number of event messages buffered for each client, clients that fall
+	// further behind are disconnected.
+	ClientQueueSize = 32
+
+	// Number of block updates buffered from the block manager
+	UpdateQueueSize = 10
+)
+
+var (
+	ErrTooManyClients   = errors.New("notify: Too many clients")
+	ErrTooManyAddresses = errors.New("notify: Too many addresses")
+	ErrStopped          = errors.New("notify: Hub stopped")
+)
+
+// Client is a hub subscriber, its events channel is closed when it is
+// unregistered or disconnected for being too slow.
+type Client struct {
+	events chan []api_common.Event
+
+	// Subscribed addresses, only accessed from the hub routine
+	addresses map[string]struct{}
+}
+
+// Events returns the channel where the client events are sent, one message
+// for each block update involving its addresses.
+func (c *Client) Events() <-chan []api_common.Event {
+	return c.events
+}
+
+type requestOp int
+
+const (
+	opRegister requestOp = iota
+	opUnregister
+	opSubscribe
+	opUnsubscribe
+)
+
+type hubRequest struct {
+	op        requestOp
+	client    *Client
+	addresses []string
+	resp      chan error
+}
+
+// Hub keeps the clients subscriptions and sends them the events for their
+// addresses on each new block or backtrack.
+type Hub struct {
+	manager block_manager.BlockManagerInterface
+
+	// Max number of clients and addresses subscribed by each client
+	MaxClients   int
+	MaxAddresses int
+
+	clients   map[*Client]struct{}
+	addresses map[string]map[*Client]struct{}
+
+	requestChan chan hubRequest
+
+	// Cancels the hub context, done is closed once the routine has exited
+	cancel      context.CancelFunc
+	done        chan bool
+}
+
+// NewHub initializes and starts a hub, it runs until ctx is cancelled or Stop
+// is called.
+func NewHub(ctx context.Context, manager block_manager.BlockManagerInterface, maxClients int, maxAddresses int) *Hub {
+	ctx, cancel := context.WithCancel(ctx)
+	hub := &Hub {
+		manager:      manager,
+		MaxClients:   maxClients,
+		MaxAddresses: maxAddresses,
+		clients:      make(map[*Client]struct{}),
+		addresses:    make(map[string]map[*Client]struct{}),
+		requestChan:  make(chan hubRequest),
+		cancel:       cancel,
+		done:         make(chan bool),
+	}
+
+	go hub.hubRoutine(ctx)
+	return hub
+}
+
+// hubRoutine handles block updates and client requests until the context is
+// cancelled
+func (h *Hub) hubRoutine(ctx context.Context) {
+
+	updateChan := h.manager.SubscribeExternal("notify", UpdateQueueSize)
+
+	for {
+		select {
+		case update, ok := <- updateChan:
+			if !ok {
+				updateChan = h.resubscribe(ctx)
+				continue
+			}
+			switch update.Class {
+			case block_manager.OP_NEWBLOCK:
+				h.notify(update.Block, false)
+			case block_manager.OP_BACKTRACK:
+				h.notify(update.Block, true)
+			}
+
+		case request := <- h.requestChan:
+			request.resp <- h.handleRequest(request)
+
+		case <- ctx.Done():
+			h.manager.Unsubscribe(updateChan)
+			for client := range h.clients {
+				h.remove(client)
+			}
+			close(h.done)
+			return
+		}
+	}
+}
+
+// resubscribe is called when the update channel is closed, either the manager
+// stopped or the hub was disconnected for being too slow. In the latter case
+// the clients missed some events so they are disconnected, and a new channel
+// is returned.
+func (h *Hub) resubscribe(ctx context.Context) block_manager.UpdateChan {
+	if _, err := h.manager.GetHeight(ctx); err != nil {
+		return nil // Manager stopped
+	}
+
+	log.Print("notify: Disconnected from the block manager, dropping clients")
+	for client := range h.clients {
+		h.remove(client)
+	}
+	return h.manager.SubscribeExternal("notify", UpdateQueueSize)
+}
+
+// handleRequest applies a client request
+func (h *Hub) handleRequest(request hubRequest) error {
+	client := request.client
+	if _, ok := h.clients[client]; !ok && request.op != opRegister {
+		return nil // Already removed
+	}
+
+	switch request.op {
+	case opRegister:
+		if len(h.clients) >= h.MaxClients {
+			return ErrTooManyClients
+		}
+		h.clients[client] = struct{}{}
+
+	case opUnregister:
+		h.remove(client)
+
+	case opSubscribe:
+		added := 0
+		for _, addr := range request.addresses {
+			if _, ok := client.addresses[addr]; !ok {
+				added++
+			}
+		}
+		if len(client.addresses)+added > h.MaxAddresses {
+			return ErrTooManyAddresses
+		}
+		for _, addr := range request.addresses {
+			client.addresses[addr] = struct{}{}
+			if h.addresses[addr] == nil {
+				h.addresses[addr] = make(map[*Client]struct{})
+			}
+			h.addresses[addr][client] = struct{}{}
+		}
+
+	case opUnsubscribe:
+		for _, addr := range request.addresses {
+			h.unsubscribe(client, addr)
+		}
+	}
+	return nil
+}
+
+// unsubscribe removes a single client address
+func (h *Hub) unsubscribe(client *Client, addr string) {
+	delete(client.addresses, addr)
+	if clients, ok := h.addresses[addr]; ok {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(h.addresses, addr)
+		}
+	}
+}
+
+// remove unsubscribes all client addresses and closes its channel
+func (h *Hub) remove(client *Client) {
+	for addr := range client.addresses {
+		h.unsubscribe(client, addr)
+	}
+	delete(h.clients, client)
+	close(client.events)
+}
+
+// notify sends the events for a connected or disconnected block to the
+// clients subscribed to any of its addresses.
+func (h *Hub) notify(block *primitives.Block, backtrack bool) {
+	if len(h.addresses) == 0 {
+		return
+	}
+
+	txType, sign := api_common.EventTx, int64(1)
+	if backtrack {
+		txType, sign = api_common.EventTxRemoved, -1
+	}
+
+	events := make(map[*Client][]api_common.Event)
+	deltas := make(map[string]int64)
+	for _, tx := range block.Transactions {
+		tx.ForEachAddress(func(addr string, balance int64, tx *primitives.Tx) {
+			clients, ok := h.addresses[addr]
+			if !ok {
+				return
+			}
+			deltas[addr] += balance
+			event := api_common.Event {
+				Type:      txType,
+				Address:   addr,
+				TxHash:    tx.Hash.String(),
+				Delta:     sign*balance,
+				Height:    int64(block.Height),
+				BlockHash: block.Hash.String(),
+			}
+			for client := range clients {
+				events[client] = append(events[client], event)
+			}
+		})
+	}
+
+	for addr, delta := range deltas {
+		event := api_common.Event {
+			Type:      api_common.EventBalance,
+			Address:   addr,
+			Delta:     sign*delta,
+			Height:    int64(block.Height),
+			BlockHash: block.Hash.String(),
+		}
+		for client := range h.addresses[addr] {
+			events[client] = append(events[client], event)
+		}
+	}
+
+	for client, clientEvents := range events {
+		select {
+		case client.events <- clientEvents:
+		default:
+			h.remove(client) // Too slow
+		}
+	}
+}
+
+// request sends a request to the hub routine and waits for the response
+func (h *Hub) request(op requestOp, client *Client, addresses []string) error {
+	resp := make(chan error, 1)
+	select {
+	case h.requestChan <- hubRequest{op: op, client: client, addresses: addresses, resp: resp}:
+	case <- h.done:
+		return ErrStopped
+	}
+	return <-resp
+}
+
+// Register returns a new client, fails with ErrTooManyClients when the max
+// number of clients is reached.
+func (h *Hub) Register() (*Client, error) {
+	client := &Client {
+		events:    make(chan []api_common.Event, ClientQueueSize),
+		addresses: make(map[string]struct{}),
+	}
+	if err := h.request(opRegister, client, nil); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Unregister removes the client and closes its events channel
+func (h *Hub) Unregister(client *Client) {
+	h.request(opUnregister, client, nil)
+}
+
+// Subscribe adds addresses to the client subscriptions, none is added if the
+// client would exceed MaxAddresses.
+func (h *Hub) Subscribe(client *Client, addresses []string) error {
+	return h.request(opSubscribe, client, addresses)
+}
+
+// Unsubscribe removes addresses from the client subscriptions
+func (h *Hub) Unsubscribe(client *Client, addresses []string) error {
+	return h.request(opUnsubscribe, client, addresses)
+}
+
+// Stop cancels the hub and waits until it has exited, all the clients are
+// disconnected.
+func (h *Hub) Stop() {
+	h.cancel()
+	<-h.done
+}
@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/api/common"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/primitives"
+)
+
+// mockManager sends the updates written to updates, other methods aren't used
+type mockManager struct {
+	block_manager.BlockManagerInterface
+	updates block_manager.UpdateChan
+
+	// Number of subscriptions, and GetHeight fails once stopped
+	subscriptions int
+	stopped       bool
+}
+
+func (m *mockManager) SubscribeExternal(name string, chanSize uint) block_manager.UpdateChan {
+	m.subscriptions++
+	return m.updates
+}
+
+func (m *mockManager) GetHeight(ctx context.Context) (int64, error) {
+	if m.stopped {
+		return -1, block_manager.ErrStopped
+	}
+	return 10, nil
+}
+
+func (m *mockManager) Unsubscribe(ch block_manager.UpdateChan) {}
+
+// mockBlock returns a block where addr1 sends 30 to addr2
+func mockBlock(height uint64) *primitives.Block {
+	hash := chainhash.Hash{byte(height), 1}
+	tx := primitives.NewTx(&hash)
+	tx.AddIn(primitives.NewTxOut(&chainhash.Hash{0xa0}, 0, "addr1", 100))
+	tx.AddOut(primitives.NewTxOut(&hash, 0, "addr2", 30))
+	tx.AddOut(primitives.NewTxOut(&hash, 1, "addr1", 70))
+	block := primitives.NewBlock(chainhash.Hash{byte(height)}, primitives.ZeroHash, height)
+	block.AddTx(tx)
+	return block
+}
+
+// receive waits for the next client message
+func receive(t *testing.T, client *Client) []api_common.Event {
+	select {
+	case events := <-client.Events():
+		return events
+	case <-time.After(time.Second):
+		t.Fatal("Events(): Timeout waiting for events")
+	}
+	return nil
+}
+
+func TestHubEvents(t *testing.T) {
+	manager := &mockManager{updates: make(block_manager.UpdateChan, 10)}
+	hub := NewHub(context.Background(), manager, 1, 2)
+	defer hub.Stop()
+
+	client, err := hub.Register()
+	if err != nil {
+		t.Fatal("Register(): ", err)
+	}
+	if _, err := hub.Register(); err != ErrTooManyClients {
+		t.Errorf("Register(): Expecting ErrTooManyClients returned %v", err)
+	}
+	if err := hub.Subscribe(client, []string{"addr1", "addr3", "addr4"}); err != ErrTooManyAddresses {
+		t.Errorf("Subscribe(): Expecting ErrTooManyAddresses returned %v", err)
+	}
+	if err := hub.Subscribe(client, []string{"addr1"}); err != nil {
+		t.Fatal("Subscribe(): ", err)
+	}
+
+	block := mockBlock(1)
+	manager.updates <- block_manager.NewBlockUpdate(block_manager.OP_NEWBLOCK, block)
+	events := receive(t, client)
+	if len(events) != 2 || events[0].Type != api_common.EventTx || events[0].Delta != -30 ||
+		events[1].Type != api_common.EventBalance || events[1].Delta != -30 || events[1].Height != 1 {
+		t.Errorf("Events(): Unexpected new block events %+v", events)
+	}
+
+	manager.updates <- block_manager.NewBlockUpdate(block_manager.OP_BACKTRACK, block)
+	events = receive(t, client)
+	if len(events) != 2 || events[0].Type != api_common.EventTxRemoved || events[1].Delta != 30 {
+		t.Errorf("Events(): Unexpected backtrack events %+v", events)
+	}
+
+	// Unsubscribed addresses receive no events
+	hub.Unsubscribe(client, []string{"addr1"})
+	hub.Subscribe(client, []string{"addr2"})
+	manager.updates <- block_manager.NewBlockUpdate(block_manager.OP_NEWBLOCK, mockBlock(2))
+	events = receive(t, client)
+	if len(events) != 2 || events[0].Address != "addr2" || events[1].Address != "addr2" || events[0].Height != 2 {
+		t.Errorf("Events(): Unexpected events %+v", events)
+	}
+
+	// The client can reconnect once removed
+	hub.Unregister(client)
+	if _, ok := <-client.Events(); ok {
+		t.Errorf("Unregister(): Expecting events channel closed")
+	}
+	if _, err := hub.Register(); err != nil {
+		t.Errorf("Register(): Expecting client removed %v", err)
+	}
+}
+
+// Test clients are disconnected when their queue is full
+func TestHubSlowClient(t *testing.T) {
+	hub := &Hub {
+		MaxClients:   1,
+		MaxAddresses: 1,
+		clients:      make(map[*Client]struct{}),
+		addresses:    make(map[string]map[*Client]struct{}),
+	}
+	client := &Client {
+		events:    make(chan []api_common.Event, ClientQueueSize),
+		addresses: make(map[string]struct{}),
+	}
+	hub.handleRequest(hubRequest{op: opRegister, client: client})
+	hub.handleRequest(hubRequest{op: opSubscribe, client: client, addresses: []string{"addr1"}})
+
+	for height := uint64(0); height <= ClientQueueSize; height++ {
+		hub.notify(mockBlock(height), false)
+	}
+	for n := 0; n < ClientQueueSize; n++ {
+		<-client.Events()
+	}
+	if _, ok := <-client.Events(); ok {
+		t.Errorf("notify(): Expecting slow client disconnected")
+	}
+	if len(hub.clients) != 0 || len(hub.addresses) != 0 {
+		t.Errorf("notify(): Expecting slow client removed")
+	}
+}
+
+// Test clients are dropped and the hub subscribes again when disconnected by
+// the manager
+func TestHubResubscribe(t *testing.T) {
+	manager := &mockManager{updates: make(block_manager.UpdateChan, 10)}
+	hub := &Hub {
+		manager:      manager,
+		MaxClients:   1,
+		MaxAddresses: 1,
+		clients:      make(map[*Client]struct{}),
+		addresses:    make(map[string]map[*Client]struct{}),
+	}
+	client := &Client {
+		events:    make(chan []api_common.Event, ClientQueueSize),
+		addresses: make(map[string]struct{}),
+	}
+	hub.handleRequest(hubRequest{op: opRegister, client: client})
+
+	if ch := hub.resubscribe(context.Background()); ch == nil || manager.subscriptions != 1 {
+		t.Errorf("resubscribe(): Expecting a new subscription")
+	}
+	if _, ok := <-client.Events(); ok || len(hub.clients) != 0 {
+		t.Errorf("resubscribe(): Expecting clients disconnected")
+	}
+
+	manager.stopped = true
+	if ch := hub.resubscribe(context.Background()); ch != nil || manager.subscriptions != 1 {
+		t.Errorf("resubscribe(): Expecting no subscription once the manager stopped")
+	}
+}
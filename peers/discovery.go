@@ -47,6 +47,12 @@ const (
 
 	// Announcement from another peer (AnnouncementData struct include with data)
 	PeerAnnouncementMsg
+
+	// Set local committing status (bool with data)
+	SetCommittingMsg
+
+	// Request for the number of full peers committing (channel for the response with data)
+	CommittingPeersRequestMsg
 )
 
 func (d discoveryMsgType) String() string{
@@ -60,6 +66,10 @@ func (d discoveryMsgType) String() string{
 			return "DeletePeerMsg"
 		case StopHandlerMsg:
 			return "StopHandlerMsg"
+		case SetCommittingMsg:
+			return "SetCommittingMsg"
+		case CommittingPeersRequestMsg:
+			return "CommittingPeersRequestMsg"
 	}
 	return ""
 }
@@ -148,6 +158,9 @@ type PeerHandler struct {
 	// Pointer to http server handling peer requests (for closing on exit)
 	httpServer *http.Server
 
+	// This node is committing blocks
+	committing bool
+
 	// Started flag
 	started bool
 }
@@ -215,6 +228,20 @@ func (p *PeerHandler) MarkPeerUnreachable(hostname string) {
 	p.commandCh <- NewDiscoveryMsg(UnreachablePeerMsg, hostname)
 }
 
+// SetCommitting sets the committing flag reported to other peers
+func (p *PeerHandler) SetCommitting(flag bool) {
+	p.commandCh <- NewDiscoveryMsg(SetCommittingMsg, flag)
+}
+
+// CommittingPeers returns the number of reachable full peers committing
+func (p *PeerHandler) CommittingPeers() int {
+	responseCh := make(chan int)
+	p.commandCh <- NewDiscoveryMsg(CommittingPeersRequestMsg, responseCh)
+	committing := <-responseCh
+	close(responseCh)
+	return committing
+}
+
 // PeerDiscoveryRoutine
 func (p *PeerHandler) peerDiscoveryRoutine() {
 	
@@ -260,6 +287,13 @@ func (p *PeerHandler) peerDiscoveryRoutine() {
 				responseCh := msg.data.(chan Status)
 				responseCh <- p.getStatus()
 
+			case SetCommittingMsg:
+				p.committing = msg.data.(bool)
+
+			case CommittingPeersRequestMsg:
+				responseCh := msg.data.(chan int)
+				responseCh <- p.countCommittingPeers()
+
 			case PeerAnnouncementMsg:
 				data := msg.data.(AnnouncementData)
 
@@ -350,7 +384,21 @@ func (p *PeerHandler) getStatus() Status {
 		BalancePort: p.BalancePort,
 		Uptime: int64(time.Since(p.startTime).Seconds()),
 		Version: p.Version,
+		Committing: p.committing,
+	}
+}
+
+// countCommittingPeers returns the number of reachable full peers committing
+func (p *PeerHandler) countCommittingPeers() int {
+	committing := 0
+	iter := p.peers.Iter()
+	for _, peer, ok := iter.Next(); ok; _, peer, ok = iter.Next() {
+		peer := peer.(*Peer)
+		if peer.Reachable() && peer.Mode() == FullMode && peer.Committing() {
+			committing += 1
+		}
 	}
+	return committing
 }
 
 // GetPeerList returns the list of currently active peers
@@ -33,6 +33,8 @@ type PeerManagerInterface interface {
 	MarkPeerUnreachable(peer string)
 	GetPeer()(string, error)
 	GetPeerPersistent(id string)(string, error)
+	SetCommitting(flag bool)
+	CommittingPeers() int
 }
 
 
@@ -146,8 +148,8 @@ func (p *PeerManager) Start() error {
 func (p *PeerManager) Stop() {
 	p.Lock()
 	if !p.started {
-		return
 		p.Unlock()
+		return
 	}
 	stopCh := p.stopCh
 	p.Unlock()
@@ -256,6 +258,31 @@ func (p *PeerManager) MarkPeerUnreachable(peer string) {
 	}
 }
 
+// SetCommitting announces to other peers this node is committing blocks
+func (p *PeerManager) SetCommitting(flag bool) {
+	p.RLock()
+	defer p.RUnlock()
+
+	if !p.started {
+		return
+	}
+	p.handler.SetCommitting(flag)
+}
+
+// CommittingPeers returns the number of reachable full peers committing
+// blocks at their last status update.
+func (p *PeerManager) CommittingPeers() int {
+	p.RLock()
+	handler := p.handler
+	started := p.started
+	p.RUnlock()
+
+	if !started {
+		return 0
+	}
+	return handler.CommittingPeers()
+}
+
 // addUnreachableMark adds a new unreachable mark and deletes expired ones,
 // to and from peer.
 func addUnreachableMark(marks *queue.Queue, period time.Duration) {
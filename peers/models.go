@@ -18,5 +18,8 @@ type Status struct {
 
 	// Software version being used
 	Version string `json:version`
+
+	// The peer is committing blocks to its db (balance requests are proxied)
+	Committing bool `json:"committing"`
 }
 
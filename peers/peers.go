@@ -84,6 +84,9 @@ type Peer struct {
 
 	// flag if the remote peer has discovered this node 
 	discovered bool	
+
+	// peer was committing blocks at the last status update
+	committing bool
 }
 
 // NewPeer
@@ -274,6 +277,15 @@ func (p *Peer) SetFromStatus(s Status) {
 	p.peerPort = s.Port
 	p.apiPort = s.BalancePort
 	p.version = s.Version
+	p.committing = s.Committing
+}
+
+// Committing returns true if the peer was committing at the last update
+func (p *Peer) Committing() bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.committing
 }
 
 // CheckStatus connects to peer to get current status
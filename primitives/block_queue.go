@@ -13,6 +13,9 @@ type txBlock struct{
 
 	// Block that contains the transaction
 	block *Block
+
+	// Position of the transaction within the block
+	position int
 }
 
 type BlockQueue struct {
@@ -23,6 +26,9 @@ type BlockQueue struct {
 	// Balance delta for the queue blocks
 	addrBalance map[string]int64
 
+	// Balance delta for each block, in the same order as blocks
+	blockBalance []map[string]int64
+
 	// Index addresses to the list of transactions that contain them 
 	addrTx map[string]*queue.Queue
 
@@ -93,15 +99,26 @@ func (b *BlockQueue)blockUpdateAddress(block *Block, reverse bool) {
 	}
 }
 
+// blockDelta returns the address balance deltas for a single block
+func blockDelta(block *Block) map[string]int64 {
+	delta := make(map[string]int64)
+	for _, tx := range block.Transactions {
+		tx.ForEachAddress(func(addr string, balance int64, tx *Tx) {
+			delta[addr] += balance
+		})
+	}
+	return delta
+}
+
 // blockUpdateTxIndex 
 func (b *BlockQueue)blockUpdateTxIndex(block *Block, reverse bool) {
 
-	for _, tx := range block.Transactions {
+	for position, tx := range block.Transactions {
 
 		if reverse {
 			delete(b.txIndex, *(tx.Hash))
 		} else {
-			b.txIndex[*tx.Hash] = txBlock{tx: tx, block: block}
+			b.txIndex[*tx.Hash] = txBlock{tx: tx, block: block, position: position}
 		}
 	}
 }
@@ -113,6 +130,7 @@ func (b *BlockQueue) PushBack(block *Block) {
 
 	// Update balance index
 	b.blockUpdateAddress(block, false)
+	b.blockBalance = append(b.blockBalance, blockDelta(block))
 	
 	// Update transaction index
 	b.blockUpdateTxIndex(block, false)
@@ -125,6 +143,7 @@ func (b *BlockQueue) PushFront(block *Block) {
 	
 	// Update balance index
 	b.blockUpdateAddress(block, false)
+	b.blockBalance = append([]map[string]int64{blockDelta(block)}, b.blockBalance...)
 	
 	// Update transaction index
 	b.blockUpdateTxIndex(block, false)
@@ -141,6 +160,9 @@ func (b *BlockQueue) PopBack() *Block {
 
 	// Update address index
 	b.blockUpdateAddress(block.(*Block), true)
+	last := len(b.blockBalance)-1
+	b.blockBalance[last] = nil
+	b.blockBalance = b.blockBalance[:last]
 	
 	// Update transaction index
 	b.blockUpdateTxIndex(block.(*Block), true)
@@ -158,6 +180,8 @@ func (b *BlockQueue) PopFront() *Block {
 
 	// Update address index
 	b.blockUpdateAddress(block.(*Block), true)
+	b.blockBalance[0] = nil
+	b.blockBalance = b.blockBalance[1:]
 	
 	// Update transaction index
 	b.blockUpdateTxIndex(block.(*Block), true)
@@ -191,6 +215,16 @@ func (b *BlockQueue) GetBalance(address string) (balance int64, ok bool) {
 	return
 }
 
+// GetBalanceMinConf returns the balance delta generated by the blocks with at
+// least minconf confirmations, the last block in the queue has 1 confirmation.
+func (b *BlockQueue) GetBalanceMinConf(address string, minconf int) int64 {
+	balance := b.addrBalance[address]
+	for n := len(b.blockBalance)-1; n >= 0 && n > len(b.blockBalance)-minconf; n-- {
+		balance -= b.blockBalance[n][address]
+	}
+	return balance
+}
+
 // GetTx returns all the transactions where the address took part
 func (b *BlockQueue) GetTx(address string) []*Tx {
 	
@@ -227,3 +261,35 @@ func (b *BlockQueue) Tx(hash chainhash.Hash) (*Tx, *Block) {
 	return nil, nil
 }
 
+// TxPosition returns the transaction, the block containing it and its
+// position within the block.
+func (b *BlockQueue) TxPosition(hash chainhash.Hash) (*Tx, *Block, int) {
+	tx, ok := b.txIndex[hash]
+	if ok {
+		return tx.tx, tx.block, tx.position
+	}
+	return nil, nil, 0
+}
+
+
+// Block returns the queued block with hash, or nil if not found
+func (b *BlockQueue) Block(hash chainhash.Hash) *Block {
+	iter := b.blocks.Iter()
+	for block, finished := iter.Next(); !finished; block, finished = iter.Next() {
+		if block.(*Block).Hash == hash {
+			return block.(*Block)
+		}
+	}
+	return nil
+}
+
+// BlockAt returns the queued block at height, or nil if not found
+func (b *BlockQueue) BlockAt(height uint64) *Block {
+	iter := b.blocks.Iter()
+	for block, finished := iter.Next(); !finished; block, finished = iter.Next() {
+		if block.(*Block).Height == height {
+			return block.(*Block)
+		}
+	}
+	return nil
+}
@@ -255,6 +255,48 @@ func TestQueueBalance(t *testing.T) {
 	}
 }
 
+// Check balance only includes blocks with enough confirmations
+func TestQueueBalanceMinConf(t *testing.T) {
+
+	block1, block2 := initBlocks()
+	addr1 := block1.Transactions[0].Out[0].Addr
+	addr2 := block2.Transactions[0].Out[0].Addr
+	queue := NewBlockQueue()
+	queue.PushBack(block1)
+	queue.PushBack(block2)
+
+	expected := []struct {
+		addr    string
+		minconf int
+		balance int64
+	}{
+		{addr1, 0, 0},
+		{addr1, 1, 0},
+		{addr1, 2, 10},
+		{addr1, 3, 0},
+		{addr2, 1, 5},
+		{addr2, 2, 0},
+	}
+	for _, e := range expected {
+		if balance := queue.GetBalanceMinConf(e.addr, e.minconf); balance != e.balance {
+			t.Errorf("GetBalanceMinConf(%v, %v): Expecting %v returned %v", e.addr, e.minconf, e.balance, balance)
+		}
+	}
+
+	// Deltas follow the queue blocks
+	queue.PopFront()
+	if balance := queue.GetBalanceMinConf(addr1, 1); balance != -10 {
+		t.Errorf("GetBalanceMinConf(%v, 1): Expecting -10 returned %v", addr1, balance)
+	}
+	if balance := queue.GetBalanceMinConf(addr1, 2); balance != 0 {
+		t.Errorf("GetBalanceMinConf(%v, 2): Expecting 0 returned %v", addr1, balance)
+	}
+	queue.PushFront(block1)
+	if balance := queue.GetBalanceMinConf(addr1, 2); balance != 10 {
+		t.Errorf("GetBalanceMinConf(%v, 2): Expecting 10 returned %v", addr1, balance)
+	}
+}
+
 func TestQueueTxIndex(t *testing.T) {
 
 	block1, block2 := initBlocks()
@@ -286,6 +328,11 @@ func TestQueueTxIndex(t *testing.T) {
 		t.Errorf("Tx(%v): Transaction wasn't indexed", *hash2)
 		return
 	}
+	last := block2.Transactions[len(block2.Transactions)-1]
+	if tx, block, position := queue.TxPosition(*last.Hash); tx != last || block != block2 ||
+		position != len(block2.Transactions)-1 {
+		t.Errorf("TxPosition(%v): Unexpected %v %v", *last.Hash, block, position)
+	}
 
 	// Remove blocks and check transactions
 	queue.PopBack()
@@ -467,3 +514,17 @@ func testQueueFront(t *testing.T) {
 }
 
 
+
+func TestQueueBlockLookup(t *testing.T) {
+	queue := NewBlockQueue()
+	block1, block2 := initBlocks()
+	queue.PushBack(block1)
+	queue.PushBack(block2)
+
+	if queue.Block(MainNetGenesisHash) != block2 || queue.Block(mockHash(3)) != nil {
+		t.Error("queue.Block(): returned unexpected block")
+	}
+	if queue.BlockAt(1) != block1 || queue.BlockAt(3) != nil {
+		t.Error("queue.BlockAt(): returned unexpected block")
+	}
+}
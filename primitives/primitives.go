@@ -6,7 +6,9 @@ import (
 	"math"
 	"bytes"
 	"fmt"
+	"time"
 
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/txscript"
@@ -62,6 +64,7 @@ type Block struct {
 	Hash         chainhash.Hash 
 	PrevHash     chainhash.Hash
 	Height       uint64
+	Time         time.Time // Block header timestamp
 
 	Transactions []*Tx
 }
@@ -103,6 +106,31 @@ func NewTx(hash *chainhash.Hash) *Tx {
 	}
 }
 
+// NewTxFromMsgTx returns the Tx for a wire.MsgTx, inputs only contain the 
+// previous output hash and number (without address and value)
+func NewTxFromMsgTx(wireTx *wire.MsgTx) *Tx {
+	hash := wireTx.TxHash()
+	tx := NewTx(&hash)
+
+	for nout, txOut := range wireTx.TxOut {
+		address := PkScriptToAddr(txOut.PkScript)
+		txout := NewTxOut(&hash, uint32(nout), address, txOut.Value)
+		if txout != nil {
+			tx.AddOut(txout)
+		}
+	}
+
+	for _, txIn := range wireTx.TxIn {
+		prevOutHash := txIn.PreviousOutPoint.Hash
+		txin := NewTxOut(&prevOutHash, uint32(txIn.PreviousOutPoint.Index), "", 0)
+		if txin != nil {
+			tx.AddIn(txin)
+		}
+	}
+
+	return tx
+}
+
 // Add Input to transaction
 func (t *Tx) AddIn(in *TxOut) {
 	t.In = append(t.In, in)
@@ -129,6 +157,22 @@ func (t *Tx) IsCoinBase() bool {
 	return true
 }
 
+// Fee returns the inputs minus outputs value (0 for coinbase transactions),
+// the inputs must have been resolved.
+func (t *Tx) Fee() int64 {
+	if t.IsCoinBase() {
+		return 0
+	}
+	var fee int64
+	for _, in := range t.In {
+		fee += in.Value
+	}
+	for _, out := range t.Out {
+		fee -= out.Value
+	}
+	return fee
+}
+
 // forEachAddress calls a function once for each address in the transaction
 // with the balance delta generated by the transaction for that address
 func (t *Tx) ForEachAddress(do func(addr string, balance int64, tx *Tx)) {
@@ -0,0 +1,282 @@
+package recent_tx
+
+import (
+	"log"
+	"sync"
+	"bytes"
+	"errors"
+	"time"
+	"context"
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/bitcoind"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/primitives"
+)
+
+const (
+	// Default max number of previous transactions retrieved from bitcoind to
+	// resolve the inputs of a single block or transaction.
+	DefaultMaxParentRequests = 10000
+
+	// Number of previous transactions retrieved concurrently
+	ParentRequestWorkers = 8
+)
+
+var (
+	ErrBlockNotFound    = errors.New("recent_tx: Block not found")
+	ErrTxNotFound       = errors.New("recent_tx: Transaction not found")
+	ErrTooManyInputs    = errors.New("recent_tx: Too many inputs to resolve")
+	ErrUnresolvedInputs = errors.New("recent_tx: Transaction inputs couldn't be resolved")
+)
+
+// BlockQuery looks up a cached block by Hash, or by Height when Hash is nil,
+// the result is the *primitives.Block or nil when not cached.
+type BlockQuery struct {
+	Hash   *chainhash.Hash
+	Height int64
+}
+
+// TxQuery looks up a cached transaction, the result is a TxResponse with the
+// transaction and its block or empty when not cached.
+type TxQuery struct {
+	Hash chainhash.Hash
+}
+
+// queryBlock returns the cached block for the query
+func (r *Indexer) queryBlock(q BlockQuery) *primitives.Block {
+	if q.Hash != nil {
+		return r.queue.Block(*q.Hash)
+	}
+	if q.Height < 0 {
+		return nil
+	}
+	return r.queue.BlockAt(uint64(q.Height))
+}
+
+// queryTx returns the cached transaction for the query
+func (r *Indexer) queryTx(q TxQuery) TxResponse {
+	tx, block, position := r.queue.TxPosition(q.Hash)
+	if tx == nil {
+		return TxResponse{}
+	}
+	return TxResponse{Tx: []*primitives.Tx{tx}, Block: []*primitives.Block{block}, Position: []int{position}}
+}
+
+// GetBlock returns a block by hash, or by height when hash is nil, and the
+// chain state when it was retrieved. Recent blocks are served from the indexer
+// and older ones from bitcoind with their inputs resolved.
+func (r *RecentTxCache) GetBlock(ctx context.Context, hash *chainhash.Hash, height int64) (*primitives.Block, block_manager.ChainState, error) {
+	result, state, err := r.manager.QueryIndexer(ctx, IndexerName, BlockQuery{Hash: hash, Height: height})
+	if err != nil {
+		return nil, state, err
+	}
+	if block := result.(*primitives.Block); block != nil {
+		return block, state, nil
+	}
+
+	if r.pool == nil || (hash == nil && (height < 0 || height > state.Height)) {
+		return nil, state, ErrBlockNotFound
+	}
+
+	if hash == nil {
+		if hash, err = r.pool.BlockHash(height); err != nil {
+			return nil, state, err
+		}
+	}
+	header, err := r.pool.BlockHeader(hash)
+	if _, ok := err.(*btcjson.RPCError); ok || (err == nil && header.Confirmations < 0) {
+		// Unknown or not in the best chain
+		return nil, state, ErrBlockNotFound
+	}
+	if err != nil {
+		return nil, state, err
+	}
+
+	msgBlock, err := r.pool.Block(hash)
+	if err != nil {
+		return nil, state, err
+	}
+	block := primitives.NewBlock(*hash, msgBlock.Header.PrevBlock, uint64(header.Height))
+	block.Time = msgBlock.Header.Timestamp
+	for _, wireTx := range msgBlock.Transactions {
+		block.AddTx(primitives.NewTxFromMsgTx(wireTx))
+	}
+
+	if err := r.resolveInputs(ctx, block.Transactions); err != nil {
+		return nil, state, err
+	}
+	return block, state, nil
+}
+
+// GetTx returns a transaction, the block containing it (nil while in the
+// mempool) and the chain state when it was retrieved. Transactions in recent
+// blocks are served from the indexer and the rest from bitcoind, which must
+// have txindex enabled.
+func (r *RecentTxCache) GetTx(ctx context.Context, hash chainhash.Hash) (*primitives.Tx, *primitives.Block, block_manager.ChainState, error) {
+	result, state, err := r.manager.QueryIndexer(ctx, IndexerName, TxQuery{Hash: hash})
+	if err != nil {
+		return nil, nil, state, err
+	}
+	if response := result.(TxResponse); len(response.Tx) > 0 {
+		return response.Tx[0], response.Block[0], state, nil
+	}
+
+	if r.pool == nil {
+		return nil, nil, state, ErrTxNotFound
+	}
+
+	rawTx, err := r.pool.RawTransactionVerbose(&hash)
+	if _, ok := err.(*btcjson.RPCError); ok {
+		return nil, nil, state, ErrTxNotFound
+	}
+	if err != nil {
+		return nil, nil, state, err
+	}
+
+	serialized, err := hex.DecodeString(rawTx.Hex)
+	if err != nil {
+		return nil, nil, state, err
+	}
+	var wireTx wire.MsgTx
+	if err := wireTx.Deserialize(bytes.NewReader(serialized)); err != nil {
+		return nil, nil, state, err
+	}
+	tx := primitives.NewTxFromMsgTx(&wireTx)
+	if err := r.resolveInputs(ctx, []*primitives.Tx{tx}); err != nil {
+		return nil, nil, state, err
+	}
+
+	// Unconfirmed
+	if rawTx.BlockHash == "" {
+		return tx, nil, state, nil
+	}
+
+	blockHash, err := chainhash.NewHashFromStr(rawTx.BlockHash)
+	if err != nil {
+		return nil, nil, state, err
+	}
+	header, err := r.pool.BlockHeader(blockHash)
+	if err != nil {
+		return nil, nil, state, err
+	}
+	block := primitives.NewBlock(*blockHash, primitives.ZeroHash, uint64(header.Height))
+	block.Time = time.Unix(header.Time, 0)
+	return tx, block, state, nil
+}
+
+// resolveInputs populates the inputs address and value, from transactions
+// within txs, then the block manager (unspent outputs) and finally retrieving
+// the previous transactions from bitcoind.
+func (r *RecentTxCache) resolveInputs(ctx context.Context, txs []*primitives.Tx) error {
+	local := make(map[chainhash.Hash]*primitives.Tx, len(txs))
+	for _, tx := range txs {
+		local[*tx.Hash] = tx
+	}
+
+	unresolved := make([]*primitives.TxOut, 0)
+	for _, tx := range txs {
+		if tx.IsCoinBase() {
+			continue
+		}
+		for _, in := range tx.In {
+			if parent, ok := local[*in.TxHash]; ok && int(in.Nout) < len(parent.Out) {
+				in.Addr  = parent.Out[in.Nout].Addr
+				in.Value = parent.Out[in.Nout].Value
+			} else {
+				unresolved = append(unresolved, in)
+			}
+		}
+	}
+
+	if err := r.manager.ResolveTxOuts(ctx, unresolved); err != nil {
+		return err
+	}
+
+	// Spent outputs are only available from bitcoind
+	parents := make(map[chainhash.Hash]*wire.MsgTx)
+	for _, in := range unresolved {
+		if in.Value == 0 && in.Addr == "" {
+			parents[*in.TxHash] = nil
+		}
+	}
+	if len(parents) > r.MaxParentRequests {
+		return ErrTooManyInputs
+	}
+	if err := r.fetchParents(ctx, parents); err != nil {
+		return err
+	}
+
+	for _, in := range unresolved {
+		if in.Value != 0 || in.Addr != "" {
+			continue
+		}
+		parent := parents[*in.TxHash]
+		if int(in.Nout) >= len(parent.TxOut) {
+			return ErrUnresolvedInputs
+		}
+		in.Addr  = primitives.PkScriptToAddr(parent.TxOut[in.Nout].PkScript)
+		in.Value = parent.TxOut[in.Nout].Value
+	}
+	return nil
+}
+
+// fetchParents retrieves from bitcoind the transactions in parents with up to
+// ParentRequestWorkers concurrent requests, it stops at the first failure.
+func (r *RecentTxCache) fetchParents(ctx context.Context, parents map[chainhash.Hash]*wire.MsgTx) error {
+	hashes := make([]chainhash.Hash, 0, len(parents))
+	for hash := range parents {
+		hashes = append(hashes, hash)
+	}
+
+	var mutex sync.Mutex
+	var failed error
+	fetched := make([]*wire.MsgTx, len(hashes))
+	sem := make(chan struct{}, ParentRequestWorkers)
+	var wg sync.WaitGroup
+
+	for n := range hashes {
+		mutex.Lock()
+		stop := failed != nil
+		mutex.Unlock()
+		if stop || ctx.Err() != nil {
+			break
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(n int) {
+			defer func() { <-sem; wg.Done() }()
+			tx, err := r.pool.RawTransaction(&hashes[n])
+			if err != nil {
+				mutex.Lock()
+				if failed == nil {
+					failed = err
+				}
+				mutex.Unlock()
+				return
+			}
+			fetched[n] = tx
+		}(n)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed != nil {
+		if failed == bitcoind.ErrNoBackendAvailable {
+			return failed
+		}
+		log.Print("recent_tx: Resolving inputs: ", failed)
+		return ErrUnresolvedInputs
+	}
+
+	for n, hash := range hashes {
+		parents[hash] = fetched[n]
+	}
+	return nil
+}
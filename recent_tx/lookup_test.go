@@ -0,0 +1,85 @@
+package recent_tx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/bitcoind"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/primitives"
+)
+
+// mockManager forwards the indexer queries to indexer, other methods aren't
+// used
+type mockManager struct {
+	block_manager.BlockManagerInterface
+	indexer *Indexer
+	state   block_manager.ChainState
+}
+
+func (m *mockManager) QueryIndexer(ctx context.Context, name string, query interface{}) (interface{}, block_manager.ChainState, error) {
+	result, err := m.indexer.Query(ctx, query)
+	return result, m.state, err
+}
+
+func (m *mockManager) ResolveTxOuts(ctx context.Context, outs []*primitives.TxOut) error {
+	return nil
+}
+
+func TestLookup(t *testing.T) {
+	tx1 := mockTx(1, primitives.NewTxOut(&chainhash.Hash{0xa0}, 0, "other", 110), "addr1", 100)
+	block1 := mockBlock(1, tx1)
+	block2 := mockBlock(2)
+
+	indexer := NewIndexer(10)
+	indexer.ConnectBlock(block1)
+	indexer.ConnectBlock(block2)
+	manager := &mockManager {
+		indexer: indexer,
+		state:   block_manager.ChainState{Height: 2, Hash: block2.Hash},
+	}
+	cache := NewRecentTxCache(manager, nil)
+	ctx := context.Background()
+
+	// Blocks by height and hash
+	if block, state, err := cache.GetBlock(ctx, nil, 1); err != nil || block != block1 || state != manager.state {
+		t.Errorf("GetBlock(): Unexpected block at height 1 %v %v %v", block, state, err)
+	}
+	if block, _, err := cache.GetBlock(ctx, &block2.Hash, -1); err != nil || block != block2 {
+		t.Errorf("GetBlock(): Unexpected block %v %v", block, err)
+	}
+	if _, _, err := cache.GetBlock(ctx, nil, 3); err != ErrBlockNotFound {
+		t.Errorf("GetBlock(): Expecting ErrBlockNotFound returned %v", err)
+	}
+	if _, _, err := cache.GetBlock(ctx, &chainhash.Hash{9}, -1); err != ErrBlockNotFound {
+		t.Errorf("GetBlock(): Expecting ErrBlockNotFound returned %v", err)
+	}
+
+	// Transactions
+	tx, block, _, err := cache.GetTx(ctx, *tx1.Hash)
+	if err != nil || tx != tx1 || block != block1 {
+		t.Errorf("GetTx(): Unexpected %v %v %v", tx, block, err)
+	}
+	if tx.Fee() != 10 {
+		t.Errorf("Fee(): Expecting 10 returned %v", tx.Fee())
+	}
+	if _, _, _, err := cache.GetTx(ctx, chainhash.Hash{9}); err != ErrTxNotFound {
+		t.Errorf("GetTx(): Expecting ErrTxNotFound returned %v", err)
+	}
+}
+
+// Test inputs that can't be resolved from bitcoind fail the lookup
+func TestResolveInputsErrors(t *testing.T) {
+	tx := mockTx(1, primitives.NewTxOut(&chainhash.Hash{0xa0}, 0, "", 0), "addr1", 100)
+	cache := NewRecentTxCache(&mockManager{}, &bitcoind.Pool{})
+
+	if err := cache.resolveInputs(context.Background(), []*primitives.Tx{tx}); err != bitcoind.ErrNoBackendAvailable {
+		t.Errorf("resolveInputs(): Expecting ErrNoBackendAvailable returned %v", err)
+	}
+
+	cache.MaxParentRequests = 0
+	if err := cache.resolveInputs(context.Background(), []*primitives.Tx{tx}); err != ErrTooManyInputs {
+		t.Errorf("resolveInputs(): Expecting ErrTooManyInputs returned %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package recent_tx
+
+import (
+	"sort"
+	"errors"
+	"context"
+
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/primitives"
+)
+
+// Transaction direction relative to the queried address
+const (
+	// All transactions
+	DirectionAll = ""
+
+	// Transactions increasing the address balance
+	DirectionIn  = "in"
+
+	// Transactions decreasing the address balance
+	DirectionOut = "out"
+)
+
+var ErrInvalidDirection = errors.New("recent_tx: Invalid direction")
+
+// Query selects a page of recent transactions for Address, newest first
+type Query struct {
+	Address   string
+
+	// One of DirectionAll, DirectionIn or DirectionOut
+	Direction string
+
+	// Cursor returned with the previous page (empty for the first one)
+	Cursor    string
+
+	// Max number of transactions returned
+	Limit     int
+}
+
+// RecentTx is a recent transaction with the block containing it
+type RecentTx struct {
+	Tx    *primitives.Tx
+	Block *primitives.Block
+
+	// Position of the transaction within its block
+	Position int
+
+	// Inputs minus outputs value (0 for coinbase transactions)
+	Fee int64
+
+	// Balance change for the queried address
+	Delta int64
+}
+
+// Page of recent transactions
+type Page struct {
+	Txs    []RecentTx
+
+	// Cursor for the next page, empty when there are no more transactions
+	Cursor string
+}
+
+// newRecentTx computes the transaction fee and delta for address
+func newRecentTx(tx *primitives.Tx, block *primitives.Block, position int, address string) RecentTx {
+	rtx := RecentTx{Tx: tx, Block: block, Position: position, Fee: tx.Fee()}
+	for _, txIn := range tx.In {
+		if txIn.Addr == address {
+			rtx.Delta -= txIn.Value
+		}
+	}
+	for _, txOut := range tx.Out {
+		if txOut.Addr == address {
+			rtx.Delta += txOut.Value
+		}
+	}
+	return rtx
+}
+
+// newPage returns the query page from the indexer response
+func newPage(response TxResponse, query Query) (Page, error) {
+	switch query.Direction {
+	case DirectionAll, DirectionIn, DirectionOut:
+	default:
+		return Page{}, ErrInvalidDirection
+	}
+
+	var cursorHeight int64
+	var cursorPosition int
+	if query.Cursor != "" {
+		var err error
+		if cursorHeight, cursorPosition, err = block_manager.DecodeCursor(query.Cursor); err != nil {
+			return Page{}, err
+		}
+	}
+
+	txs := make([]RecentTx, 0, len(response.Tx))
+	for n, tx := range response.Tx {
+		rtx := newRecentTx(tx, response.Block[n], response.Position[n], query.Address)
+		switch {
+		case query.Direction == DirectionIn && rtx.Delta <= 0:
+			continue
+		case query.Direction == DirectionOut && rtx.Delta >= 0:
+			continue
+		}
+		txs = append(txs, rtx)
+	}
+
+	// Newest first
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].Block.Height != txs[j].Block.Height {
+			return txs[i].Block.Height > txs[j].Block.Height
+		}
+		return txs[i].Position > txs[j].Position
+	})
+
+	// Transactions before the cursor
+	if query.Cursor != "" {
+		start := sort.Search(len(txs), func(n int) bool {
+			height := int64(txs[n].Block.Height)
+			return height < cursorHeight || (height == cursorHeight && txs[n].Position < cursorPosition)
+		})
+		txs = txs[start:]
+	}
+
+	page := Page{Txs: txs}
+	if query.Limit > 0 && len(txs) > query.Limit {
+		page.Txs    = txs[:query.Limit]
+		last := page.Txs[query.Limit-1]
+		page.Cursor = block_manager.EncodeCursor(int64(last.Block.Height), last.Position)
+	}
+	return page, nil
+}
+
+// GetRecentTxPage returns a page of the address recent transactions and the
+// chain state they were retrieved at.
+func (r *RecentTxCache) GetRecentTxPage(ctx context.Context, query Query) (Page, block_manager.ChainState, error) {
+	response, state, err := r.GetRecentTxState(ctx, query.Address)
+	if err != nil {
+		return Page{}, state, err
+	}
+	page, err := newPage(response, query)
+	return page, state, err
+}
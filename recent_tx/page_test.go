@@ -0,0 +1,83 @@
+package recent_tx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/primitives"
+)
+
+// mockTx returns a transaction spending in and paying value to address
+func mockTx(id byte, in *primitives.TxOut, address string, value int64) *primitives.Tx {
+	hash := chainhash.Hash{id}
+	tx := primitives.NewTx(&hash)
+	tx.AddIn(in)
+	tx.AddOut(primitives.NewTxOut(&hash, 0, address, value))
+	return tx
+}
+
+// mockBlock returns a block with a coinbase followed by txs
+func mockBlock(height uint64, txs ...*primitives.Tx) *primitives.Block {
+	block := primitives.NewBlock(chainhash.Hash{byte(height), 0xff}, primitives.ZeroHash, height)
+	coinbaseHash := chainhash.Hash{byte(height), 0xfe}
+	coinbase := primitives.NewTx(&coinbaseHash)
+	coinbase.AddIn(primitives.NewTxOut(&primitives.ZeroHash, 0xffffffff, "", 0))
+	block.AddTx(coinbase)
+	for _, tx := range txs {
+		block.AddTx(tx)
+	}
+	return block
+}
+
+func TestRecentTxPage(t *testing.T) {
+	// addr1 receives 100 in block 1, sends 60 in block 2 and receives 5
+	// later in the same block
+	tx1 := mockTx(1, primitives.NewTxOut(&chainhash.Hash{0xa0}, 0, "other", 110), "addr1", 100)
+	tx2 := mockTx(2, primitives.NewTxOut(tx1.Hash, 0, "addr1", 100), "other", 60)
+	tx2.AddOut(primitives.NewTxOut(tx2.Hash, 1, "addr1", 39))
+	tx3 := mockTx(3, primitives.NewTxOut(&chainhash.Hash{0xa1}, 0, "other", 6), "addr1", 5)
+	block1 := mockBlock(1, tx1)
+	block2 := mockBlock(2, tx2, tx3)
+
+	indexer := NewIndexer(10)
+	indexer.ConnectBlock(block1)
+	indexer.ConnectBlock(block2)
+	result, _ := indexer.Query(context.Background(), "addr1")
+	response := result.(TxResponse)
+
+	page, err := newPage(response, Query{Address: "addr1", Limit: 2})
+	if err != nil {
+		t.Fatal("newPage(): ", err)
+	}
+	if len(page.Txs) != 2 || page.Txs[0].Tx != tx3 || page.Txs[1].Tx != tx2 || page.Cursor != "2:1" {
+		t.Fatalf("newPage(): Unexpected first page %v", page)
+	}
+	if page.Txs[1].Fee != 1 || page.Txs[1].Delta != -61 || page.Txs[0].Position != 2 {
+		t.Errorf("newPage(): Unexpected tx details %+v", page.Txs[1])
+	}
+
+	page, err = newPage(response, Query{Address: "addr1", Cursor: page.Cursor, Limit: 2})
+	if err != nil || len(page.Txs) != 1 || page.Txs[0].Tx != tx1 || page.Cursor != "" {
+		t.Errorf("newPage(): Unexpected last page %v %v", page, err)
+	}
+
+	// Direction filters
+	page, _ = newPage(response, Query{Address: "addr1", Direction: DirectionIn})
+	if len(page.Txs) != 2 || page.Txs[0].Tx != tx3 || page.Txs[1].Tx != tx1 {
+		t.Errorf("newPage(): Unexpected incoming txs %v", page.Txs)
+	}
+	page, _ = newPage(response, Query{Address: "addr1", Direction: DirectionOut})
+	if len(page.Txs) != 1 || page.Txs[0].Tx != tx2 {
+		t.Errorf("newPage(): Unexpected outgoing txs %v", page.Txs)
+	}
+
+	// Invalid queries
+	if _, err := newPage(response, Query{Address: "addr1", Direction: "sideways"}); err != ErrInvalidDirection {
+		t.Errorf("newPage(): Expecting ErrInvalidDirection returned %v", err)
+	}
+	if _, err := newPage(response, Query{Address: "addr1", Cursor: "2-1"}); err != block_manager.ErrInvalidCursor {
+		t.Errorf("newPage(): Expecting ErrInvalidCursor returned %v", err)
+	}
+}
@@ -1,139 +1,145 @@
 package recent_tx
 
 import (
+	"context"
+	"errors"
+
+	"github.com/secnot/gobalance/bitcoind"
 	"github.com/secnot/gobalance/block_manager"
+	"github.com/secnot/gobalance/block_manager/storage"
 	"github.com/secnot/gobalance/primitives"
 )
 
+const (
+	// Name used to register and query the indexer
+	IndexerName = "recent_tx"
+)
+
+var ErrInvalidQuery = errors.New("recent_tx: Invalid query")
+
 type TxResponse struct {
 	Tx []*primitives.Tx
 	Block []*primitives.Block
-}
 
-type TxRequest struct {
-	Address string
-	Response chan TxResponse
+	// Position of each transaction within its block
+	Position []int
 }
 
 
-type RecentTxCache struct {
+// Indexer keeps in memory the transactions for the last blocks, it is the
+// built-in block manager indexer used by the recent transactions API.
+type Indexer struct {
 
 	// max number of blocks cached
 	size uint16
 
-	// 
+	//
 	queue *primitives.BlockQueue
-
-	// block manager
-	manager block_manager.BlockManagerInterface
-	
-	// transaction requests
-	requestChan chan TxRequest
-
-	// signal to stop cache
-	stopChan chan chan bool
 }
 
-
-// NewRecentTxCache 
-func NewRecentTxCache(manager block_manager.BlockManagerInterface, trackedBlocks uint16) *RecentTxCache {
-	cache := &RecentTxCache{
-		requestChan: make(chan TxRequest),
-		stopChan:    make(chan chan bool),
-		manager:     manager,
-		queue:       primitives.NewBlockQueue(),
-		size:        trackedBlocks,
+// NewIndexer returns an indexer tracking the last trackedBlocks
+func NewIndexer(trackedBlocks uint16) *Indexer {
+	return &Indexer {
+		size:  trackedBlocks,
+		queue: primitives.NewBlockQueue(),
 	}
+}
 
-	go cache.recentTxRoutine()
-	return cache
+func (r *Indexer) Name() string {
+	return IndexerName
 }
 
-// backtrackBlock
-func (r *RecentTxCache) backtrackBlock(block *primitives.Block) {
-	r.queue.PopBack()
+// Init does nothing, the index is only kept in memory
+func (r *Indexer) Init(sto storage.Storage, height int64) error {
+	return nil
 }
 
-// newBlock
-func (r *RecentTxCache) newBlock(block *primitives.Block) {
+// ConnectBlock
+func (r *Indexer) ConnectBlock(block *primitives.Block) error {
 	r.queue.PushBack(block)
 	if r.queue.Len() > int(r.size) {
 		r.queue.PopFront()
 	}
+	return nil
 }
 
+// DisconnectBlock
+func (r *Indexer) DisconnectBlock(block *primitives.Block) error {
+	r.queue.PopBack()
+	return nil
+}
 
-// GetAddrRecentTx returns the transactions with in the cached blocks that 
-// cointaned the address, together with the blocks containing thos transactions
-func (r *RecentTxCache) getAddrRecentTx(address string) ([] *primitives.Tx, [] *primitives.Block) {
+// Commit does nothing, the index is only kept in memory
+func (r *Indexer) Commit(tx storage.Tx, height int64) error {
+	return nil
+}
+
+// Query returns a TxResponse with the transactions within the cached blocks
+// that contained the address (query), together with the blocks containing them.
+// BlockQuery and TxQuery lookup a cached block or transaction.
+func (r *Indexer) Query(ctx context.Context, query interface{}) (interface{}, error) {
+	switch q := query.(type) {
+	case BlockQuery:
+		return r.queryBlock(q), nil
+	case TxQuery:
+		return r.queryTx(q), nil
+	}
+
+	address, ok := query.(string)
+	if !ok {
+		return nil, ErrInvalidQuery
+	}
 
-	// TODO: Use getPeer when in commit mode.
 	transactions := r.queue.GetTx(address)
 
 	// Get the blocks containing each transaction
-	blocks := make([]*primitives.Block, len(transactions))
+	blocks    := make([]*primitives.Block, len(transactions))
+	positions := make([]int, len(transactions))
 	for n, tx := range transactions {
-		_, block := r.queue.Tx(*tx.Hash)
-		blocks[n] = block
+		_, blocks[n], positions[n] = r.queue.TxPosition(*tx.Hash)
 	}
 
-	return transactions, blocks
+	return TxResponse{Tx: transactions, Block: blocks, Position: positions}, nil
 }
 
 
+// RecentTxCache queries the recent transactions indexer registered in the
+// block manager.
+type RecentTxCache struct {
 
-// RecentTx is the routine handling block updates and client requests
-func (r *RecentTxCache) recentTxRoutine() {
-	
-	//
-	updatesChan  := r.manager.Subscribe(10)
-
-	//proxy := false
-	for {
-
-		select {
-		case update := <- updatesChan:
-			
-			switch update.Class {
-			case block_manager.OP_NEWBLOCK:
-				r.newBlock(update.Block)
-			case block_manager.OP_BACKTRACK:
-				r.backtrackBlock(update.Block)
-			
-			/* TODO: Request transactions from proxy when in commit
-			case block_manager.OP_COMMIT:
-				proxy = true
-			case block_manager.OP_COMMIT_DONE:
-				proxy = false
-			*/
-			}
-
-		case request := <- r.requestChan:
-			tx, blocks := r.getAddrRecentTx(request.Address)
-			request.Response <- TxResponse{Tx: tx, Block: blocks}
-		
-		case ch := <- r.stopChan:
-			close(r.stopChan)
-			close(r.requestChan)
-			ch <- true
-			return
-		}
-	}
+	// block manager
+	manager block_manager.BlockManagerInterface
+
+	// bitcoind used to lookup blocks and transactions no longer cached (nil
+	// to disable)
+	pool *bitcoind.Pool
+
+	// Max number of previous transactions retrieved from bitcoind to
+	// resolve the inputs of a single lookup, larger ones are rejected.
+	MaxParentRequests int
 }
 
-func (r *RecentTxCache) Stop() {
-	doneCh := make(chan bool)
+// NewRecentTxCache, the manager must have been started with an Indexer and
+// pool can be nil when only the cached blocks can be looked up.
+func NewRecentTxCache(manager block_manager.BlockManagerInterface, pool *bitcoind.Pool) *RecentTxCache {
+	return &RecentTxCache {
+		manager:           manager,
+		pool:              pool,
+		MaxParentRequests: DefaultMaxParentRequests,
+	}
+}
 
-	r.stopChan <- doneCh
-	<- doneCh
-	close(doneCh)
+func (r *RecentTxCache) GetRecentTx(ctx context.Context, address string) ([]*primitives.Tx, []*primitives.Block, error) {
+	response, _, err := r.GetRecentTxState(ctx, address)
+	return response.Tx, response.Block, err
 }
 
-func (r *RecentTxCache) GetRecentTx(address string) ([]*primitives.Tx, []*primitives.Block, error) {
-	responseCh := make(chan TxResponse)
-	
-	r.requestChan <- TxRequest{Address: address, Response: responseCh}
-	response := <- responseCh
-	close(responseCh)
-	return response.Tx, response.Block, nil
+// GetRecentTxState returns the address recent transactions and the chain
+// state they were retrieved at.
+func (r *RecentTxCache) GetRecentTxState(ctx context.Context, address string) (TxResponse, block_manager.ChainState, error) {
+	result, state, err := r.manager.QueryIndexer(ctx, IndexerName, address)
+	if err != nil {
+		return TxResponse{}, state, err
+	}
+	return result.(TxResponse), state, nil
 }